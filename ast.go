@@ -9,30 +9,64 @@
 // the tree once it is returned from the parser.
 package dsl
 
-import (
-	"fmt"
-)
-
 // RootNode is the entry point to the tree. curNode is used internally
-// to keep track of where the next node should be added.
+// to keep track of where the next node should be added. events replaces the
+// tree when parsing with WithEvents. nextID is the ID the next node added is
+// given.
 type AST struct {
-	RootNode *Node `json:"root"`
-	curNode  *Node `json:"-"`
+	RootNode *Node        `json:"root"`
+	curNode  *Node        `json:"-"`
+	events   *eventStream `json:"-"`
+	nextID   int          `json:"-"`
 }
 
+// ASTToken is the part of a Token kept in the AST. Line and Position are
+// where the token was found in the source text. They are left out of the
+// JSON encoding, which keeps the shape it had before positions were kept.
+//
+// Leading and Trailing hold the trivia around the token when the AST was
+// parsed with WithTrivia.
 type ASTToken struct {
-	ID      TokenType `json:"ID"`
-	Literal string    `json:"Literal"`
+	ID       TokenType `json:"ID"`
+	Literal  string    `json:"Literal"`
+	Line     int       `json:"-"`
+	Position int       `json:"-"`
+	Leading  string    `json:"Leading,omitempty"`
+	Trailing string    `json:"Trailing,omitempty"`
+	// Value is the value the scan function gave the token, such as the
+	// number a numeric literal stands for, see Scanner.Convert. It is
+	// transient: the JSON, S-expression and binary encodings all drop it,
+	// so it is nil in a decoded tree and converting the literal again gives
+	// it back. As it may hold a value that is not comparable, compare
+	// tokens with reflect.DeepEqual rather than ==.
+	Value interface{} `json:"-"`
 }
 
 // A Node can contain multiple Tokens which can be useful if the user knows how
 // many Tokens belong to a particular Node type. Otherwise, the user should only
 // add one token per node.
+//
+// Hidden holds any hidden tokens, such as comments, read while the node was
+// the current node of the parser. See Parser.HideTokens.
+//
+// Comments holds the comments describing the node once they have been
+// attached with AST.AttachComments or WithComments.
+//
+// Errors holds any errors found while the node was the current node of the
+// parser, so tools can point at the part of the tree that failed to parse.
+//
+// ID identifies the node within its AST and Attrs holds the attributes set
+// on it by later passes, see Attr.
 type Node struct {
+	ID       int        `json:"id,omitempty"`
 	Type     NodeType   `json:"type"`
 	Tokens   []ASTToken `json:"tokens"`
+	Hidden   []ASTToken `json:"hidden,omitempty"`
+	Comments []Comment  `json:"comments,omitempty"`
 	Parent   *Node      `json:"-"`
 	Children []Node     `json:"children"`
+	Errors   []Error    `json:"errors,omitempty"`
+	Attrs    Attributes `json:"attrs,omitempty"`
 }
 
 type NodeType string
@@ -54,59 +88,35 @@ func (a *AST) Inspect(fn func(*Node)) {
 	visit(a.RootNode, fn)
 }
 
-// Prints the entire AST tree. It does so by recursively calling Print() on
-// each node in the tree in a depth first approach.
-func (a *AST) Print() {
-	a.RootNode.Print("", true)
-	fmt.Println()
-}
-
-// Print is a recursive function that keeps track of where in the tree the node
-// belongs to so it can print a pretty prefix. The prefix indicates how deep the
-// node is and if it is the last node at that level.
-//
-// A user can print the entire tree using AST.Print() or only print a sub-branch
-// by calling Print() on any node in the tree.
-func (n *Node) Print(prefix string, isTail bool) {
-	fmt.Printf("\n%v", prefix)
-	if isTail {
-		fmt.Printf("└── ")
-	} else {
-		fmt.Printf("├── ")
-	}
-	fmt.Printf("%v - ", n.Type)
-	for _, token := range n.Tokens {
-		for _, rn := range token.Literal {
-			fmt.Print(string(rn))
-		}
-		fmt.Print(", ")
-	}
-	numNodes := len(n.Children)
-	if numNodes > 0 {
-		for _, node := range n.Children[:numNodes-1] {
-			if isTail {
-				node.Print(prefix+"    ", false)
-			} else {
-				node.Print(prefix+"│   ", false)
-			}
-		}
-	}
-	if numNodes > 0 {
-		if isTail {
-			n.Children[numNodes-1].Print(prefix+"    ", true)
-		} else {
-			n.Children[numNodes-1].Print(prefix+"│   ", true)
-		}
-	}
-}
-
 // ---------------------------------------------------------------------------------------------------------
 
 // newAST returns a new instance of AST. The RootNode has the
 // builtin node type AST_ROOT.
 func newAST() AST {
-	rootNode := &Node{Type: NODE_ROOT}
-	return AST{RootNode: rootNode, curNode: rootNode}
+	rootNode := &Node{ID: 1, Type: NODE_ROOT}
+	return AST{RootNode: rootNode, curNode: rootNode, nextID: 2}
+}
+
+// setRoot replaces the tree with a decoded one, rebuilding the Parent
+// references, numbering any nodes decoded without an ID and moving curNode
+// back to the root.
+func (a *AST) setRoot(root *Node) {
+	root.Parent = nil
+	linkParents(root)
+	a.RootNode = root
+	a.curNode = root
+	a.nextID = 0
+	a.numberNodes(root)
+}
+
+// linkParents sets the Parent reference of every descendant of n. Children
+// are stored by value so the references must be rebuilt whenever the child
+// slices are created or moved, such as after decoding.
+func linkParents(n *Node) {
+	for i := range n.Children {
+		n.Children[i].Parent = n
+		linkParents(&n.Children[i])
+	}
 }
 
 func visit(node *Node, fn func(*Node)) {
@@ -119,8 +129,19 @@ func visit(node *Node, fn func(*Node)) {
 // Called by Parser.AddNode() in the user parse function. Creates a new node and
 // builds the two-way reference to its parent. Also moves the AST curNode
 // down the tree to the new node.
+//
+// An AST without a root, as used by ParseTyped, drops the nodes and tokens
+// added to it.
 func (a *AST) addNode(nt NodeType) {
-	a.curNode.Children = append(a.curNode.Children, Node{Type: nt, Parent: a.curNode})
+	if a.events != nil {
+		a.events.startNode(nt)
+		return
+	}
+	if a.curNode == nil {
+		return
+	}
+	a.curNode.Children = append(a.curNode.Children, Node{ID: a.nextID, Type: nt, Parent: a.curNode})
+	a.nextID++
 	a.curNode = &a.curNode.Children[len(a.curNode.Children)-1]
 }
 
@@ -130,17 +151,58 @@ func (a *AST) addNode(nt NodeType) {
 // If Parser.AddToken() is called without any tokens available on the Parser.toks buffer
 // the call to AddToken will be logged but no tokens will be added to the node.
 func (a *AST) addToken(toks []Token) {
+	if a.events != nil {
+		for _, tok := range toks {
+			a.events.token(EventToken, tok)
+		}
+		return
+	}
+	if a.curNode == nil {
+		return
+	}
 
 	for _, tok := range toks {
-		a.curNode.Tokens = append(a.curNode.Tokens, ASTToken{ID: tok.ID, Literal: tok.Literal})
+		a.curNode.Tokens = append(a.curNode.Tokens, newASTToken(tok))
+	}
+
+}
+
+// Called by the Parser whenever a hidden token is read. The token is
+// attached to the current node.
+func (a *AST) addHidden(tok Token) {
+	if a.events != nil {
+		a.events.token(EventHidden, tok)
+		return
 	}
+	if a.curNode != nil {
+		a.curNode.Hidden = append(a.curNode.Hidden, newASTToken(tok))
+	}
+}
 
+func newASTToken(tok Token) ASTToken {
+	return ASTToken{ID: tok.ID, Literal: tok.Literal, Line: tok.Line, Position: tok.Position, Leading: tok.Leading, Trailing: tok.Trailing, Value: tok.Value}
+}
+
+// Called by the Parser whenever an error is found. The error is attached to
+// the current node.
+func (a *AST) addError(err Error) {
+	if a.events != nil {
+		a.events.error(err)
+		return
+	}
+	if a.curNode != nil {
+		a.curNode.Errors = append(a.curNode.Errors, err)
+	}
 }
 
 // Called by Parser.WalkUp() in the user parse function. Moves the AST
 // curNode to its parent.
 func (a *AST) walkUp() {
-	if a.curNode.Type != NODE_ROOT {
+	if a.events != nil {
+		a.events.endNode()
+		return
+	}
+	if a.curNode != nil && a.curNode.Type != NODE_ROOT {
 		a.curNode = a.curNode.Parent
 	}
 }
@@ -0,0 +1,170 @@
+// attr.go implements node IDs and attributes, which let analysis passes run
+// after the parse annotate the tree, for example with the type of an
+// expression or the declaration a name resolves to, without keeping side
+// tables keyed by *Node. Children are held by value, so such pointers change
+// whenever a tree is copied, decoded or reparsed by Document.Edit.
+//
+// Every node added by the parser is given an ID, numbered in the order the
+// nodes are added, which is preorder, starting at 1 for the root. IDs are
+// kept by the JSON and binary encodings, and Document.Edit keeps the IDs and
+// attributes of the root and the nodes it reuses, giving new IDs to the nodes
+// it reparses.
+//
+// Attributes are read and written through typed keys, so a pass can only
+// store and get back values of the type the key was declared with:
+//
+//	var typeOf = dsl.NewAttr[Type]("type")
+//
+//	typeOf.Set(n, Int)
+//	if t, ok := typeOf.Get(n); ok {
+//		...
+//	}
+//
+// Attributes are encoded as JSON by every AST encoding, so their values
+// must marshal to JSON for the tree to be encoded. A decoded attribute holds
+// the JSON until Get unmarshals it into the type of the key.
+
+package dsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Attributes holds the attributes of a node by name. Values are set and read
+// with an Attr key of the matching type. The nodes of a tree returned by
+// Document.Edit or StreamParser.Feed have attributes of their own, so setting
+// one does not change the node it was copied from.
+type Attributes map[string]interface{}
+
+// clone returns a copy of the attributes, or nil if there are none.
+func (a Attributes) clone() Attributes {
+	if a == nil {
+		return nil
+	}
+	c := make(Attributes, len(a))
+	for name, v := range a {
+		c[name] = v
+	}
+	return c
+}
+
+// UnmarshalJSON implements json.Unmarshaler, keeping each value as its JSON
+// so that Attr.Get can decode it into the type of the key.
+func (a *Attributes) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*a = make(Attributes, len(raw))
+	for name, v := range raw {
+		(*a)[name] = v
+	}
+	return nil
+}
+
+// names returns the attribute names in sorted order, so that encodings are
+// deterministic.
+func (a Attributes) names() []string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// marshal returns the value of the named attribute as JSON.
+func (a Attributes) marshal(name string) ([]byte, error) {
+	b, err := json.Marshal(a[name])
+	if err != nil {
+		return nil, fmt.Errorf("dsl: attribute %v: %w", name, err)
+	}
+	return b, nil
+}
+
+// Attr is a typed key for a node attribute. Keys are compared by name, so
+// two keys with the same name refer to the same attribute and should be
+// declared with the same type.
+type Attr[T any] struct {
+	name string
+}
+
+// NewAttr returns a key for the attribute with the given name, holding values
+// of type T.
+func NewAttr[T any](name string) Attr[T] {
+	return Attr[T]{name: name}
+}
+
+// Name returns the name the attribute is stored under.
+func (k Attr[T]) Name() string {
+	return k.name
+}
+
+// Get returns the value of the attribute on the node, and whether the node
+// has it. An attribute decoded with the AST is unmarshalled from its JSON
+// into T on each call; if that fails, or the value held is not a T, Get
+// reports that the node does not have the attribute.
+func (k Attr[T]) Get(n *Node) (T, bool) {
+	var v T
+	// A decoded value is checked for first, as the JSON is itself a T when T
+	// is an interface type such as interface{}
+	switch found := n.Attrs[k.name].(type) {
+	case nil:
+		return v, false
+	case json.RawMessage:
+		if err := json.Unmarshal(found, &v); err != nil {
+			return v, false
+		}
+		return v, true
+	case T:
+		return found, true
+	}
+	return v, false
+}
+
+// Set sets the attribute on the node, replacing any value it had.
+func (k Attr[T]) Set(n *Node, v T) {
+	if n.Attrs == nil {
+		n.Attrs = Attributes{}
+	}
+	n.Attrs[k.name] = v
+}
+
+// Delete removes the attribute from the node.
+func (k Attr[T]) Delete(n *Node) {
+	delete(n.Attrs, k.name)
+}
+
+// ---------------------------------------------------------------------------------------------------------
+
+// numberNodes gives every node of the tree without an ID the ID following
+// the node before it in preorder, or 1 for the root, as a decoded tree only
+// holds the IDs that break from preorder. It then moves nextID past the
+// highest ID in the tree.
+func (a *AST) numberNodes(root *Node) {
+	prev := 0
+	visitPreorder(root, func(n *Node) {
+		if n.ID == 0 {
+			n.ID = prev + 1
+		}
+		prev = n.ID
+		a.nextID = max(a.nextID, n.ID+1)
+	})
+}
+
+// renumber gives the node and its descendants new IDs, in preorder.
+func (a *AST) renumber(n *Node) {
+	visitPreorder(n, func(n *Node) {
+		n.ID = a.nextID
+		a.nextID++
+	})
+}
+
+func visitPreorder(n *Node, fn func(*Node)) {
+	fn(n)
+	for i := range n.Children {
+		visitPreorder(&n.Children[i], fn)
+	}
+}
@@ -0,0 +1,227 @@
+package dsl
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testSymbol struct {
+	Name string
+	Line int
+}
+
+func TestAttr(t *testing.T) {
+	a := newTestAST()
+	var ids []int
+	visitPreorder(a.RootNode, func(n *Node) { ids = append(ids, n.ID) })
+	for i, id := range ids {
+		if id != i+1 {
+			t.Fatalf("expected nodes numbered in preorder, found %v", ids)
+		}
+	}
+
+	typeOf := NewAttr[string]("type")
+	symbol := NewAttr[*testSymbol]("symbol")
+	n := &a.RootNode.Children[0].Children[1]
+	if _, ok := typeOf.Get(n); ok {
+		t.Errorf("expected no type before it is set")
+	}
+	typeOf.Set(n, "int")
+	symbol.Set(n, &testSymbol{Name: "a", Line: 1})
+	if v, ok := typeOf.Get(n); !ok || v != "int" {
+		t.Errorf("expected type int, found %q %v", v, ok)
+	}
+	if v, ok := symbol.Get(n); !ok || v.Name != "a" {
+		t.Errorf("expected symbol a, found %v %v", v, ok)
+	}
+	if _, ok := NewAttr[int]("type").Get(n); ok {
+		t.Errorf("expected a key of another type not to get the attribute")
+	}
+
+	// Copies made by Document.Edit keep the attributes without sharing them
+	c := copyNode(n)
+	typeOf.Set(&c, "float")
+	if v, _ := typeOf.Get(n); v != "int" || c.ID != n.ID {
+		t.Errorf("expected the copy to keep the ID and not share attributes, found %q", v)
+	}
+	typeOf.Delete(n)
+	if _, ok := typeOf.Get(n); ok {
+		t.Errorf("expected type to be deleted")
+	}
+}
+
+func TestAttrRoundTrip(t *testing.T) {
+	formats := []struct {
+		name      string
+		marshal   func(AST) ([]byte, error)
+		unmarshal func(*AST, []byte) error
+		ids       bool
+	}{
+		{"JSON", func(a AST) ([]byte, error) { return json.Marshal(a) }, func(a *AST, b []byte) error { return json.Unmarshal(b, a) }, true},
+		{"SExpr", AST.MarshalSExpr, (*AST).UnmarshalSExpr, false},
+		{"Binary", AST.MarshalBinary, (*AST).UnmarshalBinary, true},
+	}
+
+	typeOf := NewAttr[string]("type")
+	symbol := NewAttr[testSymbol]("symbol")
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			a := newTestAST()
+			a.RootNode.Children[1].ID = 40
+			n := &a.RootNode.Children[0].Children[1]
+			typeOf.Set(n, "int")
+			symbol.Set(n, testSymbol{Name: "a", Line: 1})
+
+			data, err := f.marshal(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded AST
+			if err := f.unmarshal(&decoded, data); err != nil {
+				t.Fatal(err)
+			}
+			n = &decoded.RootNode.Children[0].Children[1]
+			if v, ok := typeOf.Get(n); !ok || v != "int" {
+				t.Errorf("expected type int, found %q %v", v, ok)
+			}
+			if v, ok := symbol.Get(n); !ok || v != (testSymbol{Name: "a", Line: 1}) {
+				t.Errorf("expected symbol a, found %v %v", v, ok)
+			}
+
+			// The nodes after the renumbered one keep their IDs too
+			var expectedIDs, ids []int
+			visitPreorder(a.RootNode, func(n *Node) { expectedIDs = append(expectedIDs, n.ID) })
+			visitPreorder(decoded.RootNode, func(n *Node) { ids = append(ids, n.ID) })
+			if !f.ids {
+				expectedIDs = []int{1, 2, 3, 4, 5, 6, 7}
+			}
+			if !reflect.DeepEqual(ids, expectedIDs) {
+				t.Errorf("expected IDs %v, found %v", expectedIDs, ids)
+			}
+			expected := expectedIDs[len(expectedIDs)-1]
+			decoded.addNode("NEW")
+			if id := decoded.curNode.ID; id <= expected {
+				t.Errorf("expected a new node to be given an unused ID, found %v", id)
+			}
+		})
+	}
+
+	a := newTestAST()
+	NewAttr[func()]("fn").Set(a.RootNode, func() {})
+	if _, err := a.MarshalBinary(); err == nil {
+		t.Errorf("expected an error encoding an attribute that is not JSON")
+	}
+	if _, err := a.MarshalSExpr(); err == nil {
+		t.Errorf("expected an error encoding an attribute that is not JSON")
+	}
+}
+
+func TestAttrInterface(t *testing.T) {
+	a := newTestAST()
+	n := &a.RootNode.Children[0]
+	NewAttr[string]("type").Set(n, "int")
+	NewAttr[int]("size").Set(n, 8)
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded AST
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// The JSON held by a decoded attribute is unmarshalled rather than
+	// returned as it is
+	n = &decoded.RootNode.Children[0]
+	if v, ok := NewAttr[interface{}]("type").Get(n); !ok || v != "int" {
+		t.Errorf("expected type int, found %#v %v", v, ok)
+	}
+	if v, ok := NewAttr[interface{}]("size").Get(n); !ok || v != float64(8) {
+		t.Errorf("expected size 8, found %#v %v", v, ok)
+	}
+}
+
+func TestMarshalJSONIDs(t *testing.T) {
+	// A tree numbered in preorder is written without IDs
+	a := newTestAST()
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), `"id"`) {
+		t.Errorf("expected no IDs, found %s", data)
+	}
+
+	// Otherwise only the IDs breaking from preorder are written, starting
+	// with the node marshalled unless it is 1
+	a.RootNode.Children[1].ID = 40
+	for _, tt := range []struct {
+		node     *Node
+		expected []string
+	}{
+		{a.RootNode, []string{`"id":40`, `"id":7`}},
+		{&a.RootNode.Children[0].Children[1], []string{`"id":4`}},
+		{&a.RootNode.Children[1], []string{`"id":40`}},
+	} {
+		data, err := json.Marshal(tt.node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []string
+		for _, field := range strings.Split(string(data), ",") {
+			if i := strings.Index(field, `"id":`); i >= 0 {
+				found = append(found, field[i:])
+			}
+		}
+		if !reflect.DeepEqual(found, tt.expected) {
+			t.Errorf("%v: expected IDs %q, found %q in %s", tt.node.Type, tt.expected, found, data)
+		}
+	}
+}
+
+func TestUnmarshalSExprAttr(t *testing.T) {
+	var a AST
+	if err := a.UnmarshalSExpr([]byte(`(ROOT (:attr "count" "3") (A (:attr "x" "not json")))`)); err == nil {
+		t.Errorf("expected an error decoding an attribute that is not JSON")
+	}
+	if err := a.UnmarshalSExpr([]byte(`(ROOT (:attr "count" "3") (A))`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := NewAttr[int]("count").Get(a.RootNode); !ok || v != 3 {
+		t.Errorf("expected count 3, found %v %v", v, ok)
+	}
+	if a.RootNode.ID != 1 || a.RootNode.Children[0].ID != 2 {
+		t.Errorf("expected nodes numbered in preorder, found %v and %v", a.RootNode.ID, a.RootNode.Children[0].ID)
+	}
+}
+
+func TestAttrEdit(t *testing.T) {
+	d := ParseDocument(nestedParse, triviaScan, "one;\ntwo;\nthree;")
+	typeOf := NewAttr[string]("type")
+	typeOf.Set(d.AST.RootNode, "root")
+	typeOf.Set(&d.AST.RootNode.Children[0], "first")
+
+	nd, _, err := d.Edit(TextEdit{StartLine: 2, StartPosition: 1, EndLine: 2, EndPosition: 4, Text: "deux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, first := nd.AST.RootNode, &nd.AST.RootNode.Children[0]
+	if v, _ := typeOf.Get(root); v != "root" {
+		t.Errorf("expected the root to keep its attributes, found %q", v)
+	}
+	if v, _ := typeOf.Get(first); v != "first" {
+		t.Errorf("expected the reused node to keep its attributes, found %q", v)
+	}
+
+	// Setting an attribute on the edited tree leaves the previous one as it was
+	typeOf.Set(root, "changed")
+	typeOf.Set(first, "changed")
+	if v, _ := typeOf.Get(d.AST.RootNode); v != "root" {
+		t.Errorf("expected the previous root to keep its attribute, found %q", v)
+	}
+	if v, _ := typeOf.Get(&d.AST.RootNode.Children[0]); v != "first" {
+		t.Errorf("expected the previous node to keep its attribute, found %q", v)
+	}
+}
@@ -0,0 +1,264 @@
+// comments.go attaches comment tokens to the AST nodes they describe, in
+// the same way as go/ast.CommentMap. Comments are found among the hidden
+// tokens of the AST (see Parser.HideTokens), where each one is attached to
+// whichever node the parser happened to be building when it was read.
+// AttachComments moves each comment to the node it belongs to based on line
+// and position, as a leading, trailing or dangling comment.
+//
+// A comment is attached to a node n if:
+//   - it starts on the line n ends, as a trailing comment; or
+//   - it starts on the line after n ends and there is an empty line after
+//     it and before the next node, as a trailing comment; or
+//   - it is before n and not attached to the node before n by the previous
+//     rules, as a leading comment.
+//
+// Only nodes inside the innermost node containing the comment are
+// considered, and the outermost of several nodes starting or ending at the
+// same place is chosen. A comment that is not attached by any rule, such as
+// a comment at the end of the input or inside a node with nothing after it,
+// is a dangling comment of the innermost node containing it.
+
+package dsl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CommentKind describes where a comment is relative to the node it is
+// attached to.
+type CommentKind int
+
+const (
+	CommentLeading  CommentKind = iota // The comment is before the node
+	CommentTrailing                    // The comment follows the end of the node
+	CommentDangling                    // The comment is inside the node but not next to any of its children
+)
+
+var commentKindNames = [...]string{"leading", "trailing", "dangling"}
+
+func (k CommentKind) String() string {
+	if k >= 0 && int(k) < len(commentKindNames) {
+		return commentKindNames[k]
+	}
+	return fmt.Sprintf("CommentKind(%d)", int(k))
+}
+
+// MarshalText implements encoding.TextMarshaler so the kind is written by
+// name in JSON.
+func (k CommentKind) MarshalText() ([]byte, error) {
+	if k < 0 || int(k) >= len(commentKindNames) {
+		return nil, fmt.Errorf("dsl: invalid comment kind %d", int(k))
+	}
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *CommentKind) UnmarshalText(text []byte) error {
+	kind, ok := parseCommentKind(string(text))
+	if !ok {
+		return fmt.Errorf("dsl: invalid comment kind %q", text)
+	}
+	*k = kind
+	return nil
+}
+
+func parseCommentKind(name string) (CommentKind, bool) {
+	for i, n := range commentKindNames {
+		if n == name {
+			return CommentKind(i), true
+		}
+	}
+	return 0, false
+}
+
+// Comment is a comment token attached to a node.
+type Comment struct {
+	Kind  CommentKind `json:"kind"`
+	Token ASTToken    `json:"token"`
+}
+
+// WithComments returns a ParseOption that hides tokens of the given types
+// from the user parse function and attaches them to the AST as comments
+// once the parse is complete. See AttachComments.
+func WithComments(ids ...TokenType) ParseOption {
+	return func(c *ParseConfig) {
+		c.HiddenTokens = append(c.HiddenTokens, ids...)
+		c.Comments = append(c.Comments, ids...)
+	}
+}
+
+// LeadingComments returns the comments before the node.
+func (n *Node) LeadingComments() []ASTToken {
+	return n.commentsOf(CommentLeading)
+}
+
+// TrailingComments returns the comments following the end of the node.
+func (n *Node) TrailingComments() []ASTToken {
+	return n.commentsOf(CommentTrailing)
+}
+
+// DanglingComments returns the comments inside the node that are not next
+// to any of its children.
+func (n *Node) DanglingComments() []ASTToken {
+	return n.commentsOf(CommentDangling)
+}
+
+func (n *Node) commentsOf(kind CommentKind) []ASTToken {
+	var tokens []ASTToken
+	for _, c := range n.Comments {
+		if c.Kind == kind {
+			tokens = append(tokens, c.Token)
+		}
+	}
+	return tokens
+}
+
+// AttachComments moves the hidden tokens of the given types out of
+// Node.Hidden and attaches them to the nodes they describe as Comments. If
+// no types are given every hidden token is treated as a comment. Comments
+// already attached are attached again, so AttachComments can be called
+// again after the tree has changed.
+func (a *AST) AttachComments(ids ...TokenType) {
+	if a.RootNode == nil {
+		return
+	}
+
+	isComment := make(map[TokenType]bool)
+	for _, id := range ids {
+		isComment[id] = true
+	}
+
+	// Collect the comments and the span of every node in depth first order
+	var comments []ASTToken
+	var nodes []commentNode
+	var walk func(n *Node, depth int) int
+	walk = func(n *Node, depth int) int {
+		var hidden []ASTToken
+		for _, tok := range n.Hidden {
+			if len(ids) == 0 || isComment[tok.ID] {
+				comments = append(comments, tok)
+			} else {
+				hidden = append(hidden, tok)
+			}
+		}
+		n.Hidden = hidden
+		for _, c := range n.Comments {
+			comments = append(comments, c.Token)
+		}
+		n.Comments = nil
+
+		i := len(nodes)
+		nodes = append(nodes, commentNode{node: n, depth: depth})
+		for _, tok := range n.Tokens {
+			nodes[i].span.add(tok)
+		}
+		for j := range n.Children {
+			child := walk(&n.Children[j], depth+1)
+			nodes[i].span.join(nodes[child].span)
+		}
+		nodes[i].last = len(nodes) - 1
+		return i
+	}
+	walk(a.RootNode, 0)
+
+	sort.SliceStable(comments, func(i, j int) bool {
+		return posBefore(comments[i].Line, comments[i].Position, comments[j].Line, comments[j].Position)
+	})
+	for _, c := range comments {
+		target, kind := commentTarget(nodes, c)
+		target.Comments = append(target.Comments, Comment{Kind: kind, Token: c})
+	}
+}
+
+// commentNode is a node in depth first order with the span of its tokens
+// and the tokens of all of its descendants. Its descendants are the nodes
+// after it up to and including last.
+type commentNode struct {
+	node  *Node
+	span  span
+	depth int
+	last  int
+}
+
+// span is the part of the source between the first rune of the first token
+// and the last rune of the last token.
+type span struct {
+	valid               bool
+	startLine, startPos int
+	endLine, endPos     int
+}
+
+func (s *span) add(tok ASTToken) {
+	endLine, endPos := literalEnd(tok.Line, tok.Position, sourceLiteral(tok.Literal))
+	s.join(span{true, tok.Line, tok.Position, endLine, endPos})
+}
+
+func (s *span) join(o span) {
+	if !o.valid {
+		return
+	}
+	if !s.valid {
+		*s = o
+		return
+	}
+	if posBefore(o.startLine, o.startPos, s.startLine, s.startPos) {
+		s.startLine, s.startPos = o.startLine, o.startPos
+	}
+	if posBefore(s.endLine, s.endPos, o.endLine, o.endPos) {
+		s.endLine, s.endPos = o.endLine, o.endPos
+	}
+}
+
+// contains reports whether line:pos is strictly inside the span.
+func (s span) contains(line, pos int) bool {
+	return s.valid && posBefore(s.startLine, s.startPos, line, pos) && posBefore(line, pos, s.endLine, s.endPos)
+}
+
+func posBefore(line1, pos1, line2, pos2 int) bool {
+	return line1 < line2 || (line1 == line2 && pos1 < pos2)
+}
+
+// commentTarget returns the node the comment is attached to and how.
+func commentTarget(nodes []commentNode, c ASTToken) (*Node, CommentKind) {
+	cEndLine, cEndPos := literalEnd(c.Line, c.Position, c.Literal)
+
+	// The innermost node containing the comment, or the root
+	enclosing := 0
+	for i, n := range nodes {
+		if n.span.contains(c.Line, c.Position) && n.depth >= nodes[enclosing].depth {
+			enclosing = i
+		}
+	}
+
+	// The nodes inside it ending closest before and starting closest after
+	// the comment. Nodes come before their descendants so the outermost of
+	// those ending or starting at the same place is kept.
+	prev, next := -1, -1
+	for i := enclosing + 1; i <= nodes[enclosing].last; i++ {
+		s := nodes[i].span
+		if !s.valid {
+			continue
+		}
+		if posBefore(s.endLine, s.endPos, c.Line, c.Position) {
+			if prev < 0 || posBefore(nodes[prev].span.endLine, nodes[prev].span.endPos, s.endLine, s.endPos) {
+				prev = i
+			}
+		}
+		if posBefore(cEndLine, cEndPos, s.startLine, s.startPos) {
+			if next < 0 || posBefore(s.startLine, s.startPos, nodes[next].span.startLine, nodes[next].span.startPos) {
+				next = i
+			}
+		}
+	}
+
+	switch {
+	case prev >= 0 && c.Line == nodes[prev].span.endLine:
+		return nodes[prev].node, CommentTrailing
+	case prev >= 0 && c.Line == nodes[prev].span.endLine+1 && (next < 0 || nodes[next].span.startLine > cEndLine+1):
+		return nodes[prev].node, CommentTrailing
+	case next >= 0:
+		return nodes[next].node, CommentLeading
+	}
+	return nodes[enclosing].node, CommentDangling
+}
@@ -0,0 +1,114 @@
+package dsl
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newCommentAST builds the AST for the source below, with every comment and
+// the semicolon hidden on whichever node was current when it was read.
+//
+//	// lead
+//	a b // trail
+//	c;
+//	// after
+//
+//	d ( /* in */ )
+//
+//	// end
+func newCommentAST() AST {
+	a := newAST()
+	a.addHidden(Token{ID: "COMMENT", Literal: "// lead", Line: 1, Position: 1})
+	a.addNode("STATEMENT")
+	a.addToken([]Token{{ID: "WORD", Literal: "a", Line: 2, Position: 1}})
+	a.addNode("TERM")
+	a.addToken([]Token{{ID: "WORD", Literal: "b", Line: 2, Position: 3}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "// trail", Line: 2, Position: 5})
+	a.walkUp()
+	a.walkUp()
+	a.addNode("STATEMENT")
+	a.addToken([]Token{{ID: "WORD", Literal: "c", Line: 3, Position: 1}})
+	a.addHidden(Token{ID: "SEMI", Literal: ";", Line: 3, Position: 2})
+	a.addHidden(Token{ID: "COMMENT", Literal: "// after", Line: 4, Position: 1})
+	a.walkUp()
+	a.addNode("STATEMENT")
+	a.addToken([]Token{{ID: "WORD", Literal: "d", Line: 6, Position: 1}})
+	a.addNode("GROUP")
+	a.addToken([]Token{{ID: "OPEN", Literal: "(", Line: 6, Position: 3}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "/* in */", Line: 6, Position: 5})
+	a.addToken([]Token{{ID: "CLOSE", Literal: ")", Line: 6, Position: 14}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "// end", Line: 8, Position: 1})
+	a.walkUp()
+	a.walkUp()
+	return a
+}
+
+func TestAttachComments(t *testing.T) {
+	a := newCommentAST()
+	a.AttachComments("COMMENT")
+
+	root := a.RootNode
+	first, second, third := &root.Children[0], &root.Children[1], &root.Children[2]
+	group := &third.Children[0]
+
+	tests := []struct {
+		name  string
+		got   []ASTToken
+		wants []string
+	}{
+		{"leading on first statement", first.LeadingComments(), []string{"// lead"}},
+		{"trailing on the same line, outermost node", first.TrailingComments(), []string{"// trail"}},
+		{"not on the inner node ending at the same place", first.Children[0].TrailingComments(), nil},
+		{"trailing on the next line before a blank line", second.TrailingComments(), []string{"// after"}},
+		{"nothing leading the third statement", third.LeadingComments(), nil},
+		{"dangling inside a node with no children", group.DanglingComments(), []string{"/* in */"}},
+		{"dangling at the end of the input", root.DanglingComments(), []string{"// end"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, tok := range test.got {
+			got = append(got, tok.Literal)
+		}
+		if !reflect.DeepEqual(got, test.wants) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.wants)
+		}
+	}
+
+	// Only comments are moved, other hidden tokens stay where they were
+	for _, n := range []*Node{root, first, &first.Children[0], second, third, group} {
+		for _, tok := range n.Hidden {
+			if tok.ID == "COMMENT" {
+				t.Errorf("comment %q left hidden on %v", tok.Literal, n.Type)
+			}
+		}
+	}
+	if len(second.Hidden) != 1 || second.Hidden[0].ID != "SEMI" {
+		t.Errorf("expected the semicolon to stay hidden, found %+v", second.Hidden)
+	}
+
+	// Attaching again gives the same result
+	before, _ := a.MarshalSExpr()
+	a.AttachComments("COMMENT")
+	after, _ := a.MarshalSExpr()
+	if string(before) != string(after) {
+		t.Errorf("AttachComments is not idempotent:\nfirst  %s\nsecond %s", before, after)
+	}
+}
+
+func TestAttachCommentsLeadingBeforeBlankLine(t *testing.T) {
+	// A comment on the line after a node is leading the next node unless
+	// there is a blank line between them.
+	a := newAST()
+	a.addNode("STATEMENT")
+	a.addToken([]Token{{ID: "WORD", Literal: "a", Line: 1, Position: 1}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "// about b", Line: 2, Position: 1})
+	a.walkUp()
+	a.addNode("STATEMENT")
+	a.addToken([]Token{{ID: "WORD", Literal: "b", Line: 3, Position: 1}})
+	a.walkUp()
+
+	a.AttachComments()
+	if got := a.RootNode.Children[1].LeadingComments(); len(got) != 1 || got[0].Literal != "// about b" {
+		t.Errorf("expected the comment leading the second statement, found %+v", a.RootNode.Children)
+	}
+}
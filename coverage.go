@@ -0,0 +1,352 @@
+// coverage.go collects grammar coverage: for every Parser.Expect,
+// Parser.ExpectNot, Scanner.Expect and Scanner.ExpectNot call site in the
+// user parse and scan functions it records which branches were taken and
+// which never fired. A single Coverage can be shared by every parse in a
+// test run and reported as text or HTML, much like go test -cover but at the
+// grammar level.
+
+package dsl
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Coverage collects branch coverage across any number of parses. It is safe
+// for concurrent use. The zero value is not usable, create one with
+// NewCoverage and pass it to Parse or Tokenize with WithCoverage.
+type Coverage struct {
+	mu    sync.Mutex
+	byPC  map[siteKey]*CoverageSite
+	byPos map[string]*CoverageSite
+}
+
+// CoverageSite is a single Expect or ExpectNot call site in the grammar.
+// A call site passed different branches on different calls, such as one in
+// a helper shared by several parse functions, is a separate site for each
+// set of branches.
+type CoverageSite struct {
+	Kind     string // Parser.Expect, Parser.ExpectNot, Scanner.Expect or Scanner.ExpectNot
+	File     string
+	Line     int
+	Func     string // The user parse or scan function containing the call
+	Calls    int    // Number of times the call ran, not counting calls skipped after an error
+	Branches []BranchCoverage
+}
+
+// BranchCoverage is the number of times a branch of a call site fired.
+// An ExpectNot call site has one branch for the tokens or runes it consumes
+// and one Stop branch for each of the tokens or runes that end it.
+type BranchCoverage struct {
+	Label string
+	Stop  bool
+	Hits  int
+}
+
+const (
+	coverParserExpect     = "Parser.Expect"
+	coverParserExpectNot  = "Parser.ExpectNot"
+	coverScannerExpect    = "Scanner.Expect"
+	coverScannerExpectNot = "Scanner.ExpectNot"
+
+	// coverOther labels the branch an ExpectNot takes when it consumes.
+	coverOther = "(any other)"
+)
+
+// NewCoverage returns an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		byPC:  make(map[siteKey]*CoverageSite),
+		byPos: make(map[string]*CoverageSite),
+	}
+}
+
+// WithCoverage returns a ParseOption that records grammar coverage in c.
+func WithCoverage(c *Coverage) ParseOption {
+	return func(config *ParseConfig) {
+		config.Coverage = c
+	}
+}
+
+// siteKey identifies a call site by the caller's program counter and the
+// labels of the branches it was passed.
+type siteKey struct {
+	pc     uintptr
+	labels string
+}
+
+// site returns the call site of the Expect or ExpectNot method that called
+// it with the given branches, registering the site the first time it is
+// seen so branches that never fire are still reported. It returns nil if
+// coverage is not being collected.
+func (c *Coverage) site(kind string, branches []BranchCoverage) *CoverageSite {
+	if c == nil {
+		return nil
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // Skip runtime.Callers, site and the Expect method
+	key := siteKey{pcs[0], branchLabels(branches)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if site, ok := c.byPC[key]; ok {
+		return site
+	}
+
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	pos := fmt.Sprintf("%v:%v:%v:%v", frame.File, frame.Line, kind, key.labels)
+	site, ok := c.byPos[pos]
+	if !ok {
+		site = &CoverageSite{
+			Kind:     kind,
+			File:     frame.File,
+			Line:     frame.Line,
+			Func:     shortFuncName(frame.Function),
+			Branches: branches,
+		}
+		c.byPos[pos] = site
+	}
+	c.byPC[key] = site
+	return site
+}
+
+// call records that the call site ran.
+func (c *Coverage) call(site *CoverageSite) {
+	if site == nil {
+		return
+	}
+	c.mu.Lock()
+	site.Calls++
+	c.mu.Unlock()
+}
+
+// hit records that branch i of the call site fired.
+func (c *Coverage) hit(site *CoverageSite, i int) {
+	if site == nil {
+		return
+	}
+	c.mu.Lock()
+	site.Branches[i].Hits++
+	c.mu.Unlock()
+}
+
+// Sites returns a copy of every call site seen, ordered by file and line.
+func (c *Coverage) Sites() []CoverageSite {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sites := make([]CoverageSite, 0, len(c.byPos))
+	for _, site := range c.byPos {
+		s := *site
+		s.Branches = append([]BranchCoverage(nil), site.Branches...)
+		sites = append(sites, s)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].File != sites[j].File {
+			return sites[i].File < sites[j].File
+		}
+		if sites[i].Line != sites[j].Line {
+			return sites[i].Line < sites[j].Line
+		}
+		if sites[i].Kind != sites[j].Kind {
+			return sites[i].Kind < sites[j].Kind
+		}
+		return branchLabels(sites[i].Branches) < branchLabels(sites[j].Branches)
+	})
+	return sites
+}
+
+// Branches returns the number of branches that fired and the total number
+// of branches across every call site.
+func (c *Coverage) Branches() (hit, total int) {
+	for _, site := range c.Sites() {
+		h, t := site.branches()
+		hit += h
+		total += t
+	}
+	return hit, total
+}
+
+// Percent returns the percentage of branches that fired, or 0 if no call
+// sites have been seen.
+func (c *Coverage) Percent() float64 {
+	return percent(c.Branches())
+}
+
+func (site CoverageSite) branches() (hit, total int) {
+	for _, b := range site.Branches {
+		if b.Hits > 0 {
+			hit++
+		}
+	}
+	return hit, len(site.Branches)
+}
+
+func percent(hit, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(hit) / float64(total)
+}
+
+// -------------------------------- Reports ---------------------------------------
+
+// WriteText writes a plain text coverage report to w. Each call site is
+// listed with the number of times each of its branches fired, branches
+// that never fired are marked "never".
+//
+//	grammar coverage: 3 of 4 branches (75.0%)
+//
+//	mydsl/parser.go:31: Parser.Expect in assignment, 2 calls, 1 of 2 branches
+//	       2  ASSIGN
+//	   never  OPEN_PAREN
+func (c *Coverage) WriteText(w io.Writer) error {
+	sites := c.Sites()
+	hit, total := c.Branches()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "grammar coverage: %d of %d branches (%.1f%%)\n", hit, total, percent(hit, total))
+	for _, site := range sites {
+		h, t := site.branches()
+		fmt.Fprintf(&b, "\n%v:%v: %v in %v, %v, %d of %d branches\n", shortFile(site.File), site.Line, site.Kind, site.Func, plural(site.Calls, "call"), h, t)
+		for _, branch := range site.Branches {
+			hits := "never"
+			if branch.Hits > 0 {
+				hits = fmt.Sprint(branch.Hits)
+			}
+			label := branch.Label
+			if branch.Stop {
+				label += " (stop)"
+			}
+			fmt.Fprintf(&b, "%8v  %v\n", hits, label)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHTML writes a self contained HTML coverage report to w, colouring
+// branches that fired green and branches that never fired red.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	type row struct {
+		Site    CoverageSite
+		File    string
+		Hit     int
+		Total   int
+		Percent float64
+	}
+	var data struct {
+		Hit, Total int
+		Percent    float64
+		Sites      []row
+	}
+	data.Hit, data.Total = c.Branches()
+	data.Percent = percent(data.Hit, data.Total)
+	for _, site := range c.Sites() {
+		h, t := site.branches()
+		data.Sites = append(data.Sites, row{site, shortFile(site.File), h, t, percent(h, t)})
+	}
+	return coverageHTML.Execute(w, data)
+}
+
+var coverageHTML = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Grammar coverage</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th { text-align: left; font-weight: normal; padding: 0.3em 0; }
+td { font-family: monospace; padding: 0.1em 0.8em; }
+td.hits { text-align: right; }
+tr.hit { background: #d4edda; }
+tr.miss { background: #f8d7da; }
+</style>
+</head>
+<body>
+<h1>Grammar coverage: {{.Hit}} of {{.Total}} branches ({{printf "%.1f" .Percent}}%)</h1>
+{{range .Sites}}<table>
+<tr><th colspan="2"><code>{{.File}}:{{.Site.Line}}</code> {{.Site.Kind}} in <code>{{.Site.Func}}</code>, {{.Site.Calls}} calls, {{.Hit}} of {{.Total}} branches ({{printf "%.1f" .Percent}}%)</th></tr>
+{{range .Site.Branches}}<tr class="{{if .Hits}}hit{{else}}miss{{end}}"><td class="hits">{{if .Hits}}{{.Hits}}{{else}}never{{end}}</td><td>{{.Label}}{{if .Stop}} (stop){{end}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// -------------------------------- Coverage Helper Functions ---------------------------------------
+
+// Used to label the branches of a Parser.Expect call site
+func branchTokenCoverage(branches []BranchToken) []BranchCoverage {
+	var cover []BranchCoverage
+	for _, label := range branchTokensToStrings(branches) {
+		cover = append(cover, BranchCoverage{Label: label})
+	}
+	return cover
+}
+
+// Used to label the branches of a Parser.ExpectNot call site
+func notTokenCoverage(tokens []TokenType) []BranchCoverage {
+	cover := []BranchCoverage{{Label: coverOther}}
+	for _, token := range tokens {
+		cover = append(cover, BranchCoverage{Label: string(token), Stop: true})
+	}
+	return cover
+}
+
+// Used to label the branches and branch ranges of a Scanner.Expect call site
+func branchRuneCoverage(branches []Branch, ranges []BranchRange) []BranchCoverage {
+	var cover []BranchCoverage
+	for _, label := range append(branchesToStrings(branches), branchRangesToStrings(ranges)...) {
+		cover = append(cover, BranchCoverage{Label: label})
+	}
+	return cover
+}
+
+// Used to label the runes and rune ranges of a Scanner.ExpectNot call site
+func notRuneCoverage(runes []rune, ranges []RuneRange) []BranchCoverage {
+	cover := []BranchCoverage{{Label: coverOther}}
+	for _, label := range append(runesToStrings(runes), runeRangesToStrings(ranges)...) {
+		cover = append(cover, BranchCoverage{Label: label, Stop: true})
+	}
+	return cover
+}
+
+// branchLabels joins the labels of a call site's branches, telling apart
+// the sets of branches passed to the same call.
+func branchLabels(branches []BranchCoverage) string {
+	labels := make([]string, len(branches))
+	for i, b := range branches {
+		labels[i] = b.Label
+	}
+	return strings.Join(labels, "\x00")
+}
+
+// shortFuncName trims the package path from a function name, so
+// github.com/user/mydsl.assignment becomes assignment.
+func shortFuncName(name string) string {
+	name = path.Base(name)
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// shortFile keeps the last directory of a file path so call sites in
+// different grammars with the same file name can be told apart.
+func shortFile(file string) string {
+	return path.Join(path.Base(path.Dir(file)), path.Base(file))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %v", n, noun)
+	}
+	return fmt.Sprintf("%d %vs", n, noun)
+}
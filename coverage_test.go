@@ -0,0 +1,152 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// coverScan scans single letter words and digits, skipping spaces.
+func coverScan(s *Scanner) Token {
+	s.Expect(ExpectRune{
+		Branches: []Branch{{Rn: ' ', Fn: func(s *Scanner) { s.SkipRune() }}},
+		Options:  ExpectRuneOptions{Optional: true, Multiple: true},
+	})
+	s.Expect(ExpectRune{
+		Branches: []Branch{
+			{Rn: 'a', Fn: func(s *Scanner) { s.Match([]Match{{Literal: "a", ID: "A"}}) }},
+			{Rn: 'b', Fn: func(s *Scanner) { s.Match([]Match{{Literal: "b", ID: "B"}}) }},
+			{Rn: rune(0), Fn: func(s *Scanner) { s.Match([]Match{{Literal: "", ID: TOKEN_EOF}}) }},
+		},
+		BranchRanges: []BranchRange{
+			{StartRn: '0', EndRn: '9', Fn: func(s *Scanner) { s.Match([]Match{{Literal: "", ID: "DIGIT"}}) }},
+		},
+	})
+	return s.Exit()
+}
+
+// coverParse accepts any number of A and B tokens until a DIGIT or the end.
+func coverParse(p *Parser) (AST, []Error) {
+	p.AddNode("ROOT")
+	p.ExpectNot(ExpectNotToken{
+		Tokens:  []TokenType{"DIGIT", TOKEN_EOF},
+		Options: ParseOptions{Optional: true, Multiple: true},
+	})
+	p.Expect(ExpectToken{
+		Branches: []BranchToken{
+			{Id: "DIGIT"},
+			{Id: TOKEN_EOF},
+		},
+	})
+	return p.ast, p.errors
+}
+
+func TestCoverage(t *testing.T) {
+	c := NewCoverage()
+	for _, input := range []string{"a a b", "a"} {
+		Parse(coverParse, coverScan, bufio.NewReader(strings.NewReader(input)), WithCoverage(c))
+	}
+
+	sites := c.Sites()
+	if len(sites) != 4 {
+		t.Fatalf("expected 4 call sites, found %d: %+v", len(sites), sites)
+	}
+
+	hits := make(map[string]int)
+	for _, site := range sites {
+		if !strings.HasSuffix(site.File, "coverage_test.go") {
+			t.Errorf("%v call site in unexpected file %v", site.Kind, site.File)
+		}
+		for _, branch := range site.Branches {
+			hits[site.Kind+" "+site.Func+" "+branch.Label] = branch.Hits
+		}
+	}
+	expected := map[string]int{
+		"Scanner.Expect coverScan WS":               2,
+		"Scanner.Expect coverScan a":                3,
+		"Scanner.Expect coverScan b":                1,
+		"Scanner.Expect coverScan EOF":              2,
+		"Scanner.Expect coverScan 0-9":              0,
+		"Parser.ExpectNot coverParse " + coverOther: 4,
+		"Parser.ExpectNot coverParse DIGIT":         0,
+		"Parser.ExpectNot coverParse EOF":           2,
+		"Parser.Expect coverParse DIGIT":            0,
+		"Parser.Expect coverParse EOF":              2,
+	}
+	for key, want := range expected {
+		if got, ok := hits[key]; !ok || got != want {
+			t.Errorf("%v: expected %d hits, found %d (recorded %v)", key, want, got, ok)
+		}
+	}
+
+	if hit, total := c.Branches(); hit != 7 || total != 10 {
+		t.Errorf("expected 7 of 10 branches, found %d of %d", hit, total)
+	}
+
+	var text bytes.Buffer
+	if err := c.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"grammar coverage: 7 of 10 branches (70.0%)",
+		"Parser.Expect in coverParse, 2 calls, 1 of 2 branches",
+		"   never  DIGIT (stop)",
+		"   never  0-9",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report is missing %q:\n%v", want, text.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := c.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), `<tr class="miss"><td class="hits">never</td><td>0-9</td></tr>`) {
+		t.Errorf("html report does not mark 0-9 as never fired:\n%v", html.String())
+	}
+}
+
+// expectAny expects any of ids, so each caller passes its own branches to
+// the same call site.
+func expectAny(p *Parser, ids ...TokenType) {
+	var branches []BranchToken
+	for _, id := range ids {
+		branches = append(branches, BranchToken{Id: id})
+	}
+	p.Expect(ExpectToken{Branches: branches})
+}
+
+func TestCoverageSharedSite(t *testing.T) {
+	helperParse := func(p *Parser) (AST, []Error) {
+		p.AddNode("ROOT")
+		expectAny(p, "A")
+		expectAny(p, "B", "DIGIT")
+		return p.ast, p.errors
+	}
+	c := NewCoverage()
+	for _, input := range []string{"a 1", "a b"} {
+		if _, errs := Parse(helperParse, coverScan, bufio.NewReader(strings.NewReader(input)), WithCoverage(c)); len(errs) != 0 {
+			t.Fatalf("%q: unexpected errors: %v", input, errs)
+		}
+	}
+
+	// The call in expectAny is a separate site for each set of branches
+	var found []string
+	for _, site := range c.Sites() {
+		if site.Func != "expectAny" {
+			continue
+		}
+		var branches []string
+		for _, branch := range site.Branches {
+			branches = append(branches, fmt.Sprintf("%v=%d", branch.Label, branch.Hits))
+		}
+		found = append(found, fmt.Sprintf("%d calls: %v", site.Calls, strings.Join(branches, " ")))
+	}
+	expected := []string{"2 calls: A=2", "2 calls: B=1 DIGIT=1"}
+	if strings.Join(found, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected sites %q, found %q", expected, found)
+	}
+}
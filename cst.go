@@ -0,0 +1,77 @@
+// cst.go implements automatic concrete syntax trees. Building the AST takes
+// explicit AddNode, AddTokens, WalkUp and SkipToken calls in the user parse
+// function, and skipped tokens such as parentheses and separators never
+// reach it. With WithCST the parser also builds a second tree on its own,
+// with one node for each call of a rule function holding every token the
+// parser consumed while it was running, skipped or not.
+//
+// A node is named after its rule function without the package path, such
+// as "parseObject", or "parseObject.func1" for a function literal. The
+// token matched by a branch belongs to the node of the branch function, so
+// the node of a function such as parseObject starts with the "{" that led
+// to it. A token matched by a branch without a function belongs to the
+// node of the rule that called Expect. Tokens and children are kept apart
+// in a Node, so the order of the source is given by their positions, as
+// for Node.Source.
+//
+// The CST is an ordinary AST, so it can be printed, serialized and walked
+// to derive an AST instead of building one in the parse function.
+
+package dsl
+
+import "strings"
+
+// WithCST returns a ParseOption that builds a concrete syntax tree during the
+// parse and stores it in cst. Errors, hidden tokens, comments and trivia are
+// kept on the CST in the same way as on the AST.
+func WithCST(cst *AST) ParseOption {
+	return func(c *ParseConfig) {
+		c.CST = cst
+	}
+}
+
+// cstEnter adds a node for the rule function to the CST, holding the token
+// matched by the branch that called it, and makes it the current node.
+func (p *Parser) cstEnter(fn interface{}) {
+	if p.cst == nil {
+		return
+	}
+	p.cst.addNode(ruleName(fn))
+	p.cstFlush()
+}
+
+// cstLeave makes the parent of the current node the current node of the
+// CST.
+func (p *Parser) cstLeave() {
+	if p.cst != nil {
+		p.cst.walkUp()
+	}
+}
+
+// cstConsume records a token consumed by Expect or ExpectNot. It is held
+// until the branch function is called, see cstEnter, or found to be nil.
+func (p *Parser) cstConsume(tok Token) {
+	if p.cst != nil {
+		p.cstTokens = append(p.cstTokens, tok)
+	}
+}
+
+// cstFlush adds the tokens consumed so far to the current node of the CST.
+func (p *Parser) cstFlush() {
+	if p.cst != nil && len(p.cstTokens) > 0 {
+		p.cst.addToken(p.cstTokens)
+		p.cstTokens = nil
+	}
+}
+
+// ruleName returns the name of a rule function without its package path.
+func ruleName(fn interface{}) NodeType {
+	name := getFuncName(fn)
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return NodeType(name)
+}
@@ -0,0 +1,104 @@
+package dsl
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// cstParse parses statements of words and strings separated by semicolons
+// without building an AST.
+func cstParse(p *Parser) (AST, []Error) {
+	p.Expect(ExpectToken{
+		Branches: []BranchToken{
+			{Id: "WORD", Fn: cstStatement},
+			{Id: "STRING", Fn: cstStatement},
+			{Id: "SEMI"},
+		},
+		Options: ParseOptions{Multiple: true},
+	})
+	p.Recover(cstRecover)
+	return p.Exit()
+}
+
+func cstStatement(p *Parser) {
+	p.Call(cstWords)
+	p.Expect(ExpectToken{Branches: []BranchToken{{Id: "SEMI"}, {Id: TOKEN_EOF}}, Options: ParseOptions{Skip: true}})
+}
+
+func cstWords(p *Parser) {
+	p.Expect(ExpectToken{
+		Branches: []BranchToken{{Id: "WORD"}, {Id: "STRING"}},
+		Options:  ParseOptions{Multiple: true, Optional: true},
+	})
+}
+
+func cstRecover(p *Parser) {
+	p.ExpectNot(ExpectNotToken{Tokens: []TokenType{TOKEN_EOF}, Options: ParseOptions{Optional: true, Multiple: true}})
+}
+
+func TestWithCST(t *testing.T) {
+	input := "say \"hi\" ; bye;"
+	var cst AST
+	ast, errs := Parse(cstParse, triviaScan, bufio.NewReader(strings.NewReader(input)), WithCST(&cst), WithTrivia())
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(ast.RootNode.Children) != 0 {
+		t.Errorf("expected an empty AST, found %+v", ast.RootNode.Children)
+	}
+
+	want := `(ROOT
+  (cstParse
+    (cstStatement (WORD "say" 1:1) (SEMI ";" 1:10 :leading " ")
+      (cstWords (STRING "hi" 1:6 :leading " \"" :trailing "\"")))
+    (cstStatement (WORD "bye" 1:12 :leading " ") (SEMI ";" 1:15)
+      (cstWords))))
+`
+	got, err := cst.MarshalSExpr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("unexpected CST:\nexpected %s\nfound    %s", want, got)
+	}
+	if source := cst.Source(); source != input {
+		t.Errorf("CST does not reproduce the input:\nexpected %q\nfound    %q", input, source)
+	}
+	checkParents(t, cst.RootNode)
+}
+
+func TestWithCSTErrors(t *testing.T) {
+	// The scanner error is kept on the node of the rule that was running
+	// when it was found.
+	input := "one \"two"
+	var cst AST
+	_, errs := Parse(cstParse, triviaScan, bufio.NewReader(strings.NewReader(input)), WithCST(&cst))
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, found %v", errs)
+	}
+
+	statement := cst.RootNode.Children[0].Children[0]
+	if statement.Type != "cstStatement" || len(statement.Children) != 1 {
+		t.Fatalf("unexpected statement: %+v", statement)
+	}
+	if words := statement.Children[0]; len(words.Errors) != 1 {
+		t.Errorf("expected the error on %v, found %+v", words.Type, words.Errors)
+	}
+}
+
+func TestRuleName(t *testing.T) {
+	tests := []struct {
+		fn   interface{}
+		want NodeType
+	}{
+		{cstParse, "cstParse"},
+		{func(p *Parser) {}, "TestRuleName.func1"},
+		{(*Parser).WalkUp, "(*Parser).WalkUp"},
+	}
+	for _, test := range tests {
+		if got := ruleName(test.fn); got != test.want {
+			t.Errorf("expected %q, found %q", test.want, got)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// diff.go implements a structural diff between two trees. Rather than
+// comparing text, the children of each pair of matched nodes are aligned so
+// the differences are reported as nodes that were inserted, deleted, moved or
+// changed.
+//
+// Nodes are identified by a path made up of the type of each node from the
+// root and the index of the node among the siblings of the same type, for
+// example ROOT/ASSIGNMENT[1]/EXPRESSION[0] is the first EXPRESSION child of
+// the second ASSIGNMENT child of the root. Token positions are ignored so
+// moving text around without changing its meaning reports no differences.
+package dsl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+type ChangeKind int
+
+const (
+	ChangeInserted ChangeKind = iota // 0
+	ChangeDeleted
+	ChangeMoved
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeInserted:
+		return "inserted"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeMoved:
+		return "moved"
+	case ChangeModified:
+		return "changed"
+	}
+	return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Change is a single difference between two trees.
+//
+// Path is the path of the node in the new tree, except for deleted nodes
+// where it is the path in the old tree. OldPath is the path in the old tree
+// for moved and changed nodes. Old and New are the nodes in each tree, nil
+// for inserted and deleted nodes respectively.
+//
+// A changed node has the same type in both trees but different tokens. Its
+// children are compared separately and reported as their own changes.
+type Change struct {
+	Kind    ChangeKind
+	Path    string
+	OldPath string
+	Old     *Node
+	New     *Node
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeMoved:
+		return fmt.Sprintf("moved %v to %v", c.OldPath, c.Path)
+	case ChangeModified:
+		return fmt.Sprintf("changed %v: %v -> %v", c.Path, tokenLiterals(c.Old.Tokens), tokenLiterals(c.New.Tokens))
+	}
+	return c.Kind.String() + " " + c.Path
+}
+
+// Diff compares two ASTs and returns the changes required to turn the old
+// tree into the new tree. An empty result means the trees are equivalent.
+func Diff(before, after AST) []Change {
+	return DiffNodes(before.RootNode, after.RootNode)
+}
+
+// DiffNodes compares two branches. If the branches have different types
+// the result is the deletion of the old branch and the insertion of the new.
+func DiffNodes(before, after *Node) []Change {
+	d := &differ{hashes: make(map[*Node]uint64)}
+	oldPath, newPath := string(before.Type), string(after.Type)
+	if before.Type != after.Type {
+		return []Change{
+			{Kind: ChangeDeleted, Path: oldPath, Old: before},
+			{Kind: ChangeInserted, Path: newPath, New: after},
+		}
+	}
+	d.diff(before, after, oldPath, newPath)
+	return d.changes
+}
+
+// ---------------------------------------------------------------------------------------------------------
+
+type differ struct {
+	hashes  map[*Node]uint64
+	changes []Change
+}
+
+// diff compares two nodes of the same type.
+func (d *differ) diff(before, after *Node, oldPath, newPath string) {
+	if !equalTokens(before.Tokens, after.Tokens) {
+		d.changes = append(d.changes, Change{Kind: ChangeModified, Path: newPath, OldPath: oldPath, Old: before, New: after})
+	}
+
+	oldChildren, newChildren := before.Children, after.Children
+	oldPaths, newPaths := childPaths(oldPath, oldChildren), childPaths(newPath, newChildren)
+
+	// Align identical children in order. These are unchanged.
+	same := d.align(oldChildren, newChildren, func(a, b *Node) bool { return d.identical(a, b) })
+	oldUsed := make([]bool, len(oldChildren))
+	newUsed := make([]bool, len(newChildren))
+	for _, pair := range same {
+		oldUsed[pair[0]], newUsed[pair[1]] = true, true
+	}
+
+	// An identical child that is out of order has been moved.
+	for j := range newChildren {
+		if newUsed[j] {
+			continue
+		}
+		for i := range oldChildren {
+			if !oldUsed[i] && d.identical(&oldChildren[i], &newChildren[j]) {
+				oldUsed[i], newUsed[j] = true, true
+				d.changes = append(d.changes, Change{Kind: ChangeMoved, Path: newPaths[j], OldPath: oldPaths[i], Old: &oldChildren[i], New: &newChildren[j]})
+				break
+			}
+		}
+	}
+
+	// Align the remaining children by type and compare their contents.
+	var oldRest, newRest []int
+	for i, used := range oldUsed {
+		if !used {
+			oldRest = append(oldRest, i)
+		}
+	}
+	for j, used := range newUsed {
+		if !used {
+			newRest = append(newRest, j)
+		}
+	}
+	pairs := lcs(len(oldRest), len(newRest), func(i, j int) bool {
+		return oldChildren[oldRest[i]].Type == newChildren[newRest[j]].Type
+	})
+	for _, pair := range pairs {
+		i, j := oldRest[pair[0]], newRest[pair[1]]
+		oldUsed[i], newUsed[j] = true, true
+		d.diff(&oldChildren[i], &newChildren[j], oldPaths[i], newPaths[j])
+	}
+
+	for i, used := range oldUsed {
+		if !used {
+			d.changes = append(d.changes, Change{Kind: ChangeDeleted, Path: oldPaths[i], Old: &oldChildren[i]})
+		}
+	}
+	for j, used := range newUsed {
+		if !used {
+			d.changes = append(d.changes, Change{Kind: ChangeInserted, Path: newPaths[j], New: &newChildren[j]})
+		}
+	}
+}
+
+// align returns the index pairs of the longest common subsequence of the two
+// slices. Common prefixes and suffixes are matched first so the quadratic
+// search only covers the part of the slices that differs.
+func (d *differ) align(before, after []Node, eq func(a, b *Node) bool) [][2]int {
+	var pairs [][2]int
+	start := 0
+	for start < len(before) && start < len(after) && eq(&before[start], &after[start]) {
+		pairs = append(pairs, [2]int{start, start})
+		start++
+	}
+	oldEnd, newEnd := len(before), len(after)
+	var suffix [][2]int
+	for oldEnd > start && newEnd > start && eq(&before[oldEnd-1], &after[newEnd-1]) {
+		oldEnd--
+		newEnd--
+		suffix = append(suffix, [2]int{oldEnd, newEnd})
+	}
+	for _, pair := range lcs(oldEnd-start, newEnd-start, func(i, j int) bool { return eq(&before[start+i], &after[start+j]) }) {
+		pairs = append(pairs, [2]int{start + pair[0], start + pair[1]})
+	}
+	for i := len(suffix) - 1; i >= 0; i-- {
+		pairs = append(pairs, suffix[i])
+	}
+	return pairs
+}
+
+// lcs returns the index pairs of the longest common subsequence of two
+// sequences of length n and m.
+func lcs(n, m int, eq func(i, j int) bool) [][2]int {
+	if n == 0 || m == 0 {
+		return nil
+	}
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if eq(i, j) {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	var pairs [][2]int
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case eq(i, j):
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
+
+// identical reports whether two branches have the same types and tokens.
+// Branch hashes are compared first to avoid walking unequal branches.
+func (d *differ) identical(a, b *Node) bool {
+	if d.hash(a) != d.hash(b) || a.Type != b.Type || !equalTokens(a.Tokens, b.Tokens) || len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i := range a.Children {
+		if !d.identical(&a.Children[i], &b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hash returns a hash of the types and tokens of the branch, ignoring token
+// positions.
+func (d *differ) hash(n *Node) uint64 {
+	if h, ok := d.hashes[n]; ok {
+		return h
+	}
+	h := fnv.New64a()
+	h.Write([]byte(n.Type))
+	h.Write([]byte{0})
+	for _, tok := range n.Tokens {
+		h.Write([]byte(tok.ID))
+		h.Write([]byte{0})
+		h.Write([]byte(tok.Literal))
+		h.Write([]byte{0})
+	}
+	var buf [8]byte
+	for i := range n.Children {
+		binary.LittleEndian.PutUint64(buf[:], d.hash(&n.Children[i]))
+		h.Write(buf[:])
+	}
+	sum := h.Sum64()
+	d.hashes[n] = sum
+	return sum
+}
+
+func equalTokens(a, b []ASTToken) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Literal != b[i].Literal {
+			return false
+		}
+	}
+	return true
+}
+
+// childPaths returns the path of each child, indexed by the position of the
+// child among the siblings of the same type.
+func childPaths(parent string, children []Node) []string {
+	paths := make([]string, len(children))
+	counts := make(map[NodeType]int)
+	for i := range children {
+		nt := children[i].Type
+		paths[i] = parent + "/" + string(nt) + "[" + strconv.Itoa(counts[nt]) + "]"
+		counts[nt]++
+	}
+	return paths
+}
+
+func tokenLiterals(tokens []ASTToken) string {
+	literals := make([]string, len(tokens))
+	for i, tok := range tokens {
+		literals[i] = strconv.Quote(tok.Literal)
+	}
+	return "[" + strings.Join(literals, " ") + "]"
+}
@@ -0,0 +1,106 @@
+package dsl
+
+import (
+	"testing"
+)
+
+// testNode is a compact description of a node; its type, an optional literal
+// and its children.
+type testNode struct {
+	nt       NodeType
+	literal  string
+	children []testNode
+}
+
+// buildAST creates a tree with the described nodes as children of the root.
+func buildAST(nodes ...testNode) AST {
+	a := newAST()
+	var add func(n testNode)
+	add = func(n testNode) {
+		a.addNode(n.nt)
+		if n.literal != "" {
+			a.addToken([]Token{{ID: "LITERAL", Literal: n.literal}})
+		}
+		for _, child := range n.children {
+			add(child)
+		}
+		a.walkUp()
+	}
+	for _, n := range nodes {
+		add(n)
+	}
+	return a
+}
+
+func TestDiff(t *testing.T) {
+	assignment := func(name string, values ...string) testNode {
+		n := testNode{nt: "ASSIGNMENT", literal: name}
+		for _, v := range values {
+			n.children = append(n.children, testNode{nt: "TERMINAL", literal: v})
+		}
+		return n
+	}
+	comment := testNode{nt: "COMMENT", literal: "note"}
+
+	tests := []struct {
+		name     string
+		before   AST
+		after    AST
+		expected []string
+	}{
+		{
+			name:   "Identical",
+			before: buildAST(assignment("a", "1"), assignment("b", "2")),
+			after:  buildAST(assignment("a", "1"), assignment("b", "2")),
+		},
+		{
+			name:     "Inserted",
+			before:   buildAST(assignment("a", "1"), assignment("c", "3")),
+			after:    buildAST(assignment("a", "1"), assignment("b", "2"), assignment("c", "3")),
+			expected: []string{"inserted ROOT/ASSIGNMENT[1]"},
+		},
+		{
+			name:     "Deleted",
+			before:   buildAST(assignment("a", "1"), comment, assignment("b", "2")),
+			after:    buildAST(assignment("a", "1"), assignment("b", "2")),
+			expected: []string{"deleted ROOT/COMMENT[0]"},
+		},
+		{
+			name:     "Moved",
+			before:   buildAST(comment, assignment("a", "1"), assignment("b", "2")),
+			after:    buildAST(assignment("a", "1"), assignment("b", "2"), comment),
+			expected: []string{"moved ROOT/COMMENT[0] to ROOT/COMMENT[0]"},
+		},
+		{
+			name:   "Changed",
+			before: buildAST(assignment("a", "1"), assignment("b", "2", "3")),
+			after:  buildAST(assignment("a", "1"), assignment("b", "2", "4")),
+			expected: []string{
+				`changed ROOT/ASSIGNMENT[1]/TERMINAL[1]: ["3"] -> ["4"]`,
+			},
+		},
+		{
+			name:   "Changed and Inserted",
+			before: buildAST(assignment("a", "1")),
+			after:  buildAST(assignment("x", "1", "2")),
+			expected: []string{
+				`changed ROOT/ASSIGNMENT[0]: ["a"] -> ["x"]`,
+				"inserted ROOT/ASSIGNMENT[0]/TERMINAL[1]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Diff(tt.before, tt.after)
+			if len(changes) != len(tt.expected) {
+				t.Fatalf("Unexpected number of changes: got %v, want %v", changes, tt.expected)
+			}
+			for i, change := range changes {
+				if change.String() != tt.expected[i] {
+					t.Errorf("Change %d: got %q, want %q", i, change.String(), tt.expected[i])
+				}
+			}
+		})
+	}
+}
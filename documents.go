@@ -0,0 +1,124 @@
+// documents.go implements parsing a stream of documents, such as
+// newline-delimited JSON or concatenated scripts. Parse runs the parse
+// function once and returns a single AST. ParseAll runs it again and again
+// over the same reader, one AST per document, until the input ends.
+//
+// Each document is parsed by a new parser with its own AST, errors and
+// hidden tokens, but every parser reads from the same scanner, so lines and
+// positions in tokens and errors count from the start of the input rather
+// than the start of the document. A document ends when the parse function
+// returns. Tokens it read but did not consume, such as a peeked token, start
+// the next document. A parse function that reads up to TOKEN_EOF parses the
+// whole input as one document.
+
+package dsl
+
+import "bufio"
+
+// Documents iterates over the documents of an input, see ParseAll. It
+// follows bufio.Scanner: call Next to parse each document and read it with
+// AST and Errors.
+//
+//	docs := dsl.ParseAll(pf, sf, r)
+//	for docs.Next() {
+//		ast, errs := docs.AST(), docs.Errors()
+//		...
+//	}
+type Documents struct {
+	config *ParseConfig
+	s      *Scanner
+	p      *Parser // Parses the next document
+	ast    AST
+	errs   []Error
+	done   bool
+}
+
+// ParseAll returns an iterator over the documents read from r, each parsed
+// by the entry parse function pf. It takes the same options as Parse, which
+// apply to every document. With WithCST the tree holds the CST of the
+// document last returned by Next.
+func ParseAll(pf ParseFunc, sf ScanFunc, r *bufio.Reader, opts ...ParseOption) *Documents {
+	d := &Documents{config: newParseConfig(opts)}
+	d.p = d.newParser(setup(pf, sf, r, d.docConfig()))
+	d.s = d.p.s.(*Scanner)
+	d.done = d.atEOF(d.p)
+	return d
+}
+
+// Next parses the next document, returning false once there are none left.
+// A document with errors is still returned, and parsing carries on from
+// wherever its parse function stopped.
+func (d *Documents) Next() bool {
+	if d.done {
+		return false
+	}
+	p := d.p
+	p.unscan() // The token read by atEOF
+	ast, errs := p.run()
+
+	// Read ahead for the next document, so that anything after the last one
+	// is kept with it.
+	var rest string
+	consumed := len(p.buf.tokens) > p.buf.num
+	if p.eof || !consumed {
+		d.done = true
+		if p.trivia {
+			rest = d.s.remaining()
+		}
+	} else {
+		d.p = d.newParser(setupParser(p.fn, d.s, p.l, d.docConfig()))
+		d.p.buf.tokens = append(d.p.buf.tokens, p.buf.tokens[len(p.buf.tokens)-p.buf.num:]...)
+		d.p.buf.num = len(d.p.buf.tokens)
+		if d.p.buf.num == 0 && d.atEOF(d.p) {
+			d.done = true
+			rest = Source(d.p.scanned) + d.s.remaining()
+			if ast.RootNode != nil && d.p.ast.RootNode != nil {
+				ast.RootNode.Hidden = append(ast.RootNode.Hidden, d.p.ast.RootNode.Hidden...)
+			}
+		}
+	}
+
+	p.complete(&ast, rest)
+	if d.config.CST != nil {
+		*d.config.CST = *p.cst
+	}
+	d.ast, d.errs = ast, errs
+	return true
+}
+
+// AST returns the AST of the document parsed by the last call to Next.
+func (d *Documents) AST() AST {
+	return d.ast
+}
+
+// Errors returns the errors found in the document parsed by the last call to
+// Next.
+func (d *Documents) Errors() []Error {
+	return d.errs
+}
+
+// docConfig returns the configuration for the parser of a document. The CST
+// of each document is built apart from the caller's so it is only replaced
+// once the document is complete.
+func (d *Documents) docConfig() *ParseConfig {
+	config := *d.config
+	config.CST = nil
+	return &config
+}
+
+func (d *Documents) newParser(p *Parser) *Parser {
+	if d.config.CST != nil {
+		cst := newAST()
+		p.cst = &cst
+	}
+	return p
+}
+
+// atEOF reads the first token of the next document, reporting whether the
+// input has ended. The token is left on the buffer of the parser, to be
+// unread by Next.
+func (d *Documents) atEOF(p *Parser) bool {
+	tok, err := p.scan()
+	p.buf.num = 0
+	return err == nil && tok.ID == TOKEN_EOF
+}
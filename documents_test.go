@@ -0,0 +1,31 @@
+package dsl
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseAll(t *testing.T) {
+	docs := ParseAll(cstParse, triviaScan, bufio.NewReader(strings.NewReader("  ")))
+	if docs.Next() {
+		t.Errorf("expected no documents in empty input")
+	}
+
+	// cstParse reads up to TOKEN_EOF, so the input is a single document
+	var cst AST
+	docs = ParseAll(cstParse, triviaScan, bufio.NewReader(strings.NewReader("one; two")), WithCST(&cst))
+	n := 0
+	for docs.Next() {
+		n++
+		if len(docs.Errors()) != 0 {
+			t.Errorf("unexpected errors: %v", docs.Errors())
+		}
+		if len(cst.RootNode.Children) != 1 {
+			t.Errorf("expected the CST of the document, found %+v", cst.RootNode)
+		}
+	}
+	if n != 1 {
+		t.Errorf("expected 1 document, found %d", n)
+	}
+}
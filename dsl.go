@@ -8,13 +8,21 @@
 // Along with the AST, any errors will be returned in a slice containing the
 // line number and column. A log will also be produced to track any mistakes
 // in the Scan or Parse function logic including infinite loops.
+//
+// Each parse has its own Parser, Scanner and AST, so parses can run on
+// separate goroutines, see ParseFiles. A Coverage is safe to share between
+// parses running at once; a log writer or handler of WithEvents that is
+// shared must be safe for concurrent use. A returned AST may be read by any
+// number of goroutines as long as none modifies it.
 
 package dsl
 
 import (
 	"bufio"
+	"context"
 	"io"
 	"log"
+	"unsafe"
 )
 
 // Parse sets up the parser, scanner and AST ready to accept input from
@@ -25,26 +33,95 @@ import (
 // log is provided to diagnose errors in the parsing/scanning logic and can
 // be ignored once the parse/scan functions have been proven correct.
 func Parse(pf ParseFunc, sf ScanFunc, r *bufio.Reader, opts ...ParseOption) (AST, []Error) {
+	config := newParseConfig(opts)
+	return execute(pipelined(setup(pf, sf, r, config), config))
+}
 
-	config := &ParseConfig{}
+// ParseString parses the input held in src. It returns the same AST and
+// errors as Parse, but the scanner reads the runes of src in place and the
+// literal of each token is a substring of src where it can be, rather than a
+// copy.
+func ParseString(pf ParseFunc, sf ScanFunc, src string, opts ...ParseOption) (AST, []Error) {
+	config := newParseConfig(opts)
+	logger := config.logger()
+	return execute(pipelined(setupScanner(pf, newStringScanner(sf, src, logger), logger, config), config))
+}
 
-	// Apply the options
-	for _, opt := range opts {
-		opt(config)
-	}
+// ParseBytes parses the input held in b, as ParseString does, without
+// copying it. The token literals in the AST share the memory of b, so b must
+// not be modified once it has been parsed.
+func ParseBytes(pf ParseFunc, sf ScanFunc, b []byte, opts ...ParseOption) (AST, []Error) {
+	return ParseString(pf, sf, unsafe.String(unsafe.SliceData(b), len(b)), opts...)
+}
 
-	var logger logger
-	logger = &dslNoLogger{}
-	if config.LogWriter != nil {
-		logger = &dslLogger{
-			logger: log.New(config.LogWriter, "", 0),
-		}
-	}
+// setup returns a parser for the input, configured but not yet run.
+func setup(pf ParseFunc, sf ScanFunc, r *bufio.Reader, config *ParseConfig) *Parser {
+	logger := config.logger()
+	return setupScanner(pf, newScanner(sf, r, logger), logger, config)
+}
 
-	s := newScanner(sf, r, logger)
+// setupScanner configures a new scanner and returns a parser reading from it.
+func setupScanner(pf ParseFunc, s *Scanner, logger logger, config *ParseConfig) *Parser {
+	s.cov = config.Coverage
+	s.trivia = config.Trivia
+	return setupParser(pf, s, logger, config)
+}
+
+// setupParser returns a parser reading from an existing scanner.
+func setupParser(pf ParseFunc, s *Scanner, logger logger, config *ParseConfig) *Parser {
 	a := newAST()
+	if config.Events != nil {
+		a.events = &eventStream{handler: config.Events}
+	}
 	p := newParser(pf, s, a, logger)
-	return execute(p)
+	p.state = s.state
+	p.cov = config.Coverage
+	// The tokens scanned are only kept to attach their trivia to the tree,
+	// so none are kept when events are passed on in place of the tree.
+	p.trivia = config.Trivia && config.Events == nil
+	if len(config.HiddenTokens) > 0 {
+		p.HideTokens(config.HiddenTokens...)
+	}
+	p.comments = config.Comments
+	if config.CST != nil {
+		*config.CST = newAST()
+		p.cst = config.CST
+	}
+	return p
+}
+
+// Tokenize runs the user scan function over the input without a parser and
+// returns every token scanned, up to and including TOKEN_EOF. Scanning stops
+// early at the first scanner error or if the scan function stops consuming
+// input, such as a scan function that does not match the end of the input.
+//
+// Tokenize is useful for testing a scan function in isolation. With
+// WithTrivia any input left when scanning stops is added to the Trailing
+// trivia of the last token, so Source(tokens) always returns the input.
+func Tokenize(sf ScanFunc, r *bufio.Reader, opts ...ParseOption) ([]Token, []Error) {
+	config := newParseConfig(opts)
+	s := newScanner(sf, r, config.logger())
+	s.cov = config.Coverage
+	s.trivia = config.Trivia
+
+	var tokens []Token
+	var errs []Error
+	for {
+		line, pos := s.curLine, s.curPos
+		tok, err := s.scan()
+		tokens = append(tokens, tok)
+		if err != nil {
+			errs = []Error{*err}
+			break
+		}
+		if tok.ID == TOKEN_EOF || (s.curLine == line && s.curPos == pos) {
+			break
+		}
+	}
+	if s.trivia {
+		tokens[len(tokens)-1].Trailing += s.remaining()
+	}
+	return tokens, errs
 }
 
 // ParseOption is a function type that modifies ParseConfig
@@ -52,10 +129,39 @@ type ParseOption func(*ParseConfig)
 
 // ParseConfig holds the configuration for parsing
 type ParseConfig struct {
-	LogWriter io.Writer
+	LogWriter    io.Writer
+	Coverage     *Coverage       // Records grammar coverage when set, see WithCoverage
+	Trivia       bool            // Keeps skipped runes as trivia on tokens, see WithTrivia
+	HiddenTokens []TokenType     // Token types hidden from the user parse function, see WithHiddenTokens
+	Comments     []TokenType     // Token types attached to the AST as comments, see WithComments
+	CST          *AST            // Receives the concrete syntax tree when set, see WithCST
+	Events       EventHandler    // Receives the AST as events instead of a tree when set, see WithEvents
+	Workers      int             // The number of files parsed at once by ParseFiles, see WithWorkers
+	Pipeline     int             // The number of tokens the scanner may scan ahead, see WithPipeline
+	Context      context.Context // Cancels a pipelined parse, see WithPipeline
 	// Add other configuration options here as needed
 }
 
+func newParseConfig(opts []ParseOption) *ParseConfig {
+	config := &ParseConfig{}
+
+	// Apply the options
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// logger returns the logger to be shared by the scanner and parser.
+func (c *ParseConfig) logger() logger {
+	if c.LogWriter != nil {
+		return &dslLogger{
+			logger: log.New(c.LogWriter, "", 0),
+		}
+	}
+	return &dslNoLogger{}
+}
+
 // WithLogger returns a ParseOption that sets the log writer
 func WithLogger(w io.Writer) ParseOption {
 	return func(c *ParseConfig) {
@@ -64,10 +170,68 @@ func WithLogger(w io.Writer) ParseOption {
 }
 
 func execute(p *Parser) (AST, []Error) {
+	ast, errors := p.run()
+	p.stop(&ast)
+	return ast, errors
+}
+
+// stop stops the scanner once the parse function has returned and completes
+// the AST and the CST.
+func (p *Parser) stop(ast *AST) {
+	s := p.scanner() // Stops a pipelined scanner
+	var rest string
+	if s != nil && p.trivia {
+		rest = s.remaining()
+	}
+	p.complete(ast, rest)
+}
+
+// run calls the user parse function.
+func (p *Parser) run() (AST, []Error) {
 	pf := p.fn
 	p.log("Line 1: ", prefixNone)
-	p.log("Parsing: "+getFuncName(pf), prefixNewline)
+	if p.trace {
+		p.log("Parsing: "+getFuncName(pf), prefixNewline)
+	}
+	p.cstEnter(pf)
 	ast, errors := pf(p)
-	p.log("Returning: "+getFuncName(pf), prefixDecrement)
+	p.cstLeave()
+	p.cstFlush()
+	if p.trace {
+		p.log("Returning: "+getFuncName(pf), prefixDecrement)
+	}
 	return ast, errors
 }
+
+// complete finishes the AST and the CST once the parse function has
+// returned. rest is the input left unread, kept as trivia.
+func (p *Parser) complete(ast *AST, rest string) {
+	p.finish(ast, rest)
+	if p.cst != nil {
+		p.finish(p.cst, rest)
+	}
+}
+
+// finish completes a tree once the parse function has returned.
+func (p *Parser) finish(a *AST, rest string) {
+	if a.RootNode == nil {
+		return
+	}
+	if a.events != nil {
+		a.events.close()
+		a.events = nil
+		return
+	}
+
+	// Appending a child can move its siblings, leaving the Parent references
+	// of their children pointing at the old copies.
+	linkParents(a.RootNode)
+
+	if len(p.comments) > 0 {
+		a.AttachComments(p.comments...)
+	}
+
+	if p.trivia {
+		attachTrivia(a.RootNode, p.scanned, rest)
+	}
+}
@@ -0,0 +1,248 @@
+// Package dsltest runs golden file tests against a grammar built with the
+// dsl package.
+//
+// Each file named *.input in a test directory is parsed and the results are
+// compared against golden files with the same base name:
+//
+//	name.ast     the AST as an S-expression (see dsl.AST.MarshalSExpr)
+//	name.errors  one line per error; absent when no errors are expected
+//	name.tokens  one line per token, only compared when WithTokens is used
+//
+// Running the tests with the -update flag rewrites the golden files from the
+// current results instead of comparing them:
+//
+//	go test ./... -update
+package dsltest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/dezlitz/dsl"
+)
+
+var update = flag.Bool("update", false, "update the dsltest golden files")
+
+// Option is a function type that modifies the test configuration
+type Option func(*config)
+
+type config struct {
+	tokens       bool
+	logDir       string
+	parseOptions []dsl.ParseOption
+}
+
+// WithTokens returns an Option that also compares the token stream produced
+// by the scan function on its own against the name.tokens golden file.
+func WithTokens() Option {
+	return func(c *config) {
+		c.tokens = true
+	}
+}
+
+// WithLogDir returns an Option that writes the parser log for each input to
+// name.log in dir.
+func WithLogDir(dir string) Option {
+	return func(c *config) {
+		c.logDir = dir
+	}
+}
+
+// WithParseOptions returns an Option that passes opts to every call to
+// dsl.Parse and dsl.Tokenize.
+func WithParseOptions(opts ...dsl.ParseOption) Option {
+	return func(c *config) {
+		c.parseOptions = append(c.parseOptions, opts...)
+	}
+}
+
+// Run parses every *.input file in dir as a subtest named after the file and
+// compares the results with the golden files.
+func Run(t *testing.T, dir string, pf dsl.ParseFunc, sf dsl.ScanFunc, opts ...Option) {
+	t.Helper()
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	inputs, err := filepath.Glob(filepath.Join(dir, "*.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatalf("no *.input files found in %v", dir)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		t.Run(name, func(t *testing.T) {
+			runFile(t, c, strings.TrimSuffix(input, ".input"), pf, sf)
+		})
+	}
+}
+
+func runFile(t testing.TB, c *config, base string, pf dsl.ParseFunc, sf dsl.ScanFunc) {
+	source, err := os.ReadFile(base + ".input")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parseOptions := c.parseOptions
+	if c.logDir != "" {
+		logfilename := filepath.Join(c.logDir, filepath.Base(base)+".log")
+		logfile, err := os.Create(logfilename)
+		if err != nil {
+			t.Fatal("Error: Could not create log file " + logfilename + ": " + err.Error())
+		}
+		defer logfile.Close()
+		parseOptions = append(parseOptions[:len(parseOptions):len(parseOptions)], dsl.WithLogger(logfile))
+	}
+
+	ast, errs := dsl.Parse(pf, sf, bufio.NewReader(bytes.NewReader(source)), parseOptions...)
+
+	actualAST, err := ast.MarshalSExpr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareAST(t, base+".ast", actualAST)
+	compareGolden(t, base+".errors", FormatErrors(errs), true)
+
+	if c.tokens {
+		tokens, _ := dsl.Tokenize(sf, bufio.NewReader(bytes.NewReader(source)), c.parseOptions...)
+		compareGolden(t, base+".tokens", FormatTokens(tokens), false)
+	}
+}
+
+// compareAST compares the S-expression of the AST with the golden file. When
+// they differ the structural differences are reported if there are any,
+// otherwise only token positions differ and the first differing line is
+// reported.
+func compareAST(t testing.TB, path string, actual []byte) {
+	t.Helper()
+	if *update {
+		writeGolden(t, path, actual, false)
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run the tests with -update to create it", err)
+	}
+	if bytes.Equal(expected, actual) {
+		return
+	}
+
+	var expectedAST, actualAST dsl.AST
+	if err := expectedAST.UnmarshalSExpr(expected); err != nil {
+		t.Fatalf("%v: %v", path, err)
+	}
+	if err := actualAST.UnmarshalSExpr(actual); err != nil {
+		t.Fatal(err)
+	}
+	if changes := dsl.Diff(expectedAST, actualAST); len(changes) > 0 {
+		for _, change := range changes {
+			t.Errorf("%v: %v", path, change)
+		}
+		return
+	}
+	t.Errorf("%v: %v", path, firstDifference(string(expected), string(actual)))
+}
+
+// compareGolden compares text with the golden file. If optional is true a
+// missing file is the same as an empty one, and updating with empty text
+// removes the file.
+func compareGolden(t testing.TB, path string, actual string, optional bool) {
+	t.Helper()
+	if *update {
+		writeGolden(t, path, []byte(actual), optional)
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && optional {
+		err = nil
+	}
+	if err != nil {
+		t.Fatalf("%v; run the tests with -update to create it", err)
+	}
+	if string(expected) != actual {
+		t.Errorf("%v: %v", path, firstDifference(string(expected), actual))
+	}
+}
+
+func writeGolden(t testing.TB, path string, data []byte, optional bool) {
+	t.Helper()
+	if optional && len(data) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(err)
+		}
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// firstDifference describes the first line that differs between two texts.
+func firstDifference(expected, actual string) string {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var e, a string
+		if i < len(expectedLines) {
+			e = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+		if e != a {
+			return fmt.Sprintf("line %d differs\nexpected: %s\nactual:   %s", i+1, e, a)
+		}
+	}
+	return "files differ"
+}
+
+// FormatErrors returns the errors as they are written to golden files, one
+// per line as the start and end positions followed by the code and message.
+// Control characters in the message are escaped as in a Go string literal,
+// so the golden file stays a line per error and plain text.
+func FormatErrors(errs []dsl.Error) string {
+	var buf strings.Builder
+	for _, err := range errs {
+		fmt.Fprintf(&buf, "%d:%d-%d:%d %v: %v\n", err.StartLine, err.StartPosition, err.EndLine, err.EndPosition, err.Code, escapeControl(err.Message))
+	}
+	return buf.String()
+}
+
+// escapeControl escapes the control characters in s.
+func escapeControl(s string) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+	var buf strings.Builder
+	for _, rn := range s {
+		if unicode.IsControl(rn) {
+			q := strconv.QuoteRune(rn)
+			buf.WriteString(q[1 : len(q)-1])
+		} else {
+			buf.WriteRune(rn)
+		}
+	}
+	return buf.String()
+}
+
+// FormatTokens returns the tokens as they are written to golden files, one
+// per line as the position followed by the type and quoted literal.
+func FormatTokens(tokens []dsl.Token) string {
+	var buf strings.Builder
+	for _, tok := range tokens {
+		fmt.Fprintf(&buf, "%d:%d %v %v\n", tok.Line, tok.Position, tok.ID, strconv.Quote(tok.Literal))
+	}
+	return buf.String()
+}
@@ -0,0 +1,210 @@
+package dsltest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dezlitz/dsl"
+)
+
+// wordScan scans lower case words separated by spaces.
+func wordScan(s *dsl.Scanner) dsl.Token {
+	s.Expect(dsl.ExpectRune{
+		Branches: []dsl.Branch{{Rn: ' '}, {Rn: '\n'}},
+		Options:  dsl.ExpectRuneOptions{Optional: true, Multiple: true, Skip: true},
+	})
+	s.Expect(dsl.ExpectRune{
+		Branches: []dsl.Branch{{Rn: rune(0), Fn: func(s *dsl.Scanner) { s.Match([]dsl.Match{{ID: dsl.TOKEN_EOF}}) }}},
+		BranchRanges: []dsl.BranchRange{{StartRn: 'a', EndRn: 'z', Fn: func(s *dsl.Scanner) {
+			s.Expect(dsl.ExpectRune{BranchRanges: []dsl.BranchRange{{StartRn: 'a', EndRn: 'z'}}, Options: dsl.ExpectRuneOptions{Optional: true, Multiple: true}})
+			s.Match([]dsl.Match{{ID: "WORD"}})
+		}}},
+	})
+	return s.Exit()
+}
+
+// wordParse adds a node for each word.
+func wordParse(p *dsl.Parser) (dsl.AST, []dsl.Error) {
+	p.Expect(dsl.ExpectToken{
+		Branches: []dsl.BranchToken{{Id: "WORD", Fn: func(p *dsl.Parser) {
+			p.AddNode("WORD")
+			p.AddTokens()
+			p.WalkUp()
+		}}},
+		Options: dsl.ParseOptions{Multiple: true},
+	})
+	return p.Exit()
+}
+
+// recorder is a testing.TB recording failures rather than failing the test.
+// Fatalf stops the function under test with a panic caught by record.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+type fatal struct{}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	panic(fatal{})
+}
+
+func (r *recorder) Fatal(args ...interface{}) {
+	r.Fatalf("%v", fmt.Sprint(args...))
+}
+
+// record runs fn with a recorder and returns the failures it reported.
+func record(t *testing.T, fn func(tb testing.TB)) []string {
+	r := &recorder{TB: t}
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				if _, ok := v.(fatal); !ok {
+					panic(v)
+				}
+			}
+		}()
+		fn(r)
+	}()
+	return r.errors
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// setUpdate sets the -update flag for the rest of the test.
+func setUpdate(t *testing.T, v bool) {
+	old := *update
+	*update = v
+	t.Cleanup(func() { *update = old })
+}
+
+func TestRunUpdate(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "words.input"), "one two")
+	writeFile(t, filepath.Join(dir, "bad.input"), "one 2")
+	writeFile(t, filepath.Join(dir, "bad.errors"), "stale")
+	logDir := t.TempDir()
+
+	setUpdate(t, true)
+	Run(t, dir, wordParse, wordScan, WithTokens(), WithLogDir(logDir))
+
+	if ast := readFile(t, filepath.Join(dir, "words.ast")); ast != "(ROOT\n  (WORD (WORD \"one\" 1:1))\n  (WORD (WORD \"two\" 1:5)))\n" {
+		t.Errorf("unexpected AST golden file %q", ast)
+	}
+	if tokens := readFile(t, filepath.Join(dir, "words.tokens")); tokens != "1:1 WORD \"one\"\n1:5 WORD \"two\"\n1:8 EOF \"\"\n" {
+		t.Errorf("unexpected tokens golden file %q", tokens)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "words.errors")); !os.IsNotExist(err) {
+		t.Errorf("expected no errors golden file for an input without errors, found %v", err)
+	}
+	if errs := readFile(t, filepath.Join(dir, "bad.errors")); !strings.HasPrefix(errs, "1:4-1:5 RuneExpectedNotFound: ") {
+		t.Errorf("unexpected errors golden file %q", errs)
+	}
+	if log := readFile(t, filepath.Join(logDir, "words.log")); !strings.Contains(log, "wordParse") {
+		t.Errorf("expected the parse to be logged, found %q", log)
+	}
+
+	// The golden files just written match
+	setUpdate(t, false)
+	Run(t, dir, wordParse, wordScan, WithTokens())
+}
+
+func TestRunMismatch(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "words")
+	writeFile(t, base+".input", "one two")
+
+	failures := record(t, func(tb testing.TB) { runFile(tb, &config{}, base, wordParse, wordScan) })
+	if len(failures) != 1 || !strings.Contains(failures[0], "run the tests with -update") {
+		t.Errorf("expected a missing golden file to be reported, found %q", failures)
+	}
+
+	// A structural difference is reported as the changes found by dsl.Diff
+	writeFile(t, base+".ast", "(ROOT\n  (WORD (WORD \"one\" 1:1))\n  (WORD (WORD \"six\" 1:5)))\n")
+	failures = record(t, func(tb testing.TB) { runFile(tb, &config{}, base, wordParse, wordScan) })
+	if len(failures) != 1 || !strings.Contains(failures[0], `"six"`) || !strings.Contains(failures[0], `"two"`) {
+		t.Errorf("expected the changed token to be reported, found %q", failures)
+	}
+
+	// Otherwise only positions differ and the first differing line is shown
+	writeFile(t, base+".ast", "(ROOT\n  (WORD (WORD \"one\" 1:1))\n  (WORD (WORD \"two\" 1:6)))\n")
+	failures = record(t, func(tb testing.TB) { runFile(tb, &config{}, base, wordParse, wordScan) })
+	if len(failures) != 1 || !strings.Contains(failures[0], "line 3 differs") {
+		t.Errorf("expected the differing line to be reported, found %q", failures)
+	}
+
+	// Tokens are only compared with WithTokens
+	writeFile(t, base+".ast", "(ROOT\n  (WORD (WORD \"one\" 1:1))\n  (WORD (WORD \"two\" 1:5)))\n")
+	writeFile(t, base+".tokens", "1:1 WORD \"one\"\n")
+	if failures = record(t, func(tb testing.TB) { runFile(tb, &config{}, base, wordParse, wordScan) }); len(failures) != 0 {
+		t.Errorf("expected tokens not to be compared, found %q", failures)
+	}
+	failures = record(t, func(tb testing.TB) { runFile(tb, &config{tokens: true}, base, wordParse, wordScan) })
+	if len(failures) != 1 || !strings.Contains(failures[0], "words.tokens: line 2 differs") {
+		t.Errorf("expected the token mismatch to be reported, found %q", failures)
+	}
+
+	// Unexpected errors are reported against the missing errors file
+	writeFile(t, base+".input", "one 2")
+	failures = record(t, func(tb testing.TB) { runFile(tb, &config{}, base, wordParse, wordScan) })
+	if len(failures) == 0 || !strings.Contains(failures[len(failures)-1], "words.errors: line 1 differs") {
+		t.Errorf("expected the unexpected error to be reported, found %q", failures)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	errs := []dsl.Error{{Code: dsl.ErrorRuneExpectedNotFound, Message: "found [\x00], expected \"\t\"", StartLine: 1, StartPosition: 2, EndLine: 1, EndPosition: 3}}
+	if found, expected := FormatErrors(errs), "1:2-1:3 RuneExpectedNotFound: found [\\x00], expected \"\\t\"\n"; found != expected {
+		t.Errorf("expected %q, found %q", expected, found)
+	}
+}
+
+// slowParse takes a while over each word, as a parse that is slow but still
+// reading tokens.
+func slowParse(p *dsl.Parser) (dsl.AST, []dsl.Error) {
+	p.Expect(dsl.ExpectToken{
+		Branches: []dsl.BranchToken{{Id: "WORD", Fn: func(p *dsl.Parser) { time.Sleep(10 * time.Millisecond) }}},
+		Options:  dsl.ParseOptions{Multiple: true},
+	})
+	return p.Exit()
+}
+
+func TestCheckParse(t *testing.T) {
+	if failures := record(t, func(tb testing.TB) { CheckParse(tb, wordParse, wordScan, "one two\nthree") }); len(failures) != 0 {
+		t.Errorf("expected the parse to pass, found %q", failures)
+	}
+
+	old := LoopTimeout
+	LoopTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { LoopTimeout = old })
+	input := strings.Repeat("word ", 1000)
+	failures := record(t, func(tb testing.TB) { CheckParse(tb, slowParse, wordScan, input) })
+	if len(failures) != 1 || !strings.Contains(failures[0], "the parse stopped once cancelled") {
+		t.Errorf("expected the timed out parse to be cancelled, found %q", failures)
+	}
+}
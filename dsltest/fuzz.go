@@ -0,0 +1,166 @@
+// fuzz.go fuzzes a grammar with Go's native fuzzing, checking every parse for
+// panics, runaway loops, error spans outside the input and broken Parent links.
+
+package dsltest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dezlitz/dsl"
+)
+
+// LoopTimeout is how long a single parse may run during fuzzing before it is
+// reported as a runaway loop that escaped the parser's infinite loop detection.
+var LoopTimeout = 2 * time.Second
+
+// FuzzGrammar fuzzes a grammar with Go's native fuzzing, using seeds and the
+// files in testdata/fuzz/<FuzzTestName> as the seed corpus. Each input is
+// parsed and the test fails if the parse panics, does not finish within
+// LoopTimeout, returns an error whose span falls outside the input or
+// returns an AST with inconsistent Parent references.
+//
+//	func FuzzMyDSL(f *testing.F) {
+//		dsltest.FuzzGrammar(f, Parse, Scan, dsltest.ReadInputs(f, "testdata")...)
+//	}
+func FuzzGrammar(f *testing.F, pf dsl.ParseFunc, sf dsl.ScanFunc, seeds ...string) {
+	f.Helper()
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		CheckParse(t, pf, sf, input)
+	})
+}
+
+// ReadInputs returns the contents of every *.input file in dir so golden
+// test inputs can be used as fuzzing seeds.
+func ReadInputs(tb testing.TB, dir string) []string {
+	tb.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.input"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var inputs []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		inputs = append(inputs, string(data))
+	}
+	return inputs
+}
+
+// CheckParse parses the input and fails the test if the parse panics, does
+// not finish within LoopTimeout, returns an error whose span falls outside the
+// input or returns an AST with inconsistent Parent references.
+//
+// The parse runs on its own goroutine with WithPipeline. Once it has timed out
+// its context is cancelled, so a parse that is still reading tokens reads the
+// end of the input and returns. A parse reading the same token over and over
+// is already stopped by the parser with ErrorInfiniteLoopDetected. A loop that
+// never asks for another token cannot be stopped, such as one inside a scan
+// function or a parse function looping without calling Expect. Its goroutine
+// keeps running until the test binary exits, so fix the loop before fuzzing
+// on.
+func CheckParse(t testing.TB, pf dsl.ParseFunc, sf dsl.ScanFunc, input string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		ast   dsl.AST
+		errs  []dsl.Error
+		panic interface{}
+		stack []byte
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			if r.panic = recover(); r.panic != nil {
+				r.stack = debug.Stack()
+			}
+			done <- r
+		}()
+		r.ast, r.errs = dsl.Parse(pf, sf, bufio.NewReader(strings.NewReader(input)), dsl.WithPipeline(ctx, 1))
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(LoopTimeout):
+		cancel()
+		stopped := "the parse stopped once cancelled"
+		select {
+		case <-done:
+		case <-time.After(LoopTimeout):
+			stopped = "the parse is still running as it no longer asks for tokens"
+		}
+		t.Fatalf("parse did not finish within %v, possible runaway loop, %v\ninput: %q", LoopTimeout, stopped, input)
+	}
+	if r.panic != nil {
+		t.Fatalf("parse panicked: %v\ninput: %q\n%s", r.panic, input, r.stack)
+	}
+
+	lines := strings.Split(input, "\n")
+	for _, err := range r.errs {
+		if msg := checkSpan(err, lines); msg != "" {
+			t.Errorf("error span %d:%d-%d:%d %v\ninput: %q\n%v", err.StartLine, err.StartPosition, err.EndLine, err.EndPosition, msg, input, err.Message)
+		}
+	}
+
+	if r.ast.RootNode == nil {
+		t.Fatalf("parse returned an AST without a root node\ninput: %q", input)
+	}
+	if r.ast.RootNode.Parent != nil {
+		t.Errorf("root node has a parent\ninput: %q", input)
+	}
+	if path := checkParents(r.ast.RootNode, string(r.ast.RootNode.Type)); path != "" {
+		t.Errorf("node %v has an inconsistent Parent reference\ninput: %q", path, input)
+	}
+}
+
+// checkSpan describes why the error span falls outside the input, or returns
+// an empty string if it does not. A span may end one position past the end of
+// a line to point at a newline or the end of the input.
+func checkSpan(err dsl.Error, lines []string) string {
+	if err.StartLine < 1 || err.EndLine > len(lines) {
+		return fmt.Sprintf("is outside lines 1-%d", len(lines))
+	}
+	if err.EndLine < err.StartLine || (err.EndLine == err.StartLine && err.EndPosition < err.StartPosition) {
+		return "ends before it starts"
+	}
+	if max := utf8.RuneCountInString(lines[err.StartLine-1]) + 1; err.StartPosition < 1 || err.StartPosition > max {
+		return fmt.Sprintf("starts outside positions 1-%d", max)
+	}
+	if max := utf8.RuneCountInString(lines[err.EndLine-1]) + 1; err.EndPosition < 1 || err.EndPosition > max {
+		return fmt.Sprintf("ends outside positions 1-%d", max)
+	}
+	return ""
+}
+
+// checkParents returns the path of the first node whose children do not
+// refer back to it, or an empty string if every Parent reference is correct.
+func checkParents(n *dsl.Node, path string) string {
+	for i := range n.Children {
+		child := &n.Children[i]
+		childPath := fmt.Sprintf("%v/%v[%d]", path, child.Type, i)
+		if child.Parent != n {
+			return childPath
+		}
+		if p := checkParents(child, childPath); p != "" {
+			return p
+		}
+	}
+	return ""
+}
@@ -3,6 +3,7 @@ package dsl
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 )
 
 type ErrorCode int
@@ -15,10 +16,37 @@ const (
 	ErrorNodeNotInNodeSet
 	ErrorNoTokensToGet
 	ErrorInfiniteLoopDetected
+	ErrorParseCancelled
+	ErrorInvalidValue
 )
 
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorFileNotFound:
+		return "FileNotFound"
+	case ErrorCouldNotCreateFile:
+		return "CouldNotCreateFile"
+	case ErrorTokenExpectedNotFound:
+		return "TokenExpectedNotFound"
+	case ErrorRuneExpectedNotFound:
+		return "RuneExpectedNotFound"
+	case ErrorNodeNotInNodeSet:
+		return "NodeNotInNodeSet"
+	case ErrorNoTokensToGet:
+		return "NoTokensToGet"
+	case ErrorInfiniteLoopDetected:
+		return "InfiniteLoopDetected"
+	case ErrorParseCancelled:
+		return "ParseCancelled"
+	case ErrorInvalidValue:
+		return "InvalidValue"
+	}
+	return "ErrorCode(" + strconv.Itoa(int(c)) + ")"
+}
+
 // Error contains the error text, the line and positions the error occurred on, and
-// a string containing the input text from that line.
+// a string containing the input text from that line. File names the file
+// the error was found in when parsed with ParseFiles.
 type Error struct {
 	Code          ErrorCode
 	Message       string
@@ -27,12 +55,17 @@ type Error struct {
 	StartPosition int
 	EndLine       int
 	EndPosition   int
+	File          string `json:",omitempty"`
 }
 
 // Error implements the error interface.
 func (e *Error) Error() string {
 	var buf bytes.Buffer
-	buf.WriteString(fmt.Sprintf("\nError Line:%v %v\n", e.StartLine, e.Message))
+	if e.File != "" {
+		buf.WriteString(fmt.Sprintf("\nError File:%v Line:%v %v\n", e.File, e.StartLine, e.Message))
+	} else {
+		buf.WriteString(fmt.Sprintf("\nError Line:%v %v\n", e.StartLine, e.Message))
+	}
 	buf.WriteString(e.LineString + "\n")
 	for i := 0; i < e.StartPosition-1; i++ {
 		if i < len(e.LineString) && e.LineString[i] == '\t' {
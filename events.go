@@ -0,0 +1,109 @@
+// events.go implements event-based parsing, in the style of SAX. With
+// WithEvents the calls a parse function makes to build the AST, AddNode,
+// AddTokens and WalkUp, are passed to a handler as start node, token and end
+// node events instead of adding nodes to the tree, along with the hidden
+// tokens and errors that would be kept on the current node. The same parse
+// function builds a tree or streams events depending on the option, and the
+// memory used for the tree is bounded by how deeply the nodes nest rather
+// than by the size of the input.
+//
+// The AST returned by the parse holds only an empty root. Comments are not
+// attached and trivia only holds the runes skipped around each token, as
+// both need the whole tree. WithCST still builds the concrete syntax tree.
+
+package dsl
+
+// EventKind identifies what an Event reports.
+type EventKind int
+
+const (
+	EventStartNode EventKind = iota // AddNode started a node
+	EventToken                      // AddTokens added a token to the current node
+	EventEndNode                    // WalkUp ended the current node
+	EventHidden                     // A hidden token was read in the current node
+	EventError                      // An error was found in the current node
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStartNode:
+		return "start"
+	case EventToken:
+		return "token"
+	case EventEndNode:
+		return "end"
+	case EventHidden:
+		return "hidden"
+	case EventError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Event is passed to an EventHandler for every change the parse function
+// would make to the AST. Type is the node started or ended, or the current
+// node for the other events. Depth is how many nodes are open, counting the
+// node started or ended but not the root, so a top-level node has depth 1.
+type Event struct {
+	Kind  EventKind
+	Type  NodeType
+	Token ASTToken // Set for EventToken and EventHidden
+	Error Error    // Set for EventError
+	Depth int
+}
+
+// EventHandler receives the events of a parse, see WithEvents.
+type EventHandler func(Event)
+
+// WithEvents returns a ParseOption that passes the changes the parse
+// function makes to the AST to the handler as events instead of building the
+// tree. Every node started is ended, if the parse function does not walk
+// back up to the root the nodes still open are ended once it returns.
+func WithEvents(handler EventHandler) ParseOption {
+	return func(c *ParseConfig) {
+		c.Events = handler
+	}
+}
+
+// eventStream stands in for the nodes of an AST parsed with WithEvents. Only
+// the types of the open nodes are kept.
+type eventStream struct {
+	handler EventHandler
+	open    []NodeType
+}
+
+func (e *eventStream) current() NodeType {
+	if len(e.open) == 0 {
+		return NODE_ROOT
+	}
+	return e.open[len(e.open)-1]
+}
+
+func (e *eventStream) startNode(nt NodeType) {
+	e.open = append(e.open, nt)
+	e.handler(Event{Kind: EventStartNode, Type: nt, Depth: len(e.open)})
+}
+
+func (e *eventStream) endNode() {
+	if len(e.open) == 0 {
+		return
+	}
+	nt := e.open[len(e.open)-1]
+	e.handler(Event{Kind: EventEndNode, Type: nt, Depth: len(e.open)})
+	e.open = e.open[:len(e.open)-1]
+}
+
+func (e *eventStream) token(kind EventKind, tok Token) {
+	e.handler(Event{Kind: kind, Type: e.current(), Token: newASTToken(tok), Depth: len(e.open)})
+}
+
+func (e *eventStream) error(err Error) {
+	e.handler(Event{Kind: EventError, Type: e.current(), Error: err, Depth: len(e.open)})
+}
+
+// close ends every node still open.
+func (e *eventStream) close() {
+	for len(e.open) > 0 {
+		e.endNode()
+	}
+}
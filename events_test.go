@@ -0,0 +1,63 @@
+package dsl
+
+import "testing"
+
+func TestEventStream(t *testing.T) {
+	var got []string
+	a := newAST()
+	a.events = &eventStream{handler: func(e Event) {
+		s := e.Kind.String() + " " + string(e.Type)
+		switch e.Kind {
+		case EventToken, EventHidden:
+			s += " " + e.Token.Literal
+		case EventError:
+			s += " " + e.Error.Message
+		}
+		got = append(got, s)
+	}}
+	a.addNode("CALL")
+	a.addToken([]Token{{ID: "WORD", Literal: "f"}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "# f"})
+	a.walkUp()
+	a.walkUp() // At the root, ignored as in a tree
+	a.addNode("CALL")
+	a.addNode("ARG")
+	a.addError(Error{Message: "broken"})
+	(&Parser{}).finish(&a, "")
+
+	want := []string{
+		"start CALL", "token CALL f", "hidden CALL # f", "end CALL",
+		"start CALL", "start ARG", "error ARG broken", "end ARG", "end CALL",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, found %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d: expected %q, found %q", i, want[i], got[i])
+		}
+	}
+	if len(a.RootNode.Children) != 0 || a.events != nil {
+		t.Errorf("expected an empty tree once finished, found %+v", a.RootNode)
+	}
+}
+
+func TestEventsKeepNoTokens(t *testing.T) {
+	var leading []string
+	config := newParseConfig([]ParseOption{WithTrivia(), WithEvents(func(e Event) {
+		if e.Kind == EventToken {
+			leading = append(leading, e.Token.Leading)
+		}
+	})})
+	p := setupScanner(triviaParse, newStringScanner(triviaScan, "say  \"hi\" ; bye", &dslNoLogger{}), &dslNoLogger{}, config)
+	if _, errs := execute(p); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(p.scanned) != 0 {
+		t.Errorf("expected no tokens kept for trivia, found %d", len(p.scanned))
+	}
+	// The tokens passed on still hold their trivia
+	if len(leading) != 3 || leading[1] != "  \"" {
+		t.Errorf("expected the trivia of each token, found %q", leading)
+	}
+}
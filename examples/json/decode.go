@@ -0,0 +1,140 @@
+package json
+
+import (
+	"github.com/dezlitz/dsl"
+)
+
+// Decode parses a JSON document straight into Go values, with typed rules in
+// place of an AST. Objects are decoded as map[string]interface{}, arrays as
+// []interface{} and the other values as the value of their token; a string,
+// an int64 or float64, a bool or nil. If the document is not valid JSON nil
+// is returned along with the errors.
+func Decode(src string, opts ...dsl.ParseOption) (interface{}, []dsl.Error) {
+	v, errs := dsl.ParseStringTyped(decodeDocument, Scan, src, opts...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return v, nil
+}
+
+// member is a member of an object.
+type member struct {
+	key   string
+	value interface{}
+}
+
+func decodeDocument(p *dsl.Parser) interface{} {
+	return first(dsl.Expect(p, dsl.ExpectTyped[interface{}]{
+		Branches: []dsl.BranchTyped[interface{}]{
+			{Id: TOKEN_LBRACE, Fn: decodeObject},
+			{Id: TOKEN_LBRACKET, Fn: decodeArray},
+		},
+	}))
+}
+
+func decodeObject(p *dsl.Parser) interface{} {
+	// The first member is optional, but once read a comma must be followed
+	// by another, so an object is either empty or closed after a member
+	members := dsl.Expect(p, dsl.ExpectTyped[member]{
+		Branches: []dsl.BranchTyped[member]{
+			{Id: TOKEN_STRING, Fn: decodeMember},
+		},
+		Options: dsl.ParseOptions{Optional: true},
+	})
+	if len(members) > 0 {
+		members = append(members, dsl.Expect(p, dsl.ExpectTyped[member]{
+			Branches: []dsl.BranchTyped[member]{
+				{Id: TOKEN_COMMA, Fn: decodeNextMember},
+			},
+			Options: dsl.ParseOptions{Multiple: true, Optional: true, Skip: true},
+		})...)
+	}
+
+	p.Expect(dsl.ExpectToken{
+		Branches: []dsl.BranchToken{
+			{Id: TOKEN_RBRACE, Fn: nil},
+		},
+	})
+
+	object := make(map[string]interface{}, len(members))
+	for _, m := range members {
+		object[m.key] = m.value
+	}
+	return object
+}
+
+func decodeMember(p *dsl.Parser) member {
+	key, _ := p.GetToken().Value.(string)
+	p.Expect(dsl.ExpectToken{
+		Branches: []dsl.BranchToken{
+			{Id: TOKEN_COLON, Fn: nil},
+		},
+		Options: dsl.ParseOptions{Skip: true},
+	})
+	return member{key: key, value: dsl.Call(p, decodeValue)}
+}
+
+func decodeNextMember(p *dsl.Parser) member {
+	return first(dsl.Expect(p, dsl.ExpectTyped[member]{
+		Branches: []dsl.BranchTyped[member]{
+			{Id: TOKEN_STRING, Fn: decodeMember},
+		},
+	}))
+}
+
+func decodeValue(p *dsl.Parser) interface{} {
+	return first(dsl.Expect(p, dsl.ExpectTyped[interface{}]{Branches: valueBranches()}))
+}
+
+func decodeArray(p *dsl.Parser) interface{} {
+	// As for objects, the values after the first follow a comma each
+	array := dsl.Expect(p, dsl.ExpectTyped[interface{}]{
+		Branches: valueBranches(),
+		Options:  dsl.ParseOptions{Optional: true},
+	})
+	if len(array) > 0 {
+		array = append(array, dsl.Expect(p, dsl.ExpectTyped[interface{}]{
+			Branches: []dsl.BranchTyped[interface{}]{
+				{Id: TOKEN_COMMA, Fn: decodeValue},
+			},
+			Options: dsl.ParseOptions{Multiple: true, Optional: true, Skip: true},
+		})...)
+	}
+
+	p.Expect(dsl.ExpectToken{
+		Branches: []dsl.BranchToken{
+			{Id: TOKEN_RBRACKET, Fn: nil},
+		},
+	})
+	if array == nil {
+		array = []interface{}{}
+	}
+	return array
+}
+
+// valueBranches returns the branches of a value. It is a function rather than
+// a variable as the branches refer back to it through decodeArray.
+func valueBranches() []dsl.BranchTyped[interface{}] {
+	return []dsl.BranchTyped[interface{}]{
+		{Id: TOKEN_STRING, Fn: tokenValue},
+		{Id: TOKEN_NUMBER, Fn: tokenValue},
+		{Id: TOKEN_TRUE, Fn: tokenValue},
+		{Id: TOKEN_FALSE, Fn: tokenValue},
+		{Id: TOKEN_NULL, Fn: tokenValue},
+		{Id: TOKEN_LBRACE, Fn: decodeObject},
+		{Id: TOKEN_LBRACKET, Fn: decodeArray},
+	}
+}
+
+func tokenValue(p *dsl.Parser) interface{} {
+	return p.GetToken().Value
+}
+
+// first returns the value of the branch taken, or the zero value if none was.
+func first[T any](values []T) T {
+	var v T
+	if len(values) > 0 {
+		v = values[0]
+	}
+	return v
+}
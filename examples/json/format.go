@@ -0,0 +1,55 @@
+package json
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/dezlitz/dsl"
+)
+
+// NewFormatter returns a dsl.Formatter that writes JSON with each object
+// and array on a single line when it fits the line width, and otherwise
+// with one member or value per line, indented.
+func NewFormatter(opts ...dsl.FormatOption) *dsl.Formatter {
+	f := dsl.NewFormatter(opts...)
+	f.Register(NODE_OBJECT, func(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+		return formatList(f, n, "{", "}")
+	})
+	f.Register(NODE_ARRAY, func(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+		return formatList(f, n, "[", "]")
+	})
+	f.Register(NODE_MEMBER, formatMember)
+	f.Register(NODE_VALUE, formatValue)
+	return f
+}
+
+// Format parses JSON from r and writes it to w. Input that does not parse
+// is not written and the first error is returned.
+func Format(w io.Writer, r *bufio.Reader, opts ...dsl.FormatOption) error {
+	ast, errs := dsl.Parse(Parse, Scan, r)
+	if len(errs) > 0 {
+		return &errs[0]
+	}
+	return NewFormatter(opts...).Format(w, ast)
+}
+
+func formatList(f *dsl.Formatter, n *dsl.Node, open, close string) dsl.Doc {
+	if len(n.Children) == 0 {
+		return dsl.Text(open + close)
+	}
+	items := dsl.Join(dsl.Concat(dsl.Text(","), dsl.Line()), f.Children(n)...)
+	return dsl.Group(dsl.Text(open), dsl.Indent(dsl.SoftLine(), items), dsl.SoftLine(), dsl.Text(close))
+}
+
+// "key": value
+func formatMember(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+	return dsl.Concat(dsl.Text(`"`+n.Tokens[0].Literal+`": `), dsl.Concat(f.Children(n)...))
+}
+
+func formatValue(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+	tok := n.Tokens[0]
+	if tok.ID == TOKEN_STRING {
+		return dsl.Text(`"` + tok.Literal + `"`)
+	}
+	return dsl.Text(tok.Literal)
+}
@@ -3,14 +3,20 @@ package json_test
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/dezlitz/dsl"
+	"github.com/dezlitz/dsl/dsltest"
 
 	. "github.com/dezlitz/dsl/examples/json"
 )
@@ -261,3 +267,489 @@ func expectJSON(t *testing.T, expected []byte, actual []byte) {
 	}
 
 }
+
+func TestGolden(t *testing.T) {
+	dsltest.Run(t, "testdata", Parse, Scan, dsltest.WithTokens())
+}
+
+func FuzzJSON(f *testing.F) {
+	dsltest.FuzzGrammar(f, Parse, Scan, dsltest.ReadInputs(f, "testdata")...)
+}
+
+// TestTrivia checks every golden input can be rebuilt from its tokens and
+// from its AST when trivia is kept.
+func TestTrivia(t *testing.T) {
+	for _, input := range dsltest.ReadInputs(t, "testdata") {
+		tokens, _ := dsl.Tokenize(Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithTrivia())
+		if source := dsl.Source(tokens); source != input {
+			t.Errorf("tokens do not reproduce the input:\nexpected %q\nfound    %q", input, source)
+		}
+		ast, errs := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithTrivia())
+		if source := ast.Source(); len(errs) == 0 && source != input {
+			t.Errorf("AST does not reproduce the input:\nexpected %q\nfound    %q", input, source)
+		}
+	}
+}
+
+// TestParseString checks parsing in place returns the same trees and errors
+// as parsing from a reader.
+func TestParseString(t *testing.T) {
+	for _, input := range append(dsltest.ReadInputs(t, "testdata"), `{"a": [1, "b`) {
+		var cst, found dsl.AST
+		ast, errs := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithTrivia(), dsl.WithCST(&cst))
+		fromString, stringErrs := dsl.ParseString(Parse, Scan, input, dsl.WithTrivia(), dsl.WithCST(&found))
+		if !reflect.DeepEqual(fromString, ast) || !reflect.DeepEqual(stringErrs, errs) {
+			t.Errorf("%q: ParseString differs from Parse", input)
+		}
+		if !reflect.DeepEqual(found, cst) {
+			t.Errorf("%q: ParseString CST differs from Parse", input)
+		}
+
+		ast, _ = dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)))
+		if fromBytes, _ := dsl.ParseBytes(Parse, Scan, []byte(input)); !reflect.DeepEqual(fromBytes, ast) {
+			t.Errorf("%q: ParseBytes differs from Parse", input)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	input, err := os.ReadFile("testdata/object.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		width int
+		want  string
+	}{
+		{80, `{
+  "key1": "value1",
+  "key2": 42,
+  "key3": true,
+  "key4": null,
+  "key5": {"nestedKey": "nestedValue"},
+  "key6": [1, 2, 3, "four"]
+}
+`},
+		{30, `{
+  "key1": "value1",
+  "key2": 42,
+  "key3": true,
+  "key4": null,
+  "key5": {
+    "nestedKey": "nestedValue"
+  },
+  "key6": [1, 2, 3, "four"]
+}
+`},
+		{200, `{"key1": "value1", "key2": 42, "key3": true, "key4": null, "key5": {"nestedKey": "nestedValue"}, "key6": [1, 2, 3, "four"]}
+`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Format(&buf, bufio.NewReader(bytes.NewReader(input)), dsl.WithWidth(test.width)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("width %d:\nexpected %s\nfound    %s", test.width, test.want, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, bufio.NewReader(strings.NewReader(`{"a": [], "b": {}}`))); err != nil || buf.String() != "{\"a\": [], \"b\": {}}\n" {
+		t.Errorf("unexpected format of empty lists: %q, %v", buf.String(), err)
+	}
+}
+
+func TestCST(t *testing.T) {
+	input := `{"a": [1, 2], "b": {}}`
+	var cst dsl.AST
+	if _, errs := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithCST(&cst), dsl.WithTrivia()); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// The skipped braces, brackets, colons and commas are all in the CST
+	want := `(ROOT
+  (Parse
+    (parseObject (LBRACE "{" 1:1)
+      (parseObject.func1 (STRING "a" 1:3 :leading "\"" :trailing "\"") (COLON ":" 1:5)
+        (parseValue
+          (parseArray (LBRACKET "[" 1:7 :leading " ")
+            (addValue (NUMBER "1" 1:8))
+            (parseValue (COMMA "," 1:9)
+              (addValue (NUMBER "2" 1:11 :leading " ")))
+            (closeNode (RBRACKET "]" 1:12)))))
+      (parseKeyAndValue (COMMA "," 1:13) (STRING "b" 1:16 :leading " \"" :trailing "\"") (COLON ":" 1:18)
+        (parseValue
+          (parseObject (LBRACE "{" 1:20 :leading " ")
+            (closeNode (RBRACE "}" 1:21)))))
+      (closeNode (RBRACE "}" 1:22)))))
+`
+	if got, _ := cst.MarshalSExpr(); string(got) != want {
+		t.Errorf("unexpected CST:\nexpected %s\nfound    %s", want, got)
+	}
+
+	for _, input := range dsltest.ReadInputs(t, "testdata") {
+		var cst dsl.AST
+		dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithCST(&cst), dsl.WithTrivia())
+		if source := cst.Source(); source != input {
+			t.Errorf("CST does not reproduce the input:\nexpected %q\nfound    %q", input, source)
+		}
+	}
+}
+
+// eventNode is a node as seen through events or the tree, in preorder.
+type eventNode struct {
+	Type   dsl.NodeType
+	Depth  int
+	Tokens []string
+}
+
+func TestEvents(t *testing.T) {
+	input, err := os.ReadFile("testdata/nested.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []eventNode
+	var open []int
+	maxDepth := 0
+	handler := func(e dsl.Event) {
+		switch e.Kind {
+		case dsl.EventStartNode:
+			got = append(got, eventNode{Type: e.Type, Depth: e.Depth})
+			open = append(open, len(got)-1)
+			maxDepth = max(maxDepth, e.Depth)
+		case dsl.EventToken:
+			n := &got[open[len(open)-1]]
+			n.Tokens = append(n.Tokens, e.Token.Literal)
+		case dsl.EventEndNode:
+			if n := got[open[len(open)-1]]; n.Type != e.Type || n.Depth != e.Depth {
+				t.Errorf("end of %v at depth %d does not match the start of %v at depth %d", e.Type, e.Depth, n.Type, n.Depth)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	ast, errs := dsl.Parse(Parse, Scan, bufio.NewReader(bytes.NewReader(input)), dsl.WithEvents(handler))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(ast.RootNode.Children) != 0 {
+		t.Errorf("expected no tree to be built, found %d nodes", len(ast.RootNode.Children))
+	}
+	if len(open) != 0 {
+		t.Errorf("expected every node to be ended, %d still open", len(open))
+	}
+
+	tree, _ := dsl.Parse(Parse, Scan, bufio.NewReader(bytes.NewReader(input)))
+	var want []eventNode
+	var walk func(n *dsl.Node, depth int)
+	walk = func(n *dsl.Node, depth int) {
+		en := eventNode{Type: n.Type, Depth: depth}
+		for _, tok := range n.Tokens {
+			en.Tokens = append(en.Tokens, tok.Literal)
+		}
+		want = append(want, en)
+		for i := range n.Children {
+			walk(&n.Children[i], depth+1)
+		}
+	}
+	for i := range tree.RootNode.Children {
+		walk(&tree.RootNode.Children[i], 1)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("events differ from the tree (-want +got):\n%s", diff)
+	}
+	if maxDepth < 4 {
+		t.Errorf("expected the nested array to reach depth 4, found %d", maxDepth)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	input := "{\"a\": 1}\n[2, 3]\n\n{\"b\" 4}\n"
+	docs := dsl.ParseAll(Parse, Scan, bufio.NewReader(strings.NewReader(input)))
+	var asts []dsl.AST
+	var errs [][]dsl.Error
+	for docs.Next() {
+		asts = append(asts, docs.AST())
+		errs = append(errs, docs.Errors())
+	}
+	// The third document stops at the number, which then starts a fourth
+	// that does not parse either.
+	if len(asts) != 4 {
+		t.Fatalf("expected 4 documents, found %d", len(asts))
+	}
+	for i, want := range []dsl.NodeType{NODE_OBJECT, NODE_ARRAY, NODE_OBJECT} {
+		if got := asts[i].RootNode.Children[0].Type; got != want {
+			t.Errorf("document %d: expected %v, found %v", i+1, want, got)
+		}
+	}
+	if len(errs[0]) != 0 || len(errs[1]) != 0 {
+		t.Errorf("unexpected errors: %v", errs[:2])
+	}
+	// Lines count from the start of the input
+	if tok := asts[1].RootNode.Children[0].Children[1].Tokens[0]; tok.Line != 2 || tok.Position != 5 {
+		t.Errorf("expected 3 at 2:5, found %d:%d", tok.Line, tok.Position)
+	}
+	if len(errs[2]) != 1 || errs[2][0].StartLine != 4 || errs[2][0].StartPosition != 6 {
+		t.Errorf("expected an error at 4:6, found %v", errs[2])
+	}
+
+	// With trivia the documents hold the whole input between them
+	input = " {\"a\": 1}\n[2, 3]\n\n{\"b\": 4}\n "
+	docs = dsl.ParseAll(Parse, Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithTrivia())
+	var source string
+	for docs.Next() {
+		ast := docs.AST()
+		source += ast.Source()
+	}
+	if source != input {
+		t.Errorf("expected the documents to reproduce %q, found %q", input, source)
+	}
+}
+
+// codeJSON returns the decompressed contents of code.json.gz, a single
+// 1.9MB line of JSON.
+func codeJSON(b testing.TB) []byte {
+	b.Helper()
+	f, err := os.Open("code.json.gz")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func benchmarkCodeJSON(b *testing.B, opts ...dsl.ParseOption) {
+	data := codeJSON(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, errs := dsl.Parse(Parse, Scan, bufio.NewReader(bytes.NewReader(data)), opts...)
+		if len(errs) != 0 {
+			b.Fatal(errs[0].Message)
+		}
+	}
+}
+
+func BenchmarkParseCodeJSON(b *testing.B) {
+	benchmarkCodeJSON(b)
+}
+
+// BenchmarkParseCodeJSONPipelined scans on a goroutine of its own, ahead of
+// the parser.
+func BenchmarkParseCodeJSONPipelined(b *testing.B) {
+	benchmarkCodeJSON(b, dsl.WithPipeline(context.Background(), 256))
+}
+
+// BenchmarkParseBytesCodeJSON parses the same input in place, without a
+// reader.
+func BenchmarkParseBytesCodeJSON(b *testing.B) {
+	data := codeJSON(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errs := dsl.ParseBytes(Parse, Scan, data); len(errs) != 0 {
+			b.Fatal(errs[0].Message)
+		}
+	}
+}
+
+// BenchmarkParseCodeJSONTraced measures the cost of tracing, with the log
+// written and discarded. It is slow, so it is skipped with -short.
+func BenchmarkParseCodeJSONTraced(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skipping the traced parse with -short")
+	}
+	benchmarkCodeJSON(b, dsl.WithLogger(io.Discard))
+}
+
+func BenchmarkTokenizeCodeJSON(b *testing.B) {
+	data := codeJSON(b)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errs := dsl.Tokenize(Scan, bufio.NewReader(bytes.NewReader(data))); len(errs) != 0 {
+			b.Fatal(errs[0].Message)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	for _, number := range []string{"0", "42", "-7", "3.25", "-0.5", "1e10", "2E-3", "6.02e+23"} {
+		tokens, errs := dsl.Tokenize(Scan, bufio.NewReader(strings.NewReader(number)))
+		if len(errs) != 0 || tokens[0].ID != TOKEN_NUMBER || tokens[0].Literal != number {
+			t.Errorf("%v: expected a single number, found %v %v", number, tokens, errs)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	tests := []struct {
+		input   string
+		literal string
+		value   interface{}
+	}{
+		{"42", "42", int64(42)},
+		{"-7", "-7", int64(-7)},
+		{"3.25", "3.25", 3.25},
+		{"6.02e+23", "6.02e+23", 6.02e+23},
+		{"18446744073709551616", "18446744073709551616", 18446744073709551616.0},
+		{`"plain"`, "plain", "plain"},
+		{`"tab\t \"quoted\" é"`, `tab\t \"quoted\" é`, "tab\t \"quoted\" é"},
+		{"true", "true", true},
+		{"false", "false", false},
+		{"null", "null", nil},
+	}
+	for _, test := range tests {
+		tokens, errs := dsl.Tokenize(Scan, bufio.NewReader(strings.NewReader(test.input)))
+		if len(errs) != 0 {
+			t.Errorf("%v: unexpected errors %v", test.input, errs)
+			continue
+		}
+		if tok := tokens[0]; tok.Literal != test.literal || tok.Value != test.value {
+			t.Errorf("%v: expected %q with value %#v, found %q with value %#v", test.input, test.literal, test.value, tok.Literal, tok.Value)
+		}
+	}
+
+	// The values are carried through to the AST
+	ast, _ := dsl.ParseString(Parse, Scan, `{"a": [1, 2.5, "x\ny"]}`)
+	var values []interface{}
+	var walk func(n *dsl.Node)
+	walk = func(n *dsl.Node) {
+		for _, tok := range n.Tokens {
+			if tok.ID == TOKEN_NUMBER || tok.ID == TOKEN_STRING {
+				values = append(values, tok.Value)
+			}
+		}
+		for i := range n.Children {
+			walk(&n.Children[i])
+		}
+	}
+	walk(ast.RootNode)
+	if expected := []interface{}{"a", int64(1), 2.5, "x\ny"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected values %#v in the AST, found %#v", expected, values)
+	}
+}
+
+// TestNestedArrays checks that closing an array walks up to its parent only,
+// so the values after a nested array are added to the right node.
+func TestNestedArrays(t *testing.T) {
+	ast, errs := dsl.ParseString(Parse, Scan, `{"a":[[1],[]],"b":2}`)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := `(ROOT
+  (OBJECT
+    (MEMBER (STRING "a" 1:3)
+      (ARRAY
+        (ARRAY
+          (VALUE (NUMBER "1" 1:8)))
+        (ARRAY)))
+    (MEMBER (STRING "b" 1:16)
+      (VALUE (NUMBER "2" 1:19)))))
+`
+	if found, _ := ast.MarshalSExpr(); string(found) != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, found)
+	}
+}
+
+func TestInvalidValues(t *testing.T) {
+	tests := []struct {
+		input      string
+		start, end int
+	}{
+		{`[1, 1e400]`, 5, 9},
+		{`[1, "bad \q"]`, 6, 11}, // The quotes are not part of the literal
+	}
+	for _, test := range tests {
+		_, errs := dsl.ParseString(Parse, Scan, test.input)
+		if len(errs) != 1 || errs[0].Code != dsl.ErrorInvalidValue {
+			t.Errorf("%v: expected an invalid value error, found %v", test.input, errs)
+			continue
+		}
+		if err := errs[0]; err.StartLine != 1 || err.StartPosition != test.start || err.EndPosition != test.end {
+			t.Errorf("%v: expected the error at 1:%v-%v, found %v:%v-%v", test.input, test.start, test.end, err.StartLine, err.StartPosition, err.EndPosition)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	inputs := []string{
+		`{}`,
+		`[]`,
+		`{"a": [1, 2.5, "x\ny", true, false, null], "b": {"c": {}}}`,
+		string(codeJSON(t)),
+	}
+	for _, input := range inputs {
+		v, errs := Decode(input)
+		if len(errs) != 0 {
+			t.Errorf("%.20q: unexpected errors %v", input, errs)
+			continue
+		}
+		var expected interface{}
+		if err := json.Unmarshal([]byte(input), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(floats(v), expected) {
+			t.Errorf("%.20q: decoded value differs from encoding/json", input)
+		}
+	}
+
+	invalid := []string{
+		`{"a": 1,}`, `{,"a": 1}`, `{,}`, `[1,]`, `[,1]`, `[,]`,
+		`[1 2]`, `{"a" 1}`, `[1, 1e400]`, `{"a": [1,], "b": 2}`,
+	}
+	for _, input := range invalid {
+		v, errs := Decode(input)
+		if len(errs) == 0 {
+			t.Errorf("%v: expected errors", input)
+		}
+		if v != nil {
+			t.Errorf("%v: expected no value along with the errors, found %v", input, v)
+		}
+	}
+}
+
+// floats converts the integers of a decoded value to float64, as
+// encoding/json decodes them.
+func floats(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = floats(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = floats(v[k])
+		}
+	}
+	return v
+}
+
+// BenchmarkDecodeCodeJSON decodes the input to Go values with typed rules,
+// building no AST.
+func BenchmarkDecodeCodeJSON(b *testing.B) {
+	data := string(codeJSON(b))
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errs := Decode(data); len(errs) != 0 {
+			b.Fatal(errs[0].Message)
+		}
+	}
+}
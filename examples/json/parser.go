@@ -131,9 +131,6 @@ func parseArray(p *dsl.Parser) {
 			{Id: TOKEN_RBRACKET, Fn: closeNode},
 		},
 	})
-
-	p.WalkUp()
-
 }
 
 func addValue(p *dsl.Parser) {
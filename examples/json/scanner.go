@@ -1,6 +1,11 @@
 package json
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode"
+
 	"github.com/dezlitz/dsl"
 )
 
@@ -20,9 +25,9 @@ const (
 	TOKEN_EOF      dsl.TokenType = "EOF"
 )
 
-func Scan(s *dsl.Scanner) dsl.Token {
-	// Skip all whitespace at the beginning of the input
-	s.Expect(dsl.ExpectRune{
+// The branch tables are compiled once and shared by every scan.
+var (
+	whitespace = dsl.ExpectRune{
 		Branches: []dsl.Branch{
 			{Rn: ' ', Fn: skipWhitespace},
 			{Rn: '\t', Fn: skipWhitespace},
@@ -30,9 +35,9 @@ func Scan(s *dsl.Scanner) dsl.Token {
 			{Rn: '\r', Fn: skipWhitespace},
 		},
 		Options: dsl.ExpectRuneOptions{Multiple: true, Optional: true},
-	})
+	}.MustCompile()
 
-	s.Expect(dsl.ExpectRune{
+	token = dsl.ExpectRune{
 		Branches: []dsl.Branch{
 			{Rn: '{', Fn: func(s *dsl.Scanner) { s.Match([]dsl.Match{{Literal: "{", ID: TOKEN_LBRACE}}) }},
 			{Rn: '}', Fn: func(s *dsl.Scanner) { s.Match([]dsl.Match{{Literal: "}", ID: TOKEN_RBRACE}}) }},
@@ -41,6 +46,8 @@ func Scan(s *dsl.Scanner) dsl.Token {
 			{Rn: ':', Fn: func(s *dsl.Scanner) { s.Match([]dsl.Match{{Literal: ":", ID: TOKEN_COLON}}) }},
 			{Rn: ',', Fn: func(s *dsl.Scanner) { s.Match([]dsl.Match{{Literal: ",", ID: TOKEN_COMMA}}) }},
 			{Rn: '"', Fn: stringLiteral},
+			{Rn: '-', Fn: negative},
+			{Rn: rune(0), Fn: eof},
 		},
 		BranchRanges: []dsl.BranchRange{
 			{StartRn: '0', EndRn: '9', Fn: number},
@@ -48,57 +55,175 @@ func Scan(s *dsl.Scanner) dsl.Token {
 			{StartRn: 'A', EndRn: 'Z', Fn: literal},
 		},
 		Options: dsl.ExpectRuneOptions{Optional: true},
-	})
+	}.MustCompile()
 
-	return s.Exit()
-}
+	// Every rune but the closing quote and the end of the input, with the
+	// rune after a backslash taken as part of the string
+	stringRunes = dsl.ExpectRune{
+		Branches: []dsl.Branch{
+			{Rn: '\\', Fn: escaped},
+		},
+		BranchRanges: []dsl.BranchRange{
+			{StartRn: rune(1), EndRn: '"' - 1, Fn: nil},
+			{StartRn: '"' + 1, EndRn: '\\' - 1, Fn: nil},
+			{StartRn: '\\' + 1, EndRn: unicode.MaxRune, Fn: nil},
+		},
+		Options: dsl.ExpectRuneOptions{Multiple: true, Optional: true},
+	}.MustCompile()
 
-func skipWhitespace(s *dsl.Scanner) {
-	s.SkipRune()
-}
+	escapedRune = dsl.ExpectNotRune{
+		Runes:   []rune{rune(0)},
+		Fn:      nil,
+		Options: dsl.ExpectRuneOptions{},
+	}.MustCompile()
 
-func stringLiteral(s *dsl.Scanner) {
-	s.SkipRune() // Skip the opening quote
-	s.ExpectNot(dsl.ExpectNotRune{
-		Runes: []rune{
-			'"',
+	closingQuote = dsl.ExpectRune{
+		Branches: []dsl.Branch{
+			{Rn: '"', Fn: func(s *dsl.Scanner) { s.SkipRune() }}, // Skip the closing quote
+		},
+	}.MustCompile()
+
+	moreDigits = dsl.ExpectRune{
+		BranchRanges: []dsl.BranchRange{
+			{StartRn: '0', EndRn: '9', Fn: nil},
 		},
-		Fn:      nil,
 		Options: dsl.ExpectRuneOptions{Multiple: true, Optional: true},
-	})
+	}.MustCompile()
 
-	s.Expect(dsl.ExpectRune{
+	fraction = dsl.ExpectRune{
 		Branches: []dsl.Branch{
-			{Rn: '"', Fn: nil}, // Closing quote
+			{Rn: '.', Fn: digits},
 		},
-	})
-	s.SkipRune() // Skip the closing quote
-	s.Match([]dsl.Match{{Literal: "", ID: TOKEN_STRING}})
+		Options: dsl.ExpectRuneOptions{Optional: true},
+	}.MustCompile()
 
-}
+	exponentMark = dsl.ExpectRune{
+		Branches: []dsl.Branch{
+			{Rn: 'e', Fn: exponent},
+			{Rn: 'E', Fn: exponent},
+		},
+		Options: dsl.ExpectRuneOptions{Optional: true},
+	}.MustCompile()
 
-func number(s *dsl.Scanner) {
-	s.Expect(dsl.ExpectRune{
+	exponentSign = dsl.ExpectRune{
+		Branches: []dsl.Branch{
+			{Rn: '+', Fn: nil},
+			{Rn: '-', Fn: nil},
+		},
+		Options: dsl.ExpectRuneOptions{Optional: true},
+	}.MustCompile()
+
+	oneOrMoreDigits = dsl.ExpectRune{
 		BranchRanges: []dsl.BranchRange{
 			{StartRn: '0', EndRn: '9', Fn: nil},
 		},
-		Options: dsl.ExpectRuneOptions{Multiple: true, Optional: true},
-	})
-	s.Match([]dsl.Match{{Literal: "", ID: TOKEN_NUMBER}})
-}
+		Options: dsl.ExpectRuneOptions{Multiple: true},
+	}.MustCompile()
 
-func literal(s *dsl.Scanner) {
-	s.Expect(dsl.ExpectRune{
+	letters = dsl.ExpectRune{
 		BranchRanges: []dsl.BranchRange{
 			{StartRn: 'a', EndRn: 'z', Fn: nil},
 			{StartRn: 'A', EndRn: 'Z', Fn: nil},
 		},
 		Options: dsl.ExpectRuneOptions{Multiple: true, Optional: true},
-	})
+	}.MustCompile()
+)
+
+func Scan(s *dsl.Scanner) dsl.Token {
+	// Skip all whitespace at the beginning of the input
+	s.Expect(whitespace)
+	s.Expect(token)
+	return s.Exit()
+}
+
+func eof(s *dsl.Scanner) {
+	s.Match([]dsl.Match{{Literal: "", ID: TOKEN_EOF}})
+}
+
+func skipWhitespace(s *dsl.Scanner) {
+	s.SkipRune()
+}
+
+func stringLiteral(s *dsl.Scanner) {
+	s.SkipRune() // Skip the opening quote
+	s.Expect(stringRunes)
+	s.Expect(closingQuote)
+	s.Match([]dsl.Match{{Literal: "", ID: TOKEN_STRING}})
+	s.Convert(unquote)
+}
+
+// escaped scans the rune after a backslash. The literal of a string keeps
+// its escapes as written, the value of the token is the string they stand
+// for.
+func escaped(s *dsl.Scanner) {
+	s.ExpectNot(escapedRune)
+}
+
+// unquote returns the string a string literal stands for.
+func unquote(literal string) (interface{}, error) {
+	if !strings.Contains(literal, "\\") {
+		return literal, nil
+	}
+	var v string
+	if err := json.Unmarshal([]byte(`"`+literal+`"`), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// number scans the rest of a number after its first digit, with an optional
+// fraction and exponent.
+func number(s *dsl.Scanner) {
+	s.Expect(moreDigits)
+	s.Expect(fraction)
+	s.Expect(exponentMark)
+	s.Match([]dsl.Match{{Literal: "", ID: TOKEN_NUMBER}})
+	s.Convert(numberValue)
+}
+
+// numberValue returns an int64 for a whole number that fits one, otherwise
+// a float64.
+func numberValue(literal string) (interface{}, error) {
+	if !strings.ContainsAny(literal, ".eE") {
+		if v, err := strconv.ParseInt(literal, 10, 64); err == nil {
+			return v, nil
+		}
+	}
+	v, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// negative scans a number after its minus sign.
+func negative(s *dsl.Scanner) {
+	digits(s)
+	number(s)
+}
+
+func exponent(s *dsl.Scanner) {
+	s.Expect(exponentSign)
+	digits(s)
+}
+
+// digits scans one or more digits.
+func digits(s *dsl.Scanner) {
+	s.Expect(oneOrMoreDigits)
+}
+
+func literal(s *dsl.Scanner) {
+	s.Expect(letters)
 
 	s.Match([]dsl.Match{
 		{Literal: "true", ID: TOKEN_TRUE},
 		{Literal: "false", ID: TOKEN_FALSE},
 		{Literal: "null", ID: TOKEN_NULL},
 	})
+	switch s.Exit().ID {
+	case TOKEN_TRUE:
+		s.SetValue(true)
+	case TOKEN_FALSE:
+		s.SetValue(false)
+	}
 }
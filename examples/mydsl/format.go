@@ -0,0 +1,85 @@
+package mydsl
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/dezlitz/dsl"
+)
+
+// NewFormatter returns a dsl.Formatter that writes mydsl in its canonical
+// layout: one statement per line with at most one blank line between them,
+// a single space around := and each operator, none inside parentheses, and
+// comments after a single quote.
+//
+// A statement ends at a line break, so statements are never broken to fit
+// the line width. Strings and numbers are both LITERAL tokens without their
+// quotes, so a literal that is not a number is written as a string.
+func NewFormatter(opts ...dsl.FormatOption) *dsl.Formatter {
+	opts = append([]dsl.FormatOption{dsl.WithCommentFormat(formatComment)}, opts...)
+	f := dsl.NewFormatter(opts...)
+	f.Register(NODE_ASSIGNMENT, formatAssignment)
+	f.Register(NODE_CALL, formatCall)
+	f.Register(NODE_EXPRESSION, formatExpression)
+	f.Register(NODE_TERMINAL, formatTerminal)
+	return f
+}
+
+// Format parses mydsl source from r and writes it to w in its canonical
+// layout. Source that does not parse is not written and the first error is
+// returned.
+func Format(w io.Writer, r *bufio.Reader, opts ...dsl.FormatOption) error {
+	ast, errs := dsl.Parse(Parse, Scan, r, dsl.WithComments(TOKEN_COMMENT))
+	if len(errs) > 0 {
+		return &errs[0]
+	}
+	return NewFormatter(opts...).Format(w, ast)
+}
+
+func formatComment(tok dsl.ASTToken) string {
+	return "'" + tok.Literal
+}
+
+// a := operand
+func formatAssignment(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+	return dsl.Concat(dsl.Text(n.Tokens[0].Literal+" := "), dsl.Concat(f.Children(n)...))
+}
+
+// name(operand)
+func formatCall(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+	return dsl.Concat(dsl.Text(n.Tokens[0].Literal+"("), dsl.Concat(f.Children(n)...), dsl.Text(")"))
+}
+
+// An expression is either an operator followed by its right operand or an
+// opening parenthesis followed by the expression inside it.
+func formatExpression(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+	if n.Tokens[0].ID == TOKEN_OPEN_PAREN {
+		return dsl.Concat(dsl.Text("("), dsl.Concat(f.Children(n)...))
+	}
+	return dsl.Concat(dsl.Text(" "+n.Tokens[0].Literal+" "), dsl.Concat(f.Children(n)...))
+}
+
+func formatTerminal(f *dsl.Formatter, n *dsl.Node) dsl.Doc {
+	tok := n.Tokens[0]
+	if tok.ID == TOKEN_LITERAL && !isNumber(tok.Literal) {
+		return dsl.Text(`"` + tok.Literal + `"`)
+	}
+	return dsl.Text(tok.Literal)
+}
+
+// isNumber reports whether the literal is scanned as a number, digits with
+// an optional fraction.
+func isNumber(literal string) bool {
+	digits, dot := 0, false
+	for _, r := range literal {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '.' && !dot && digits > 0:
+			dot, digits = true, 0
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
@@ -3,11 +3,15 @@ package mydsl_test
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/dezlitz/dsl"
+	"github.com/dezlitz/dsl/dsltest"
 	. "github.com/dezlitz/dsl/examples/mydsl"
 	"github.com/google/go-cmp/cmp"
 )
@@ -112,6 +116,9 @@ double(a + b)`)
 								"tokens": [
 									{"ID": "DIVIDE", "Literal": "/"}
 								],
+								"hidden": [
+									{"ID": "COMMENT", "Literal": "A Simple Expression"}
+								],
 								"children": [
 									{
 										"type": "EXPRESSION",
@@ -156,13 +163,6 @@ double(a + b)`)
 					}
 				]
 			},
-			{
-				"type": "COMMENT",
-				"tokens": [
-					{"ID": "COMMENT", "Literal": "A Simple Expression"}
-				],
-				"children": null
-			},
 			{
 				"type": "CALL",
 				"tokens": [
@@ -455,6 +455,12 @@ func TestPrintAST(t *testing.T) {
 											"Literal": "/"
 										}
 									],
+									"hidden": [
+										{
+											"ID": "COMMENT",
+											"Literal": "A Simple Expression"
+										}
+									],
 									"children": [
 										{
 											"type": "EXPRESSION",
@@ -514,16 +520,6 @@ func TestPrintAST(t *testing.T) {
 						}
 					]
 				},
-				{
-					"type": "COMMENT",
-					"tokens": [
-						{
-							"ID": "COMMENT",
-							"Literal": "A Simple Expression"
-						}
-					],
-					"children": null
-				},
 				{
 					"type": "CALL",
 					"tokens": [
@@ -594,3 +590,261 @@ func expectJSON(t *testing.T, expected []byte, actual []byte) {
 	}
 
 }
+
+func TestGolden(t *testing.T) {
+	dsltest.Run(t, "testdata", Parse, Scan, dsltest.WithTokens())
+}
+
+func FuzzMyDSL(f *testing.F) {
+	dsltest.FuzzGrammar(f, Parse, Scan, dsltest.ReadInputs(f, "testdata")...)
+}
+
+// TestTrivia checks every golden input can be rebuilt from its tokens and
+// from its AST when trivia is kept.
+func TestTrivia(t *testing.T) {
+	for _, input := range dsltest.ReadInputs(t, "testdata") {
+		tokens, _ := dsl.Tokenize(Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithTrivia())
+		if source := dsl.Source(tokens); source != input {
+			t.Errorf("tokens do not reproduce the input:\nexpected %q\nfound    %q", input, source)
+		}
+		ast, errs := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithTrivia())
+		if source := ast.Source(); len(errs) == 0 && source != input {
+			t.Errorf("AST does not reproduce the input:\nexpected %q\nfound    %q", input, source)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input, err := os.ReadFile("testdata/comments.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, errs := dsl.Parse(Parse, Scan, bufio.NewReader(bytes.NewReader(input)), dsl.WithComments(TOKEN_COMMENT))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	a, b := &ast.RootNode.Children[0], &ast.RootNode.Children[1]
+	tests := []struct {
+		name string
+		got  []dsl.ASTToken
+		want string
+	}{
+		{"leading a", a.LeadingComments(), "Comments can go on their own line"},
+		{"trailing a", a.TrailingComments(), "or after a statement"},
+		{"leading b", b.LeadingComments(), "and before the end"},
+	}
+	for _, test := range tests {
+		if len(test.got) != 1 || test.got[0].Literal != test.want {
+			t.Errorf("%v: expected %q, found %+v", test.name, test.want, test.got)
+		}
+	}
+	if len(ast.RootNode.Comments) != 0 || len(ast.RootNode.Hidden) != 0 {
+		t.Errorf("expected every comment to be attached to a statement, root has %+v", ast.RootNode)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	input := "'header\n\na:=1*5+7\n\n\n\tb := 3.45*44.21/ ( 4+a ) 'A Simple Expression\n'about double\ndouble( (a+b) )\ns := \"hello world\""
+	want := "'header\n\na := 1 * 5 + 7\n\nb := 3.45 * 44.21 / (4 + a) 'A Simple Expression\n'about double\ndouble((a + b))\ns := \"hello world\"\n"
+
+	var buf bytes.Buffer
+	if err := Format(&buf, bufio.NewReader(strings.NewReader(input))); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("unexpected format:\nexpected %q\nfound    %q", want, buf.String())
+	}
+
+	// Formatting is idempotent for every input that parses
+	for i, input := range dsltest.ReadInputs(t, "testdata") {
+		var first, second bytes.Buffer
+		if err := Format(&first, bufio.NewReader(strings.NewReader(input))); err != nil {
+			continue
+		}
+		if err := Format(&second, bufio.NewReader(bytes.NewReader(first.Bytes()))); err != nil {
+			t.Errorf("input %d: formatted source does not parse: %v\n%s", i, err, first.String())
+			continue
+		}
+		if first.String() != second.String() {
+			t.Errorf("input %d: formatting is not idempotent:\nfirst  %q\nsecond %q", i, first.String(), second.String())
+		}
+	}
+
+	if err := Format(&buf, bufio.NewReader(strings.NewReader("a := (1"))); err == nil {
+		t.Errorf("expected an error formatting source that does not parse")
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	source := "'header\na := 1 * 5 + 7\nb := 3.45 * 44.21 / (4 + a) 'after b\n'about double\ndouble(a + b)\nc := \"é\""
+	tests := []struct {
+		name    string
+		edit    dsl.TextEdit
+		changed int
+	}{
+		{"within a statement", dsl.TextEdit{StartLine: 3, StartPosition: 6, EndLine: 3, EndPosition: 10, Text: "12.5"}, 1},
+		{"longer line", dsl.TextEdit{StartLine: 2, StartPosition: 6, EndLine: 2, EndPosition: 7, Text: "(1 + 2)"}, 1},
+		{"new statement", dsl.TextEdit{StartLine: 3, StartPosition: 1, EndLine: 3, EndPosition: 1, Text: "x := 0\ny := 1\n"}, 4},
+		{"join lines", dsl.TextEdit{StartLine: 2, StartPosition: 15, EndLine: 3, EndPosition: 1, Text: ""}, 3},
+		{"comment", dsl.TextEdit{StartLine: 4, StartPosition: 2, EndLine: 4, EndPosition: 7, Text: "calling"}, 1},
+		{"last statement", dsl.TextEdit{StartLine: 6, StartPosition: 7, EndLine: 6, EndPosition: 8, Text: "ü"}, 1},
+		{"error", dsl.TextEdit{StartLine: 2, StartPosition: 3, EndLine: 2, EndPosition: 5, Text: "= "}, 0},
+	}
+	for _, test := range tests {
+		d := dsl.ParseDocument(Parse, Scan, source, dsl.WithComments(TOKEN_COMMENT))
+		before, _ := d.AST.MarshalSExpr()
+		nd, changed, err := d.Edit(test.edit)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		full, errs := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(nd.Source)), dsl.WithComments(TOKEN_COMMENT))
+		want, _ := full.MarshalSExpr()
+		got, _ := nd.AST.MarshalSExpr()
+		if string(got) != string(want) {
+			t.Errorf("%v: incremental AST differs from a full parse of %q:\nexpected %s\nfound    %s", test.name, nd.Source, want, got)
+		}
+		if len(nd.Errors) != len(errs) {
+			t.Errorf("%v: expected %d errors, found %v", test.name, len(errs), nd.Errors)
+		}
+		if test.changed > 0 && len(changed) != test.changed {
+			t.Errorf("%v: expected %d changed statements, found %d", test.name, test.changed, len(changed))
+		}
+		if after, _ := d.AST.MarshalSExpr(); string(after) != string(before) {
+			t.Errorf("%v: the edit changed the previous document", test.name)
+		}
+	}
+}
+
+func TestIncrementalIDs(t *testing.T) {
+	source := "a := 1\nb := 2\nc := 3"
+	typeOf := dsl.NewAttr[string]("type")
+	d := dsl.ParseDocument(Parse, Scan, source)
+	for i := range d.AST.RootNode.Children {
+		typeOf.Set(&d.AST.RootNode.Children[i], "int")
+	}
+	nd, _, err := d.Edit(dsl.TextEdit{StartLine: 2, StartPosition: 6, EndLine: 2, EndPosition: 7, Text: "2.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[int]bool{}
+	nd.AST.Inspect(func(n *dsl.Node) {
+		if n.ID == 0 || seen[n.ID] {
+			t.Errorf("node %v: expected a unique ID, found %v", n.Type, n.ID)
+		}
+		seen[n.ID] = true
+	})
+	before, after := d.AST.RootNode.Children, nd.AST.RootNode.Children
+	for _, i := range []int{0, 2} {
+		if after[i].ID != before[i].ID {
+			t.Errorf("statement %d: expected ID %v to be kept, found %v", i, before[i].ID, after[i].ID)
+		}
+		if _, ok := typeOf.Get(&after[i]); !ok {
+			t.Errorf("statement %d: expected its attributes to be kept", i)
+		}
+	}
+	if seen[before[1].ID] {
+		t.Errorf("expected the reparsed statement to be given a new ID")
+	}
+	if _, ok := typeOf.Get(&after[1]); ok {
+		t.Errorf("expected the reparsed statement to have no attributes")
+	}
+}
+
+func TestStreamParser(t *testing.T) {
+	source := "a := 1 * 5 + 7\nb := 3.45 * 44.21 / (4 + a) 'A Simple Expression\ndouble(a + b)"
+	sp := dsl.NewStreamParser(Parse, Scan)
+	var flushed []dsl.Node
+	var firstAt string
+	for i := 0; i < len(source); i += 4 {
+		chunk := source[i:min(i+4, len(source))]
+		nodes, err := sp.Feed([]byte(chunk))
+		if err != dsl.ErrNeedMoreInput {
+			t.Fatalf("%q: expected ErrNeedMoreInput, found %v", chunk, err)
+		}
+		flushed = append(flushed, nodes...)
+		// A statement is flushed once the parser has walked back up to the
+		// root, before the rest of the input arrives.
+		if len(flushed) == 1 && firstAt == "" {
+			firstAt = source[:i+len(chunk)]
+		}
+	}
+	if want := "a := 1 * 5 + 7\nb"; !strings.HasPrefix(firstAt, "a := 1 * 5 + 7") || len(firstAt) > len(want) {
+		t.Errorf("expected the first statement to be flushed once its line ends, found after %q", firstAt)
+	}
+	ast, errs := sp.Close()
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	full, _ := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(source)))
+	want, _ := full.MarshalSExpr()
+	if got, _ := ast.MarshalSExpr(); string(got) != string(want) {
+		t.Errorf("streamed AST differs from Parse:\nexpected %s\nfound    %s", want, got)
+	}
+	if len(flushed) == 0 || flushed[0].Type != NODE_ASSIGNMENT || flushed[0].Tokens[0].Literal != "a" {
+		t.Errorf("expected the assignment to a to be flushed first, found %+v", flushed)
+	}
+	if len(flushed) != 3 {
+		t.Errorf("expected 3 statements flushed before Close, found %d", len(flushed))
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	// Parse every input many times over so that files needing recovery run
+	// alongside files that do not, run with -race to check for shared state.
+	inputs, err := filepath.Glob("testdata/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for i := 0; i < 20; i++ {
+		paths = append(paths, inputs...)
+	}
+	results, err := dsl.ParseFiles(context.Background(), paths, Parse, Scan, dsl.WithWorkers(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Path != paths[i] || result.Err != nil {
+			t.Fatalf("result %d: expected %v, found %v (%v)", i, paths[i], result.Path, result.Err)
+		}
+		f, err := os.Open(paths[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast, errs := dsl.Parse(Parse, Scan, bufio.NewReader(f))
+		f.Close()
+		want, _ := ast.MarshalSExpr()
+		if got, _ := result.AST.MarshalSExpr(); string(got) != string(want) {
+			t.Errorf("%v: parallel AST differs:\nexpected %s\nfound    %s", paths[i], want, got)
+		}
+		if len(result.Errors) != len(errs) {
+			t.Errorf("%v: expected %d errors, found %d", paths[i], len(errs), len(result.Errors))
+		}
+		for _, e := range result.Errors {
+			if e.File != paths[i] {
+				t.Errorf("%v: error tagged with %q", paths[i], e.File)
+			}
+		}
+	}
+}
+
+// TestPipeline checks a pipelined parse matches a lockstep one, including
+// inputs that switch the scanner to recovery mode part way through.
+func TestPipeline(t *testing.T) {
+	inputs := append(dsltest.ReadInputs(t, "testdata"),
+		"a := 1 * 5 + 7\nb := 3.45 * 44.21 / (4; + a) 'A Simple Expression\ndouble((a + b)")
+	for _, input := range inputs {
+		ast, errs := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)), dsl.WithTrivia())
+		found, foundErrs := dsl.Parse(Parse, Scan, bufio.NewReader(strings.NewReader(input)),
+			dsl.WithTrivia(), dsl.WithPipeline(context.Background(), 4))
+		want, _ := ast.MarshalSExpr()
+		if got, _ := found.MarshalSExpr(); string(got) != string(want) {
+			t.Errorf("%.30q: pipelined AST differs:\nexpected %s\nfound    %s", input, want, got)
+		}
+		if diff := cmp.Diff(errs, foundErrs); diff != "" {
+			t.Errorf("%.30q: pipelined errors differ (-expected +found):\n%s", input, diff)
+		}
+	}
+}
@@ -10,15 +10,26 @@ const (
 	NODE_CALL       dsl.NodeType = "CALL"
 	NODE_EXPRESSION dsl.NodeType = "EXPRESSION"
 	NODE_TERMINAL   dsl.NodeType = "TERMINAL"
-	NODE_COMMENT    dsl.NodeType = "COMMENT"
 )
 
-var recover bool
+// mode is the state the parser shares with the scanner. In modeRecover the
+// scanner reads the rest of the line as a single unknown token, see
+// skipUntilLineBreak.
+type mode int
 
+const (
+	modeNormal mode = iota
+	modeRecover
+)
+
+// Parse is the entry point of the grammar. Comments are hidden so they can
+// appear at the end of any line, and are attached to the nearest node.
 func Parse(p *dsl.Parser) (dsl.AST, []dsl.Error) {
+	p.HideTokens(TOKEN_COMMENT)
 	p.Expect(dsl.ExpectToken{
 		Branches: []dsl.BranchToken{
 			{Id: TOKEN_VARIABLE, Fn: assignmentOrCall},
+			{Id: TOKEN_NL, Fn: skipBlankLine},
 			{Id: TOKEN_EOF, Fn: nil},
 		},
 		Options: dsl.ParseOptions{Multiple: true},
@@ -35,12 +46,6 @@ func assignmentOrCall(p *dsl.Parser) {
 			{Id: TOKEN_OPEN_PAREN, Fn: call},
 		},
 	})
-	p.Expect(dsl.ExpectToken{
-		Branches: []dsl.BranchToken{
-			{Id: TOKEN_COMMENT, Fn: addcomment},
-		},
-		Options: dsl.ParseOptions{Optional: true},
-	})
 	p.Expect(dsl.ExpectToken{
 		Branches: []dsl.BranchToken{
 			{Id: TOKEN_NL, Fn: nil},
@@ -138,15 +143,13 @@ func parenExpression(p *dsl.Parser) {
 
 }
 
-// parse -> assignmentOrCall -> [expression] -> addcomment
-func addcomment(p *dsl.Parser) {
-	p.AddNode(NODE_COMMENT)
-	p.AddTokens()
-	p.WalkUp()
+// parse -> skipBlankLine
+func skipBlankLine(p *dsl.Parser) {
+	p.SkipToken()
 }
 
 func skipUntilLineBreak(p *dsl.Parser) {
-	recover = true
+	p.SetState(modeRecover)
 	p.ExpectNot(dsl.ExpectNotToken{
 		Tokens:  []dsl.TokenType{dsl.TOKEN_UNKNOWN},
 		Fn:      nil,
@@ -157,5 +160,5 @@ func skipUntilLineBreak(p *dsl.Parser) {
 			{Id: dsl.TOKEN_UNKNOWN, Fn: nil},
 		},
 	})
-	recover = false
+	p.SetState(modeNormal)
 }
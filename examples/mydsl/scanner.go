@@ -20,7 +20,7 @@ const (
 )
 
 func Scan(s *dsl.Scanner) dsl.Token {
-	if recover {
+	if s.State() == modeRecover {
 		s.ExpectNot(dsl.ExpectNotRune{
 			Runes: []rune{
 				rune(0), '\n',
@@ -119,16 +119,21 @@ func literal(s *dsl.Scanner) {
 
 // ScanFn -> literal
 func stringliteral(s *dsl.Scanner) {
-	s.SkipRune()
+	s.SkipRune() // Skip the opening quote
 	s.ExpectNot(dsl.ExpectNotRune{
 		Runes: []rune{
 			'"',
+			rune(0),
 		},
 		Fn:      nil,
 		Options: dsl.ExpectRuneOptions{Multiple: true, Optional: true},
 	})
 
-	s.SkipRune()
+	s.Expect(dsl.ExpectRune{
+		Branches: []dsl.Branch{
+			{Rn: '"', Fn: func(s *dsl.Scanner) { s.SkipRune() }}, // Skip the closing quote
+		},
+	})
 	s.Match([]dsl.Match{{Literal: "", ID: TOKEN_LITERAL}})
 }
 
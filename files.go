@@ -0,0 +1,132 @@
+// files.go implements parsing many files at once. ParseFiles hands the
+// files to a bounded pool of goroutines, each running an ordinary parse with
+// its own Parser, Scanner and AST, and returns the results in the order of
+// the paths given.
+//
+// As parses run at the same time, the user parse and scan functions must not
+// share state through package variables, see Parser.SetState. Options that
+// are shared between parses, a log writer, a Coverage or the handler of
+// WithEvents, must be safe for concurrent use. Log lines of different files
+// are interleaved.
+
+package dsl
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// FileResult is the result of parsing a single file with ParseFiles. Err
+// is set if the file could not be read or was not parsed before the context
+// was cancelled, otherwise Errors holds the parse errors, each with its File
+// set to Path. CST holds the concrete syntax tree when parsed with WithCST.
+type FileResult struct {
+	Path   string
+	AST    AST
+	Errors []Error
+	CST    AST
+	Err    error
+}
+
+// WithWorkers returns a ParseOption that sets the number of files ParseFiles
+// parses at once. The default is runtime.GOMAXPROCS(0).
+func WithWorkers(n int) ParseOption {
+	return func(c *ParseConfig) {
+		c.Workers = n
+	}
+}
+
+// ParseFiles parses the files concurrently and returns one result per path,
+// in the same order. It takes the same options as Parse, except that with
+// WithCST each file's CST is returned in its FileResult.
+//
+// Once the context is cancelled no more files are started, and the files
+// not parsed have their Err set to the error of the context, which is also
+// returned. A file already being parsed runs to the end, so if every file
+// was parsed no error is returned.
+func ParseFiles(ctx context.Context, paths []string, pf ParseFunc, sf ScanFunc, opts ...ParseOption) ([]FileResult, error) {
+	config := newParseConfig(opts)
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(paths))
+
+	results := make([]FileResult, len(paths))
+	parsed := make([]bool, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = parseFile(paths[i], pf, sf, config)
+				parsed[i] = true
+			}
+		}()
+	}
+
+send:
+	for i := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var err error
+	for i := range results {
+		if !parsed[i] {
+			err = ctx.Err()
+			results[i] = FileResult{Path: paths[i], Err: err}
+		}
+	}
+	return results, err
+}
+
+// parseFile parses a single file with a configuration of its own.
+func parseFile(path string, pf ParseFunc, sf ScanFunc, config *ParseConfig) FileResult {
+	result := FileResult{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer f.Close()
+
+	c := *config
+	if c.CST != nil {
+		c.CST = &result.CST
+	}
+	result.AST, result.Errors = execute(pipelined(setup(pf, sf, bufio.NewReader(f), &c), &c))
+	for i := range result.Errors {
+		result.Errors[i].File = path
+	}
+	if result.AST.RootNode != nil {
+		tagErrors(result.AST.RootNode, path)
+	}
+	if result.CST.RootNode != nil {
+		tagErrors(result.CST.RootNode, path)
+	}
+	return result
+}
+
+// tagErrors sets the file of every error kept in the tree.
+func tagErrors(n *Node, path string) {
+	for i := range n.Errors {
+		n.Errors[i].File = path
+	}
+	for i := range n.Children {
+		tagErrors(&n.Children[i], path)
+	}
+}
@@ -0,0 +1,90 @@
+package dsl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFiles(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, input := range []string{"one;", "two \"x", "three;"} {
+		path := filepath.Join(dir, strings.Fields(input)[0][:3]+".txt")
+		if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	missing := filepath.Join(dir, "missing.txt")
+	paths = append(paths, missing)
+
+	var cst AST
+	results, err := ParseFiles(context.Background(), paths, cstParse, triviaScan, WithWorkers(2), WithCST(&cst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Path != paths[i] {
+			t.Errorf("result %d: expected %v, found %v", i, paths[i], result.Path)
+		}
+	}
+	if cst.RootNode != nil {
+		t.Errorf("expected the CST of each file in its result, found %+v", cst.RootNode)
+	}
+	if len(results[0].Errors) != 0 || results[0].CST.RootNode == nil {
+		t.Errorf("unexpected result %+v", results[0])
+	}
+	errs := results[1].Errors
+	if len(errs) != 1 || errs[0].File != paths[1] || !strings.Contains(errs[0].Error(), "File:"+paths[1]) {
+		t.Errorf("expected an error tagged with %v, found %+v", paths[1], errs)
+	}
+	if results[3].Err == nil {
+		t.Errorf("expected an error reading %v", missing)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = ParseFiles(ctx, paths, cstParse, triviaScan)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, found %v", err)
+	}
+	var skipped int
+	for i, result := range results {
+		switch {
+		case result.Path != paths[i]:
+			t.Errorf("result %d: expected %v, found %v", i, paths[i], result.Path)
+		case result.Err == context.Canceled:
+			skipped++
+		case result.AST.RootNode == nil && result.Err == nil:
+			t.Errorf("expected %v to be parsed or skipped, found %+v", paths[i], result)
+		}
+	}
+	if skipped == 0 {
+		t.Errorf("expected the files to be skipped once the context was cancelled")
+	}
+}
+
+func TestParseFilesCancelledLate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "one.txt")
+	if err := os.WriteFile(path, []byte("one;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The context is cancelled while the only file is being parsed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelParse := func(p *Parser) (AST, []Error) {
+		cancel()
+		return cstParse(p)
+	}
+	results, err := ParseFiles(ctx, []string{path}, cancelParse, triviaScan)
+	if err != nil {
+		t.Errorf("expected no error as every file was parsed, found %v", err)
+	}
+	if results[0].Err != nil || results[0].AST.RootNode == nil {
+		t.Errorf("expected the file to be parsed, found %+v", results[0])
+	}
+}
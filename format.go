@@ -0,0 +1,507 @@
+// format.go implements a pretty printer that regenerates source text from an
+// AST, based on the document algebra of Wadler's "A prettier printer". A
+// grammar registers a FormatRule for each NodeType that builds a Doc from
+// the node: Text that is always written as it is, Line breaks that become a
+// space when their Group fits in the remaining width and a new line when it
+// does not, and Nest or Indent to set the indentation after a new line.
+//
+// For example the rule
+//
+//	Group(Text("["), Indent(SoftLine(), Join(Concat(Text(","), Line()), items...)), SoftLine(), Text("]"))
+//
+// writes [1, 2, 3] when it fits on the line, and otherwise
+//
+//	[
+//	  1,
+//	  2,
+//	  3
+//	]
+//
+// Comments attached to the nodes with WithComments or AST.AttachComments are
+// written around the output of the rules: leading comments on the lines
+// before the node, trailing comments at the end of its last line and
+// dangling comments after it, unless the rule places them itself.
+
+package dsl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ----------------------------------------------------------------------------
+// Documents
+
+// Doc is a document to be laid out by the printer. The zero Doc is empty.
+type Doc struct {
+	kind docKind
+	text string
+	nest int
+	docs []Doc
+	hard bool // Holds a hard line break, so every enclosing group breaks
+}
+
+type docKind int
+
+const (
+	docConcat docKind = iota
+	docText
+	docLine
+	docSoftLine
+	docHardLine
+	docBreakParent
+	docGroup
+	docNest
+	docIndent
+	docLineSuffix
+)
+
+// Text returns a Doc that writes s. A new line in s is written as it is,
+// without indentation, and breaks every enclosing group.
+func Text(s string) Doc {
+	return Doc{kind: docText, text: s, hard: strings.Contains(s, "\n")}
+}
+
+// Line returns a Doc that is a space, or a new line when its group breaks.
+func Line() Doc {
+	return Doc{kind: docLine}
+}
+
+// SoftLine returns a Doc that is empty, or a new line when its group breaks.
+func SoftLine() Doc {
+	return Doc{kind: docSoftLine}
+}
+
+// HardLine returns a Doc that is always a new line, so every enclosing group
+// breaks.
+func HardLine() Doc {
+	return Doc{kind: docHardLine, hard: true}
+}
+
+// Concat returns a Doc that writes the documents one after another.
+func Concat(docs ...Doc) Doc {
+	return Doc{kind: docConcat, docs: docs, hard: anyHard(docs)}
+}
+
+// Group returns a Doc that writes the documents with every Line and
+// SoftLine directly inside the group flat if they fit on the rest of the
+// line, and breaks all of them otherwise. Nested groups are laid out on
+// their own once the enclosing group breaks.
+func Group(docs ...Doc) Doc {
+	return Doc{kind: docGroup, docs: docs, hard: anyHard(docs)}
+}
+
+// Nest returns a Doc that writes the documents with n more columns of
+// indentation after each new line.
+func Nest(n int, docs ...Doc) Doc {
+	return Doc{kind: docNest, nest: n, docs: docs, hard: anyHard(docs)}
+}
+
+// Indent returns a Doc that writes the documents indented by one more level,
+// see WithIndent.
+func Indent(docs ...Doc) Doc {
+	return Doc{kind: docIndent, docs: docs, hard: anyHard(docs)}
+}
+
+// LineSuffix returns a Doc that is written at the end of the current line,
+// just before the next new line, such as a comment after a statement that
+// is followed by a separator.
+func LineSuffix(docs ...Doc) Doc {
+	return Doc{kind: docLineSuffix, docs: docs}
+}
+
+// Join returns a Doc that writes the documents with sep between each of
+// them.
+func Join(sep Doc, docs ...Doc) Doc {
+	joined := make([]Doc, 0, 2*len(docs))
+	for i, d := range docs {
+		if i > 0 {
+			joined = append(joined, sep)
+		}
+		joined = append(joined, d)
+	}
+	return Concat(joined...)
+}
+
+// breakParent returns a Doc that writes nothing but breaks every enclosing
+// group.
+func breakParent() Doc {
+	return Doc{kind: docBreakParent, hard: true}
+}
+
+func anyHard(docs []Doc) bool {
+	for _, d := range docs {
+		if d.hard {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Formatter
+
+// FormatOption is a function type that modifies FormatConfig
+type FormatOption func(*FormatConfig)
+
+// FormatConfig holds the configuration for formatting
+type FormatConfig struct {
+	Width   int                   // The line width the printer tries to fit, 80 by default
+	Indent  int                   // The number of spaces added by Indent, 2 by default
+	Comment func(ASTToken) string // Returns the source text of a comment, its literal by default
+}
+
+// WithWidth returns a FormatOption that sets the line width the printer
+// tries to fit.
+func WithWidth(width int) FormatOption {
+	return func(c *FormatConfig) {
+		c.Width = width
+	}
+}
+
+// WithIndent returns a FormatOption that sets the number of spaces added by
+// each level of Indent.
+func WithIndent(spaces int) FormatOption {
+	return func(c *FormatConfig) {
+		c.Indent = spaces
+	}
+}
+
+// WithCommentFormat returns a FormatOption that sets the function returning
+// the source text of a comment. Scanners usually drop the delimiters of a
+// comment from its literal, so this is where they are put back.
+func WithCommentFormat(fn func(tok ASTToken) string) FormatOption {
+	return func(c *FormatConfig) {
+		c.Comment = fn
+	}
+}
+
+// FormatRule returns the Doc for a node. Rules call Formatter.Node for the
+// children they write, usually through Formatter.Children.
+type FormatRule func(f *Formatter, n *Node) Doc
+
+// Formatter writes an AST as source text using the FormatRule registered
+// for each NodeType. A node without a rule is written as its token literals
+// followed by its children, separated by Line, in a Group. The root node is
+// written as its children on separate lines, see Lines.
+//
+// A Formatter is not safe for concurrent use.
+type Formatter struct {
+	config   FormatConfig
+	rules    map[NodeType]FormatRule
+	dangling map[*Node]bool // Nodes whose dangling comments were placed by their rule
+}
+
+// NewFormatter returns a Formatter with no rules registered.
+func NewFormatter(opts ...FormatOption) *Formatter {
+	config := FormatConfig{Width: 80, Indent: 2, Comment: func(tok ASTToken) string { return tok.Literal }}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return &Formatter{config: config, rules: make(map[NodeType]FormatRule), dangling: make(map[*Node]bool)}
+}
+
+// Register sets the rule used to format nodes of the given type, replacing
+// any rule registered before.
+func (f *Formatter) Register(nt NodeType, rule FormatRule) {
+	f.rules[nt] = rule
+}
+
+// Format writes the AST followed by a new line to w. An AST holding errors
+// is not written, as the source text of the parts that failed to parse is
+// not in the tree.
+func (f *Formatter) Format(w io.Writer, a AST) error {
+	if a.RootNode == nil {
+		return nil
+	}
+	return f.FormatNode(w, a.RootNode)
+}
+
+// FormatNode writes the node and its descendants followed by a new line
+// to w.
+func (f *Formatter) FormatNode(w io.Writer, n *Node) error {
+	if hasErrors(n) {
+		return errors.New("dsl: cannot format a tree with errors")
+	}
+	f.dangling = make(map[*Node]bool)
+	var buf bytes.Buffer
+	f.render(&buf, f.Node(n))
+	if buf.Len() > 0 {
+		buf.WriteByte('\n')
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Render lays out the document and writes it to w.
+func (f *Formatter) Render(w io.Writer, d Doc) error {
+	var buf bytes.Buffer
+	f.render(&buf, d)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Node returns the Doc for the node from its rule, surrounded by its
+// comments.
+func (f *Formatter) Node(n *Node) Doc {
+	rule, ok := f.rules[n.Type]
+	if !ok {
+		rule = defaultRule
+	}
+	d := rule(f, n)
+
+	// Leading comments keep a blank line between them and whatever follows
+	var docs []Doc
+	leading := n.LeadingComments()
+	for i, c := range leading {
+		docs = append(docs, f.comment(c), HardLine())
+		next := nodeSpan(n).startLine
+		if i+1 < len(leading) {
+			next = leading[i+1].Line
+		}
+		if endLine, _ := literalEnd(c.Line, c.Position, c.Literal); next > endLine+1 {
+			docs = append(docs, HardLine())
+		}
+	}
+	docs = append(docs, d)
+	for _, c := range n.TrailingComments() {
+		docs = append(docs, LineSuffix(Text(" "), f.comment(c)), breakParent())
+	}
+	if !f.dangling[n] {
+		for _, c := range n.DanglingComments() {
+			docs = append(docs, HardLine(), f.comment(c))
+		}
+	}
+	return Concat(docs...)
+}
+
+// Children returns the Doc of each child of the node.
+func (f *Formatter) Children(n *Node) []Doc {
+	docs := make([]Doc, len(n.Children))
+	for i := range n.Children {
+		docs[i] = f.Node(&n.Children[i])
+	}
+	return docs
+}
+
+// Dangling returns the dangling comments of the node, each on its own line,
+// for a rule that places them itself, such as inside the brackets of an
+// empty list. Otherwise they are written after the node.
+func (f *Formatter) Dangling(n *Node) Doc {
+	f.dangling[n] = true
+	var docs []Doc
+	for i, c := range n.DanglingComments() {
+		if i > 0 {
+			docs = append(docs, HardLine())
+		}
+		docs = append(docs, f.comment(c))
+	}
+	return Concat(docs...)
+}
+
+// Lines returns the Doc of each node on its own line, with a blank line
+// between two nodes wherever the source had one or more.
+func (f *Formatter) Lines(nodes []Node) Doc {
+	var docs []Doc
+	for i := range nodes {
+		if i > 0 {
+			docs = append(docs, HardLine())
+			if commentSpan(&nodes[i]).startLine > commentSpan(&nodes[i-1]).endLine+1 {
+				docs = append(docs, HardLine())
+			}
+		}
+		docs = append(docs, f.Node(&nodes[i]))
+	}
+	return Concat(docs...)
+}
+
+func (f *Formatter) comment(tok ASTToken) Doc {
+	return Text(f.config.Comment(tok))
+}
+
+func defaultRule(f *Formatter, n *Node) Doc {
+	if n.Type == NODE_ROOT {
+		return f.Lines(n.Children)
+	}
+	var docs []Doc
+	for _, tok := range n.Tokens {
+		docs = append(docs, Text(tok.Literal))
+	}
+	docs = append(docs, f.Children(n)...)
+	return Group(Join(Line(), docs...))
+}
+
+// nodeSpan returns the span of the tokens of the node and its descendants.
+func nodeSpan(n *Node) span {
+	var s span
+	for _, tok := range sourceTokens(n) {
+		s.add(*tok)
+	}
+	return s
+}
+
+// commentSpan returns the span of the node, its descendants and the
+// comments attached to them.
+func commentSpan(n *Node) span {
+	s := nodeSpan(n)
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for _, c := range n.Comments {
+			s.add(c.Token)
+		}
+		for i := range n.Children {
+			walk(&n.Children[i])
+		}
+	}
+	walk(n)
+	return s
+}
+
+func hasErrors(n *Node) bool {
+	if len(n.Errors) > 0 {
+		return true
+	}
+	for i := range n.Children {
+		if hasErrors(&n.Children[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Layout
+
+// layoutCmd is a document waiting to be laid out at an indentation, either
+// flat or broken.
+type layoutCmd struct {
+	indent int
+	flat   bool
+	doc    Doc
+}
+
+// render lays out the document with the algorithm of Wadler's printer: a
+// group is written flat if it, and everything after it up to the next
+// possible line break, fits in the rest of the line.
+func (f *Formatter) render(buf *bytes.Buffer, d Doc) {
+	col := 0
+	var suffix []layoutCmd
+	stack := []layoutCmd{{doc: d}}
+	for len(stack) > 0 || len(suffix) > 0 {
+		if len(stack) == 0 {
+			// Write the line suffixes left at the end
+			stack = pushSuffix(stack, suffix)
+			suffix = suffix[:0]
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		switch c.doc.kind {
+		case docText:
+			buf.WriteString(c.doc.text)
+			if i := strings.LastIndexByte(c.doc.text, '\n'); i >= 0 {
+				col = utf8.RuneCountInString(c.doc.text[i+1:])
+			} else {
+				col += utf8.RuneCountInString(c.doc.text)
+			}
+		case docConcat:
+			stack = pushDocs(stack, c.indent, c.flat, c.doc.docs)
+		case docNest:
+			stack = pushDocs(stack, c.indent+c.doc.nest, c.flat, c.doc.docs)
+		case docIndent:
+			stack = pushDocs(stack, c.indent+f.config.Indent, c.flat, c.doc.docs)
+		case docGroup:
+			flat := c.flat
+			if !flat && !c.doc.hard {
+				flat = f.fits(f.config.Width-col, layoutCmd{c.indent, true, c.doc}, stack)
+			}
+			stack = pushDocs(stack, c.indent, flat, c.doc.docs)
+		case docLineSuffix:
+			suffix = append(suffix, layoutCmd{c.indent, c.flat, Concat(c.doc.docs...)})
+		case docLine, docSoftLine, docHardLine:
+			if c.flat && c.doc.kind != docHardLine {
+				if c.doc.kind == docLine {
+					buf.WriteByte(' ')
+					col++
+				}
+				continue
+			}
+			if len(suffix) > 0 {
+				// Write the line suffixes before the line break
+				stack = pushSuffix(append(stack, c), suffix)
+				suffix = suffix[:0]
+				continue
+			}
+			trimTrailingSpace(buf)
+			buf.WriteByte('\n')
+			buf.WriteString(strings.Repeat(" ", c.indent))
+			col = c.indent
+		}
+	}
+	trimTrailingSpace(buf)
+}
+
+// fits reports whether next, laid out flat, and the documents after it up
+// to the next line break fit in width columns.
+func (f *Formatter) fits(width int, next layoutCmd, rest []layoutCmd) bool {
+	cmds := []layoutCmd{next}
+	for width >= 0 {
+		if len(cmds) == 0 {
+			if len(rest) == 0 {
+				return true
+			}
+			cmds = append(cmds, rest[len(rest)-1])
+			rest = rest[:len(rest)-1]
+		}
+		c := cmds[len(cmds)-1]
+		cmds = cmds[:len(cmds)-1]
+
+		switch c.doc.kind {
+		case docText:
+			if i := strings.IndexByte(c.doc.text, '\n'); i >= 0 {
+				return width >= utf8.RuneCountInString(c.doc.text[:i])
+			}
+			width -= utf8.RuneCountInString(c.doc.text)
+		case docConcat, docNest, docIndent:
+			cmds = pushDocs(cmds, c.indent, c.flat, c.doc.docs)
+		case docGroup:
+			cmds = pushDocs(cmds, c.indent, c.flat || !c.doc.hard, c.doc.docs)
+		case docLine, docSoftLine:
+			if !c.flat {
+				return true
+			}
+			if c.doc.kind == docLine {
+				width--
+			}
+		case docHardLine:
+			return true
+		}
+	}
+	return false
+}
+
+func pushDocs(stack []layoutCmd, indent int, flat bool, docs []Doc) []layoutCmd {
+	for i := len(docs) - 1; i >= 0; i-- {
+		stack = append(stack, layoutCmd{indent, flat, docs[i]})
+	}
+	return stack
+}
+
+func pushSuffix(stack, suffix []layoutCmd) []layoutCmd {
+	for i := len(suffix) - 1; i >= 0; i-- {
+		stack = append(stack, suffix[i])
+	}
+	return stack
+}
+
+func trimTrailingSpace(buf *bytes.Buffer) {
+	b := buf.Bytes()
+	n := len(b)
+	for n > 0 && (b[n-1] == ' ' || b[n-1] == '\t') {
+		n--
+	}
+	buf.Truncate(n)
+}
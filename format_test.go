@@ -0,0 +1,144 @@
+package dsl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func renderDoc(t *testing.T, d Doc, opts ...FormatOption) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := NewFormatter(opts...).Render(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func list(items ...string) Doc {
+	var docs []Doc
+	for _, item := range items {
+		docs = append(docs, Text(item))
+	}
+	return Group(Text("["), Indent(SoftLine(), Join(Concat(Text(","), Line()), docs...)), SoftLine(), Text("]"))
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   Doc
+		width int
+		want  string
+	}{
+		{"group fits", list("1", "2", "3"), 80, "[1, 2, 3]"},
+		{"group breaks", list("1", "2", "3"), 8, "[\n  1,\n  2,\n  3\n]"},
+		{"exactly fits", list("1", "2", "3"), 9, "[1, 2, 3]"},
+		{"text after the group counts", Concat(list("1", "2"), Text(" // x")), 10, "[\n  1,\n  2\n] // x"},
+		{"nested group fits once outer breaks",
+			Group(Text("{"), Indent(Line(), Text("a: "), list("1", "2"), Text(","), Line(), Text("b: 3")), Line(), Text("}")),
+			12,
+			"{\n  a: [1, 2],\n  b: 3\n}"},
+		{"hard line breaks the group", Group(Text("a"), Line(), Text("b"), HardLine(), Text("c")), 80, "a\nb\nc"},
+		{"nest", Group(Text("a"), Nest(4, Line(), Text("b"))), 1, "a\n    b"},
+		{"no trailing space on blank lines", Indent(Text("a"), HardLine(), HardLine(), Text("b")), 80, "a\n\n  b"},
+		{"line suffix before the break", Group(Text("a"), LineSuffix(Text(" // a")), Text(","), HardLine(), Text("b")), 80, "a, // a\nb"},
+		{"line suffix at the end", Concat(Text("a"), LineSuffix(Text(" // a"))), 80, "a // a"},
+		{"multi-line text", Group(Text("`a\nbc`"), Line(), Text("d")), 80, "`a\nbc`\nd"},
+		{"empty", Doc{}, 80, ""},
+	}
+	for _, test := range tests {
+		if got := renderDoc(t, test.doc, WithWidth(test.width)); got != test.want {
+			t.Errorf("%v:\nexpected %q\nfound    %q", test.name, test.want, got)
+		}
+	}
+
+	if got := renderDoc(t, list("1", "2"), WithWidth(1), WithIndent(4)); got != "[\n    1,\n    2\n]" {
+		t.Errorf("WithIndent: found %q", got)
+	}
+}
+
+// newFormatAST builds the AST of
+//
+//	# first
+//	call(one, two)
+//
+//	call(three) # after
+func newFormatAST() AST {
+	a := newAST()
+	a.addHidden(Token{ID: "COMMENT", Literal: "first", Line: 1, Position: 1})
+	a.addNode("CALL")
+	a.addToken([]Token{{ID: "WORD", Literal: "call", Line: 2, Position: 1}})
+	a.addNode("ARG")
+	a.addToken([]Token{{ID: "WORD", Literal: "one", Line: 2, Position: 6}})
+	a.walkUp()
+	a.addNode("ARG")
+	a.addToken([]Token{{ID: "WORD", Literal: "two", Line: 2, Position: 11}})
+	a.walkUp()
+	a.walkUp()
+	a.addNode("CALL")
+	a.addToken([]Token{{ID: "WORD", Literal: "call", Line: 4, Position: 1}})
+	a.addNode("ARG")
+	a.addToken([]Token{{ID: "WORD", Literal: "three", Line: 4, Position: 6}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "after", Line: 4, Position: 13})
+	a.walkUp()
+	a.walkUp()
+	a.AttachComments("COMMENT")
+	return a
+}
+
+func TestFormatter(t *testing.T) {
+	a := newFormatAST()
+	format := func(opts ...FormatOption) string {
+		opts = append(opts, WithCommentFormat(func(tok ASTToken) string { return "# " + tok.Literal }))
+		f := NewFormatter(opts...)
+		f.Register("CALL", func(f *Formatter, n *Node) Doc {
+			args := Join(Concat(Text(","), Line()), f.Children(n)...)
+			return Group(Text(n.Tokens[0].Literal+"("), Indent(SoftLine(), args), SoftLine(), Text(")"))
+		})
+		var buf bytes.Buffer
+		if err := f.Format(&buf, a); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// ARG has no rule so it is written as its tokens. The comments and the
+	// blank line between the calls are kept.
+	want := "# first\ncall(one, two)\n\ncall(three) # after\n"
+	if got := format(); got != want {
+		t.Errorf("expected %q, found %q", want, got)
+	}
+
+	// Only the call that does not fit breaks. The trailing comment belongs
+	// to the second call, so it is outside its group and does not count.
+	want = "# first\ncall(\n  one,\n  two\n)\n\ncall(three) # after\n"
+	if got := format(WithWidth(12)); got != want {
+		t.Errorf("expected %q, found %q", want, got)
+	}
+
+	a.RootNode.Children[1].Errors = []Error{{Message: "broken"}}
+	if err := NewFormatter().Format(&bytes.Buffer{}, a); err == nil {
+		t.Errorf("expected an error formatting an AST with errors")
+	}
+}
+
+func TestFormatterDangling(t *testing.T) {
+	a := newAST()
+	a.addNode("LIST")
+	a.addToken([]Token{{ID: "OPEN", Literal: "[", Line: 1, Position: 1}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "# empty", Line: 1, Position: 3})
+	a.addToken([]Token{{ID: "CLOSE", Literal: "]", Line: 2, Position: 1}})
+	a.walkUp()
+	a.AttachComments()
+
+	f := NewFormatter()
+	f.Register("LIST", func(f *Formatter, n *Node) Doc {
+		return Concat(Text("["), Indent(HardLine(), f.Dangling(n)), HardLine(), Text("]"))
+	})
+	var buf bytes.Buffer
+	if err := f.Format(&buf, a); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[\n  # empty\n]\n"; buf.String() != want {
+		t.Errorf("expected %q, found %q", want, buf.String())
+	}
+}
@@ -0,0 +1,236 @@
+// graph.go exports an AST, or any branch of it, as a Graphviz DOT digraph or
+// a Mermaid flowchart for use in design reviews and documentation.
+//
+// Each node is labelled with its NodeType followed by the literals of its
+// tokens. Nodes can optionally be grouped into one cluster per depth and
+// nodes with errors attached can be highlighted.
+package dsl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// GraphOption is a function type that modifies GraphConfig
+type GraphOption func(*GraphConfig)
+
+// GraphConfig holds the configuration for exporting graphs
+type GraphConfig struct {
+	ClusterByDepth  bool
+	HighlightErrors bool
+}
+
+// WithDepthClusters returns a GraphOption that groups the nodes at each depth
+// of the tree into their own cluster (DOT) or subgraph (Mermaid).
+func WithDepthClusters() GraphOption {
+	return func(c *GraphConfig) {
+		c.ClusterByDepth = true
+	}
+}
+
+// WithErrorHighlight returns a GraphOption that highlights nodes that have
+// errors attached. The error messages are added to the DOT tooltip.
+func WithErrorHighlight() GraphOption {
+	return func(c *GraphConfig) {
+		c.HighlightErrors = true
+	}
+}
+
+// WriteDOT writes the entire AST to w as a Graphviz DOT digraph.
+func (a *AST) WriteDOT(w io.Writer, opts ...GraphOption) error {
+	return a.RootNode.WriteDOT(w, opts...)
+}
+
+// WriteMermaid writes the entire AST to w as a Mermaid flowchart.
+func (a *AST) WriteMermaid(w io.Writer, opts ...GraphOption) error {
+	return a.RootNode.WriteMermaid(w, opts...)
+}
+
+// WriteDOT writes the node and all of its descendants to w as a Graphviz DOT
+// digraph.
+func (n *Node) WriteDOT(w io.Writer, opts ...GraphOption) error {
+	g := newGraph(n, opts)
+	var buf bytes.Buffer
+
+	buf.WriteString("digraph AST {\n")
+	buf.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	writeNode := func(indent string, gn graphNode) {
+		fmt.Fprintf(&buf, "%s%s [label=%s", indent, gn.id, dotQuote(graphLabel(gn.node, "\n", nil)))
+		if g.config.HighlightErrors && len(gn.node.Errors) > 0 {
+			fmt.Fprintf(&buf, ", style=filled, fillcolor=\"#f8d7da\", color=\"#dc3545\", tooltip=%s", dotQuote(errorMessages(gn.node)))
+		}
+		buf.WriteString("];\n")
+	}
+	if g.config.ClusterByDepth {
+		for depth, level := range g.levels() {
+			fmt.Fprintf(&buf, "\tsubgraph cluster_depth_%d {\n", depth)
+			fmt.Fprintf(&buf, "\t\tlabel=\"depth %d\";\n", depth)
+			for _, gn := range level {
+				writeNode("\t\t", gn)
+			}
+			buf.WriteString("\t}\n")
+		}
+	} else {
+		for _, gn := range g.nodes {
+			writeNode("\t", gn)
+		}
+	}
+	for _, gn := range g.nodes {
+		if gn.parent != "" {
+			fmt.Fprintf(&buf, "\t%s -> %s;\n", gn.parent, gn.id)
+		}
+	}
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteMermaid writes the node and all of its descendants to w as a Mermaid
+// flowchart.
+func (n *Node) WriteMermaid(w io.Writer, opts ...GraphOption) error {
+	g := newGraph(n, opts)
+	var buf bytes.Buffer
+
+	buf.WriteString("flowchart TD\n")
+	writeNode := func(indent string, gn graphNode) {
+		fmt.Fprintf(&buf, "%s%s[\"%s\"]\n", indent, gn.id, graphLabel(gn.node, "<br/>", mermaidEscape))
+	}
+	if g.config.ClusterByDepth {
+		for depth, level := range g.levels() {
+			fmt.Fprintf(&buf, "\tsubgraph depth%d [\"depth %d\"]\n", depth, depth)
+			for _, gn := range level {
+				writeNode("\t\t", gn)
+			}
+			buf.WriteString("\tend\n")
+		}
+	} else {
+		for _, gn := range g.nodes {
+			writeNode("\t", gn)
+		}
+	}
+	for _, gn := range g.nodes {
+		if gn.parent != "" {
+			fmt.Fprintf(&buf, "\t%s --> %s\n", gn.parent, gn.id)
+		}
+	}
+	if g.config.HighlightErrors {
+		var errorIDs []string
+		for _, gn := range g.nodes {
+			if len(gn.node.Errors) > 0 {
+				errorIDs = append(errorIDs, gn.id)
+			}
+		}
+		if len(errorIDs) > 0 {
+			buf.WriteString("\tclassDef error fill:#f8d7da,stroke:#dc3545\n")
+			fmt.Fprintf(&buf, "\tclass %s error\n", strings.Join(errorIDs, ","))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ---------------------------------------------------------------------------------------------------------
+
+type graphNode struct {
+	id     string
+	parent string
+	depth  int
+	node   *Node
+}
+
+// graph holds the nodes of a tree in depth first order, each given an
+// identifier that is unique within the graph.
+type graph struct {
+	config GraphConfig
+	nodes  []graphNode
+}
+
+func newGraph(n *Node, opts []GraphOption) *graph {
+	g := &graph{}
+	for _, opt := range opts {
+		opt(&g.config)
+	}
+	g.add(n, "", 0)
+	return g
+}
+
+func (g *graph) add(n *Node, parent string, depth int) {
+	id := "n" + strconv.Itoa(len(g.nodes))
+	g.nodes = append(g.nodes, graphNode{id: id, parent: parent, depth: depth, node: n})
+	for i := range n.Children {
+		g.add(&n.Children[i], id, depth+1)
+	}
+}
+
+// levels groups the nodes by depth, keeping the depth first order within
+// each level.
+func (g *graph) levels() [][]graphNode {
+	var levels [][]graphNode
+	for _, gn := range g.nodes {
+		for len(levels) <= gn.depth {
+			levels = append(levels, nil)
+		}
+		levels[gn.depth] = append(levels[gn.depth], gn)
+	}
+	return levels
+}
+
+// graphLabel returns the node type and the quoted token literals separated by
+// the format specific line break. The escape function, if any, is applied to
+// the type and literals but not to the line break.
+func graphLabel(n *Node, lineBreak string, escape func(string) string) string {
+	if escape == nil {
+		escape = func(s string) string { return s }
+	}
+	label := escape(string(n.Type))
+	if len(n.Tokens) > 0 {
+		literals := make([]string, len(n.Tokens))
+		for i, tok := range n.Tokens {
+			literals[i] = strconv.Quote(tok.Literal)
+		}
+		label += lineBreak + escape(strings.Join(literals, " "))
+	}
+	return label
+}
+
+func errorMessages(n *Node) string {
+	messages := make([]string, len(n.Errors))
+	for i, err := range n.Errors {
+		messages[i] = fmt.Sprintf("Line %v: %v", err.StartLine, err.Message)
+	}
+	return strings.Join(messages, "\n")
+}
+
+// dotQuote returns s as a DOT quoted string. Newlines are written as the DOT
+// centred line break.
+func dotQuote(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for _, rn := range s {
+		switch rn {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(rn)
+		case '\n':
+			buf.WriteString(`\n`)
+		default:
+			buf.WriteRune(rn)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// mermaidEscape replaces the characters that cannot appear inside a quoted
+// Mermaid label with their entity codes. A # would start an entity code of
+// its own and a newline would end the label, so they are replaced too.
+func mermaidEscape(s string) string {
+	return mermaidReplacer.Replace(s)
+}
+
+var mermaidReplacer = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "#", "#35;", "\n", "<br/>")
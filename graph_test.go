@@ -0,0 +1,62 @@
+package dsl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	a := newTestAST()
+	var buf bytes.Buffer
+	if err := a.WriteDOT(&buf, WithDepthClusters(), WithErrorHighlight()); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, expected := range []string{
+		"digraph AST {",
+		`n1 [label="ASSIGNMENT\n\"a\""];`,
+		`n4 [label="TERMINAL\n\"say \\\"hi\\\"\\n\"", style=filled, fillcolor="#f8d7da", color="#dc3545", tooltip="Line 1: found [x]"];`,
+		"subgraph cluster_depth_3 {",
+		"n3 -> n4;",
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("DOT output is missing %s\n%s", expected, dot)
+		}
+	}
+}
+
+func TestWriteMermaid(t *testing.T) {
+	a := newTestAST()
+	var buf bytes.Buffer
+	if err := a.RootNode.Children[0].WriteMermaid(&buf, WithErrorHighlight()); err != nil {
+		t.Fatal(err)
+	}
+	mermaid := buf.String()
+	for _, expected := range []string{
+		"flowchart TD\n",
+		`n0["ASSIGNMENT<br/>#quot;a#quot;"]`,
+		"n2 --> n3\n",
+		"class n3 error\n",
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Mermaid output is missing %s\n%s", expected, mermaid)
+		}
+	}
+	if strings.Contains(mermaid, "ROOT") {
+		t.Errorf("Mermaid output of a sub-node should not contain the root node\n%s", mermaid)
+	}
+}
+
+func TestMermaidEscape(t *testing.T) {
+	a := newAST()
+	a.addNode("COLOUR #1\nRED")
+	a.addToken([]Token{{ID: "HEX", Literal: "#f00"}})
+	var buf bytes.Buffer
+	if err := a.WriteMermaid(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `n1["COLOUR #35;1<br/>RED<br/>#quot;#35;f00#quot;"]`; !strings.Contains(buf.String(), expected) {
+		t.Errorf("Mermaid output is missing %s\n%s", expected, buf.String())
+	}
+}
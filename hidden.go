@@ -0,0 +1,89 @@
+// hidden.go implements hidden token channels. Token types such as comments
+// can appear almost anywhere in a source text, so expecting them explicitly
+// means handling them in every parse function. Hidden tokens are read from
+// the scanner like any other token, but the Parser never passes them to
+// Expect or ExpectNot. Instead they are collected in the order they were
+// found and attached to the AST node being built when they were read.
+
+package dsl
+
+// WithHiddenTokens returns a ParseOption that hides tokens of the given
+// types from the user parse function from the start of the parse. See
+// Parser.HideTokens.
+func WithHiddenTokens(ids ...TokenType) ParseOption {
+	return func(c *ParseConfig) {
+		c.HiddenTokens = append(c.HiddenTokens, ids...)
+	}
+}
+
+// HideTokens hides tokens of the given types from Expect and ExpectNot.
+// Hidden tokens are skipped transparently, kept in the order they were
+// found for HiddenTokens and added to the Hidden tokens of the current node.
+//
+// A grammar can call HideTokens at the start of its parse function to
+// declare its hidden tokens, or call it along with ShowTokens to hide a
+// token type only in some contexts. Only tokens read from the scanner after
+// the call are affected, tokens that have already been peeked are not.
+func (p *Parser) HideTokens(ids ...TokenType) {
+	if p.trace {
+		p.log("Hide Tokens: "+joinTokenTypes(ids), prefixNewline)
+	}
+	if p.hidden == nil {
+		p.hidden = make(map[TokenType]bool)
+	}
+	for _, id := range ids {
+		p.hidden[id] = true
+	}
+}
+
+// ShowTokens stops hiding tokens of the given types.
+func (p *Parser) ShowTokens(ids ...TokenType) {
+	if p.trace {
+		p.log("Show Tokens: "+joinTokenTypes(ids), prefixNewline)
+	}
+	for _, id := range ids {
+		delete(p.hidden, id)
+	}
+}
+
+// HiddenTokens returns every hidden token found so far, in the order they
+// were found. They are kept for the whole parse, so they grow with the input
+// even when the tree is passed on as events with WithEvents.
+func (p *Parser) HiddenTokens() []Token {
+	return p.hiddenTokens
+}
+
+// hide reports whether the token is hidden and, if so, collects it. The end
+// of the input is never hidden, nor is a hidden token found at the same
+// place as the last, so a scan function that does not consume any input
+// still reaches the infinite loop detection.
+func (p *Parser) hide(tok Token) bool {
+	if !p.hidden[tok.ID] || tok.ID == TOKEN_EOF {
+		return false
+	}
+	if n := len(p.hiddenTokens); n > 0 {
+		if last := p.hiddenTokens[n-1]; last.Line == tok.Line && last.Position == tok.Position {
+			return false
+		}
+	}
+	if p.trace {
+		p.log("Hidden: "+string(tok.ID)+" - "+sanitize(tok.Literal, true), prefixNewline)
+	}
+	p.hiddenTokens = append(p.hiddenTokens, tok)
+	p.ast.addHidden(tok)
+	if p.cst != nil {
+		p.cst.addHidden(tok)
+	}
+	return true
+}
+
+func joinTokenTypes(ids []TokenType) string {
+	var s string
+	for i, id := range ids {
+		if i > 0 {
+			s += ", "
+		}
+		s += string(id)
+	}
+	return s
+}
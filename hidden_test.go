@@ -0,0 +1,116 @@
+package dsl
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestHideTokens(t *testing.T) {
+	parser := &Parser{
+		s: &mockScanner{
+			tokens: []Token{
+				{ID: "a", Literal: "a", Line: 1, Position: 1},
+				{ID: "COMMENT", Literal: "one", Line: 1, Position: 3},
+				{ID: "b", Literal: "b", Line: 1, Position: 8},
+				{ID: "COMMENT", Literal: "two", Line: 2, Position: 1},
+				{ID: "COMMENT", Literal: "", Line: 2, Position: 1},
+			},
+		},
+		ast: newAST(),
+		l:   &mockLogger{},
+	}
+	parser.HideTokens("COMMENT")
+
+	parser.Expect(ExpectToken{
+		Branches: []BranchToken{{Id: "a"}, {Id: "b"}},
+		Options:  ParseOptions{Multiple: true},
+	})
+	if parser.err {
+		t.Fatalf("Expect returned an error, hidden tokens were not skipped: %v", parser.errors)
+	}
+	if got := joinLiterals(parser.tokens); got != "a b" {
+		t.Errorf("Unexpected tokens consumed: got %q, want %q", got, "a b")
+	}
+
+	// The second comment is found where the last hidden token was, so it is
+	// passed to the parse function rather than skipped forever.
+	parser.Expect(ExpectToken{Branches: []BranchToken{{Id: "COMMENT"}}})
+	if parser.err {
+		t.Errorf("Expect did not find the comment that made no progress")
+	}
+
+	hidden := parser.HiddenTokens()
+	if len(hidden) != 2 || hidden[0].Literal != "one" || hidden[1].Literal != "two" {
+		t.Errorf("Unexpected hidden tokens: %+v", hidden)
+	}
+	if root := parser.ast.RootNode; len(root.Hidden) != 2 || root.Hidden[1].Literal != "two" {
+		t.Errorf("Hidden tokens were not attached to the current node: %+v", root.Hidden)
+	}
+}
+
+func TestShowTokens(t *testing.T) {
+	parser := &Parser{
+		s: &mockScanner{
+			tokens: []Token{
+				{ID: "COMMENT", Literal: "one", Line: 1, Position: 1},
+				{ID: "a", Literal: "a", Line: 1, Position: 5},
+				{ID: "COMMENT", Literal: "two", Line: 1, Position: 7},
+			},
+		},
+		ast: newAST(),
+		l:   &mockLogger{},
+	}
+	parser.HideTokens("COMMENT")
+	parser.Expect(ExpectToken{Branches: []BranchToken{{Id: "a"}}})
+	parser.ShowTokens("COMMENT")
+	parser.Expect(ExpectToken{Branches: []BranchToken{{Id: "COMMENT"}}})
+	if parser.err {
+		t.Fatalf("Expect returned an error: %v", parser.errors)
+	}
+	if got := joinLiterals(parser.tokens); got != "a two" {
+		t.Errorf("Unexpected tokens consumed: got %q, want %q", got, "a two")
+	}
+	if hidden := parser.HiddenTokens(); len(hidden) != 1 {
+		t.Errorf("Expected only the first comment to be hidden, found %+v", hidden)
+	}
+}
+
+func TestWithHiddenTokens(t *testing.T) {
+	// Without hiding, the semicolons have to be expected and skipped by the
+	// parse function. Hidden, the parse function never sees them.
+	parse := func(p *Parser) (AST, []Error) {
+		p.AddNode("STATEMENT")
+		p.Expect(ExpectToken{
+			Branches: []BranchToken{{Id: "WORD"}, {Id: "STRING"}},
+			Options:  ParseOptions{Multiple: true},
+		})
+		p.AddTokens()
+		return p.ast, p.errors
+	}
+	input := "one ; two;\"three\""
+	ast, errs := Parse(parse, triviaScan, bufio.NewReader(strings.NewReader(input)), WithHiddenTokens("SEMI"))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	statement := ast.RootNode.Children[0]
+	var literals []string
+	for _, tok := range statement.Tokens {
+		literals = append(literals, tok.Literal)
+	}
+	if got := strings.Join(literals, " "); got != "one two three" {
+		t.Errorf("Unexpected tokens: got %q, want %q", got, "one two three")
+	}
+	if len(statement.Hidden) != 2 || statement.Hidden[0].ID != "SEMI" || statement.Hidden[1].Position != 10 {
+		t.Errorf("Unexpected hidden tokens: %+v", statement.Hidden)
+	}
+}
+
+func joinLiterals(tokens []Token) string {
+	var literals []string
+	for _, tok := range tokens {
+		literals = append(literals, tok.Literal)
+	}
+	return strings.Join(literals, " ")
+}
@@ -0,0 +1,403 @@
+// incremental.go implements incremental reparsing for editors. A Document
+// keeps the source and AST of a parse along with where the text of each
+// top-level node starts and ends. Edit applies a text edit, rescans and
+// reparses only the top-level nodes whose text the edit touches, and
+// reuses the rest of the tree, moving the nodes after the edit to their
+// new lines and positions.
+//
+// The text of a top-level node runs from the first rune scanned for it,
+// including any skipped runes, up to the first rune scanned for the next,
+// so it holds the separators, comments and other tokens the parser read
+// while the node was being built. The edited nodes are reparsed on their
+// own by running the parse function over their text, so this works for
+// grammars whose parse function accepts a sequence of top-level nodes, such
+// as statements, and reads up to TOKEN_EOF.
+//
+// Edit falls back to parsing the whole source whenever it cannot tell that
+// reusing the tree gives the same result: when the previous parse or the
+// reparsed text has errors, when the parse function stops before the end of
+// the reparsed text, when a top-level node has no tokens, when the root
+// holds tokens of its own, and with WithTrivia or WithCST. Hidden tokens
+// read next to the edited nodes may still be kept on a different node than
+// a full parse would choose, but with WithComments the comments are always
+// attached again to the whole tree.
+//
+// The nodes reused keep their IDs and attributes, while the reparsed nodes
+// are given new IDs and have no attributes, so analysis passes only need to
+// revisit those. The root keeps its ID but not its attributes. When Edit
+// falls back to a full parse every node is numbered afresh.
+
+package dsl
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a source text parsed with ParseDocument, along with what Edit
+// needs to reparse it after a change. A Document is never modified, each
+// Edit returns a new one.
+type Document struct {
+	Source string
+	AST    AST
+	Errors []Error
+
+	pf     ParseFunc
+	sf     ScanFunc
+	opts   []ParseOption
+	config *ParseConfig
+	bounds []int // The offset in Source where the text of each top-level node ends, nil to always reparse everything
+}
+
+// TextEdit replaces the source text from the start, up to but not including
+// the end, with Text. Lines and positions count runes from 1 as they do for
+// a Token, so the end of a line is the position of its line break and the
+// end of the source is one past its last rune.
+type TextEdit struct {
+	StartLine, StartPosition int
+	EndLine, EndPosition     int
+	Text                     string
+}
+
+// ParseDocument parses the source like Parse and returns it as a Document
+// ready to be edited.
+func ParseDocument(pf ParseFunc, sf ScanFunc, source string, opts ...ParseOption) *Document {
+	d := &Document{Source: source, pf: pf, sf: sf, opts: opts, config: newParseConfig(opts)}
+	var eof bool
+	d.AST, d.Errors, d.bounds, eof = d.parse(source)
+	if len(d.Errors) > 0 || !eof {
+		d.bounds = nil
+	}
+	return d
+}
+
+// Edit applies the edit to the source and returns the new Document along
+// with the top-level nodes of its AST that were reparsed. Every other
+// top-level node was reused from the previous AST, which is left as it was.
+func (d *Document) Edit(e TextEdit) (*Document, []*Node, error) {
+	start, ok := lineOffset(d.Source, e.StartLine, e.StartPosition)
+	end, endOK := lineOffset(d.Source, e.EndLine, e.EndPosition)
+	if !ok || !endOK || end < start {
+		return nil, nil, fmt.Errorf("dsl: edit %d:%d-%d:%d is outside the source", e.StartLine, e.StartPosition, e.EndLine, e.EndPosition)
+	}
+	source := d.Source[:start] + e.Text + d.Source[end:]
+	if d.bounds == nil {
+		return d.reparse(source)
+	}
+
+	// The nodes whose text the edit touches, including a node ending or
+	// starting right at the edit as its last token may continue into it.
+	first, last := -1, -1
+	for i := range d.bounds {
+		if d.regionStart(i) <= end && start <= d.bounds[i] {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return d.reparse(source)
+	}
+
+	delta := len(e.Text) - (end - start)
+	winStart, oldEnd := d.regionStart(first), d.bounds[last]
+	newEnd := oldEnd + delta
+	window, errs, bounds, eof := d.parse(source[winStart:newEnd])
+	if len(errs) > 0 || !eof || bounds == nil || len(window.RootNode.Children) == 0 {
+		return d.reparse(source)
+	}
+
+	// Move the reparsed nodes to where their text starts in the new source
+	// and the nodes after them by however much the edit moved their text.
+	startLine, startPos := linePosition(source, winStart)
+	shiftTree(window.RootNode, 1, 1, startLine, startPos)
+	oldLine, oldPos := linePosition(d.Source, oldEnd)
+	newLine, newPos := linePosition(source, newEnd)
+
+	old := d.AST.RootNode
+	ast := AST{nextID: d.AST.nextID} // Numbers the reparsed nodes after those of the previous parse
+	root := &Node{ID: old.ID, Type: old.Type, Attrs: old.Attrs.clone()}
+	var comments []ASTToken // Comments outside the window attached to a reparsed node
+	place := func(tok ASTToken) (ASTToken, bool) {
+		offset, _ := lineOffset(d.Source, tok.Line, tok.Position)
+		switch {
+		case offset < winStart:
+			return tok, true
+		case offset >= oldEnd:
+			shiftToken(&tok, oldLine, oldPos, newLine, newPos)
+			return tok, true
+		}
+		return tok, false
+	}
+
+	for _, tok := range old.Hidden {
+		if tok, ok := place(tok); ok {
+			root.Hidden = append(root.Hidden, tok)
+		}
+	}
+	root.Hidden = append(root.Hidden, window.RootNode.Hidden...)
+	root.Comments = placeComments(old.Comments, place)
+	root.Comments = append(root.Comments, window.RootNode.Comments...)
+
+	for i := range old.Children {
+		switch {
+		case i < first:
+			root.Children = append(root.Children, copyNode(&old.Children[i]))
+			placeTreeComments(&root.Children[len(root.Children)-1], place)
+		case i > last:
+			n := copyNode(&old.Children[i])
+			placeTreeComments(&n, func(tok ASTToken) (ASTToken, bool) {
+				offset, _ := lineOffset(d.Source, tok.Line, tok.Position)
+				return tok, offset < winStart || offset >= oldEnd
+			})
+			shiftTree(&n, oldLine, oldPos, newLine, newPos)
+			root.Children = append(root.Children, n)
+		default:
+			visitTokens(&old.Children[i], func(c *Comment) {
+				if tok, ok := place(c.Token); ok {
+					comments = append(comments, tok)
+				}
+			})
+			if i == last {
+				for j := range window.RootNode.Children {
+					ast.renumber(&window.RootNode.Children[j])
+				}
+				root.Children = append(root.Children, window.RootNode.Children...)
+			}
+		}
+	}
+	root.Hidden = append(root.Hidden, comments...)
+	linkParents(root)
+
+	nd := &Document{Source: source, pf: d.pf, sf: d.sf, opts: d.opts, config: d.config}
+	nd.AST = AST{RootNode: root, curNode: root, nextID: ast.nextID}
+	if len(d.config.Comments) > 0 {
+		nd.AST.AttachComments(d.config.Comments...)
+	}
+	nd.bounds = append(nd.bounds, d.bounds[:first]...)
+	for _, b := range bounds {
+		nd.bounds = append(nd.bounds, winStart+b)
+	}
+	for _, b := range d.bounds[last+1:] {
+		nd.bounds = append(nd.bounds, b+delta)
+	}
+
+	changed := make([]*Node, len(window.RootNode.Children))
+	for i := range changed {
+		changed[i] = &root.Children[first+i]
+	}
+	return nd, changed, nil
+}
+
+// reparse parses the new source from scratch, every top-level node has
+// changed.
+func (d *Document) reparse(source string) (*Document, []*Node, error) {
+	nd := ParseDocument(d.pf, d.sf, source, d.opts...)
+	var changed []*Node
+	if root := nd.AST.RootNode; root != nil {
+		for i := range root.Children {
+			changed = append(changed, &root.Children[i])
+		}
+	}
+	return nd, changed, nil
+}
+
+// parse parses the source, returning the AST, the errors, the offset where
+// the text of each top-level node ends and whether the parse function read
+// up to TOKEN_EOF. The offsets are found from the trivia of the scanned
+// tokens, which is then dropped as it was not asked for.
+func (d *Document) parse(source string) (AST, []Error, []int, bool) {
+	config := *d.config
+	if config.Trivia || config.CST != nil {
+		p := d.setup(source, &config)
+		ast, errs := execute(p)
+		return ast, errs, nil, p.eof
+	}
+
+	config.Trivia = true
+	p := d.setup(source, &config)
+	ast, errs := execute(p)
+	bounds := regionBounds(ast.RootNode, p.scanned, len(source))
+	if ast.RootNode != nil {
+		stripTrivia(ast.RootNode)
+	}
+	return ast, errs, bounds, p.eof
+}
+
+// setup returns a parser reading the source in place.
+func (d *Document) setup(source string, config *ParseConfig) *Parser {
+	logger := config.logger()
+	return setupScanner(d.pf, newStringScanner(d.sf, source, logger), logger, config)
+}
+
+func (d *Document) regionStart(i int) int {
+	if i == 0 {
+		return 0
+	}
+	return d.bounds[i-1]
+}
+
+// regionBounds returns the offset where the text of each child of the root
+// ends, which is where the trivia of the first token scanned for the next
+// child starts, or the end of the source for the last child. It returns nil
+// if the children cannot be told apart.
+func regionBounds(root *Node, scanned []Token, size int) []int {
+	if root == nil || len(root.Tokens) > 0 {
+		return nil
+	}
+	bounds := make([]int, len(root.Children))
+	offset, next := 0, 0
+	var prev *ASTToken
+	for i := range root.Children {
+		tokens := sourceTokens(&root.Children[i])
+		if len(tokens) == 0 {
+			return nil
+		}
+		if prev != nil && !posBefore(prev.Line, prev.Position, tokens[0].Line, tokens[0].Position) {
+			return nil // The children overlap
+		}
+		prev = tokens[len(tokens)-1]
+		if i == 0 {
+			continue
+		}
+		for next < len(scanned) && !sameToken(*tokens[0], scanned[next]) {
+			offset += len(scanned[next].Text())
+			next++
+		}
+		if next == len(scanned) {
+			return nil
+		}
+		bounds[i-1] = offset
+	}
+	if len(bounds) > 0 {
+		bounds[len(bounds)-1] = size
+	}
+	return bounds
+}
+
+// ----------------------------------------------------------------------------
+// Tree helpers
+
+// copyNode returns a deep copy of the node, so the copy can be changed
+// without changing the tree it came from. The Parent references of the copy
+// must be linked again.
+func copyNode(n *Node) Node {
+	c := *n
+	c.Tokens = append([]ASTToken(nil), n.Tokens...)
+	c.Hidden = append([]ASTToken(nil), n.Hidden...)
+	c.Comments = append([]Comment(nil), n.Comments...)
+	c.Errors = append([]Error(nil), n.Errors...)
+	c.Attrs = n.Attrs.clone()
+	c.Children = nil
+	if n.Children != nil {
+		c.Children = make([]Node, len(n.Children))
+		for i := range n.Children {
+			c.Children[i] = copyNode(&n.Children[i])
+		}
+	}
+	return c
+}
+
+// visitTokens calls fn with every comment in the tree.
+func visitTokens(n *Node, fn func(*Comment)) {
+	for i := range n.Comments {
+		fn(&n.Comments[i])
+	}
+	for i := range n.Children {
+		visitTokens(&n.Children[i], fn)
+	}
+}
+
+// placeTreeComments keeps only the comments of the tree that place accepts.
+func placeTreeComments(n *Node, place func(ASTToken) (ASTToken, bool)) {
+	n.Comments = placeComments(n.Comments, place)
+	for i := range n.Children {
+		placeTreeComments(&n.Children[i], place)
+	}
+}
+
+func placeComments(comments []Comment, place func(ASTToken) (ASTToken, bool)) []Comment {
+	var kept []Comment
+	for _, c := range comments {
+		if tok, ok := place(c.Token); ok {
+			kept = append(kept, Comment{Kind: c.Kind, Token: tok})
+		}
+	}
+	return kept
+}
+
+// shiftTree moves every token in the tree found at or after fromLine:fromPos
+// so that fromLine:fromPos becomes toLine:toPos.
+func shiftTree(n *Node, fromLine, fromPos, toLine, toPos int) {
+	for i := range n.Tokens {
+		shiftToken(&n.Tokens[i], fromLine, fromPos, toLine, toPos)
+	}
+	for i := range n.Hidden {
+		shiftToken(&n.Hidden[i], fromLine, fromPos, toLine, toPos)
+	}
+	for i := range n.Comments {
+		shiftToken(&n.Comments[i].Token, fromLine, fromPos, toLine, toPos)
+	}
+	for i := range n.Children {
+		shiftTree(&n.Children[i], fromLine, fromPos, toLine, toPos)
+	}
+}
+
+func shiftToken(tok *ASTToken, fromLine, fromPos, toLine, toPos int) {
+	if tok.Line == fromLine {
+		tok.Position += toPos - fromPos
+	}
+	tok.Line += toLine - fromLine
+}
+
+func stripTrivia(n *Node) {
+	for i := range n.Tokens {
+		n.Tokens[i].Leading, n.Tokens[i].Trailing = "", ""
+	}
+	for i := range n.Hidden {
+		n.Hidden[i].Leading, n.Hidden[i].Trailing = "", ""
+	}
+	for i := range n.Comments {
+		n.Comments[i].Token.Leading, n.Comments[i].Token.Trailing = "", ""
+	}
+	for i := range n.Children {
+		stripTrivia(&n.Children[i])
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Source positions
+
+// lineOffset returns the byte offset in the source of the rune at line:pos,
+// or of the end of the source when line:pos is one past its last rune.
+func lineOffset(source string, line, pos int) (int, bool) {
+	if line < 1 || pos < 1 {
+		return 0, false
+	}
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := strings.IndexByte(source[offset:], '\n')
+		if i < 0 {
+			return 0, false
+		}
+		offset += i + 1
+	}
+	for p := 1; p < pos; p++ {
+		if offset >= len(source) || source[offset] == '\n' {
+			return 0, false
+		}
+		_, size := utf8.DecodeRuneInString(source[offset:])
+		offset += size
+	}
+	return offset, true
+}
+
+// linePosition returns the line and position of the rune at the byte offset
+// in the source.
+func linePosition(source string, offset int) (line, pos int) {
+	lines := strings.Count(source[:offset], "\n")
+	start := strings.LastIndexByte(source[:offset], '\n') + 1
+	return lines + 1, utf8.RuneCountInString(source[start:offset]) + 1
+}
@@ -0,0 +1,55 @@
+package dsl
+
+import "testing"
+
+func TestLineOffset(t *testing.T) {
+	source := "ab\né c\n"
+	tests := []struct {
+		line, pos, offset int
+		ok                bool
+	}{
+		{1, 1, 0, true},
+		{1, 3, 2, true},
+		{2, 2, 5, true},
+		{2, 4, 7, true},
+		{3, 1, 8, true},
+		{1, 4, 0, false},
+		{3, 2, 0, false},
+		{4, 1, 0, false},
+		{0, 1, 0, false},
+	}
+	for _, test := range tests {
+		offset, ok := lineOffset(source, test.line, test.pos)
+		if ok != test.ok || (ok && offset != test.offset) {
+			t.Errorf("%d:%d: expected %d %v, found %d %v", test.line, test.pos, test.offset, test.ok, offset, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if line, pos := linePosition(source, offset); line != test.line || pos != test.pos {
+			t.Errorf("offset %d: expected %d:%d, found %d:%d", offset, test.line, test.pos, line, pos)
+		}
+	}
+}
+
+func TestEditOutsideSource(t *testing.T) {
+	d := ParseDocument(cstParse, triviaScan, "one;\ntwo;")
+	edits := []TextEdit{
+		{StartLine: 3, StartPosition: 1, EndLine: 3, EndPosition: 1},
+		{StartLine: 2, StartPosition: 1, EndLine: 1, EndPosition: 1},
+	}
+	for _, e := range edits {
+		if _, _, err := d.Edit(e); err == nil {
+			t.Errorf("%+v: expected an error", e)
+		}
+	}
+
+	nd, _, err := d.Edit(TextEdit{StartLine: 2, StartPosition: 1, EndLine: 2, EndPosition: 4, Text: "three"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.Source != "one;\nthree;" || d.Source != "one;\ntwo;" {
+		t.Errorf("unexpected sources %q and %q", d.Source, nd.Source)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"runtime"
 )
 
+// logger writes the trace of a parse. tracing reports whether anything is
+// written, so callers can skip building messages that would be dropped.
 type logger interface {
 	log(msg string, indent indent)
+	tracing() bool
 }
 
 // dslLogger is a simple logger that uses the standard log package to print
@@ -75,6 +78,8 @@ func (l *dslLogger) log(msg string, indent indent) {
 	l.buf = append(l.buf, msg)
 }
 
+func (l *dslLogger) tracing() bool { return true }
+
 func (l *dslLogger) printLogBuffer() {
 	if l.buf != nil {
 		l.logger.Print(l.buf...)
@@ -88,6 +93,8 @@ type dslNoLogger struct{}
 
 func (l *dslNoLogger) log(msg string, indent indent) {}
 
+func (l *dslNoLogger) tracing() bool { return false }
+
 // ---------------------------------------------------------------------------------------------------------
 
 // Uses reflection package to pull out the name of the currently executing user
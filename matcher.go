@@ -0,0 +1,195 @@
+// matcher.go implements compiled rune matchers for the branch tables of
+// Scanner.Expect and Scanner.ExpectNot. Without one, every rune read is
+// compared to each branch and then each range in turn. Compile turns the
+// table into a matcher once, looking up ASCII runes in a bit set and any
+// other rune by a binary search of a sorted range table, so the work per rune
+// no longer grows with the size of the table.
+//
+// A compiled table is meant to be built once, in a package variable, and
+// reused by every call:
+//
+//	var digits = dsl.ExpectRune{
+//		BranchRanges: []dsl.BranchRange{{StartRn: '0', EndRn: '9'}},
+//		Options:      dsl.ExpectRuneOptions{Multiple: true},
+//	}.MustCompile()
+//
+// Compiling also checks the table. Without a matcher a rune matched by more
+// than one branch takes the first, with branches tried before ranges, which
+// hides mistakes in the table. Compile reports such overlaps, and ranges that
+// match nothing, as errors.
+
+package dsl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// runeMatcher finds the branch matching a rune. Branches are numbered as in
+// coverage, the runes or branches first and then the ranges.
+type runeMatcher struct {
+	ascii  [2]uint64      // Bit set of the ASCII runes matched
+	branch [128]int32     // Branch matching each ASCII rune in the bit set
+	ranges []matcherRange // Branches matching other runes, sorted and not overlapping
+}
+
+type matcherRange struct {
+	start, end rune
+	branch     int
+}
+
+// newRuneMatcher compiles the runes and ranges of a table, numbered in that
+// order, returning an error if any rune is matched by more than one of them.
+func newRuneMatcher(runes []rune, ranges []RuneRange) (*runeMatcher, error) {
+	var all []matcherRange
+	for i, rn := range runes {
+		all = append(all, matcherRange{rn, rn, i})
+	}
+	for i, r := range ranges {
+		if r.StartRn > r.EndRn {
+			return nil, fmt.Errorf("dsl: %v matches no runes", describeBranch(runes, ranges, len(runes)+i))
+		}
+		all = append(all, matcherRange{r.StartRn, r.EndRn, len(runes) + i})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].start < all[j].start })
+	for i := 1; i < len(all); i++ {
+		if prev := all[i-1]; all[i].start <= prev.end {
+			return nil, fmt.Errorf("dsl: rune %v is matched by both %v and %v", sanitize(string(all[i].start), true),
+				describeBranch(runes, ranges, min(prev.branch, all[i].branch)), describeBranch(runes, ranges, max(prev.branch, all[i].branch)))
+		}
+	}
+
+	m := &runeMatcher{}
+	for _, r := range all {
+		for rn := max(r.start, 0); rn <= r.end && rn < 128; rn++ {
+			m.ascii[rn/64] |= 1 << (rn % 64)
+			m.branch[rn] = int32(r.branch)
+		}
+		if r.end >= 128 {
+			m.ranges = append(m.ranges, matcherRange{max(r.start, 128), r.end, r.branch})
+		}
+	}
+	return m, nil
+}
+
+// match returns the branch matching the rune, or -1 if there is none.
+func (m *runeMatcher) match(rn rune) int {
+	if rn >= 0 && rn < 128 {
+		if m.ascii[rn/64]&(1<<(rn%64)) != 0 {
+			return int(m.branch[rn])
+		}
+		return -1
+	}
+	i := sort.Search(len(m.ranges), func(i int) bool { return m.ranges[i].end >= rn })
+	if i < len(m.ranges) && m.ranges[i].start <= rn {
+		return m.ranges[i].branch
+	}
+	return -1
+}
+
+// describeBranch names a branch of a table in errors.
+func describeBranch(runes []rune, ranges []RuneRange, branch int) string {
+	if branch < len(runes) {
+		return fmt.Sprintf("[%v]", sanitize(string(runes[branch]), true))
+	}
+	r := ranges[branch-len(runes)]
+	return fmt.Sprintf("range [%v-%v]", sanitize(string(r.StartRn), true), sanitize(string(r.EndRn), true))
+}
+
+// Compile returns a copy of the ExpectRune holding a matcher for its
+// branches, which Expect uses in place of comparing each branch in turn. An
+// error is returned if a rune is matched by more than one branch or branch
+// range, or a range matches no runes. The branches of the copy must not be
+// modified, as the matcher is built from them.
+func (e ExpectRune) Compile() (ExpectRune, error) {
+	runes := make([]rune, len(e.Branches))
+	for i, branch := range e.Branches {
+		runes[i] = branch.Rn
+	}
+	ranges := make([]RuneRange, len(e.BranchRanges))
+	for i, branch := range e.BranchRanges {
+		ranges[i] = RuneRange{branch.StartRn, branch.EndRn}
+	}
+	m, err := newRuneMatcher(runes, ranges)
+	if err != nil {
+		return e, err
+	}
+	e.matcher = m
+	return e, nil
+}
+
+// MustCompile is like Compile but panics if the branches can not be
+// compiled, for tables held in package variables.
+func (e ExpectRune) MustCompile() ExpectRune {
+	c, err := e.Compile()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Compile returns a copy of the ExpectNotRune holding a matcher for its
+// runes and ranges, as ExpectRune.Compile does.
+func (e ExpectNotRune) Compile() (ExpectNotRune, error) {
+	m, err := newRuneMatcher(e.Runes, e.RuneRanges)
+	if err != nil {
+		return e, err
+	}
+	e.matcher = m
+	return e, nil
+}
+
+// MustCompile is like Compile but panics if the runes can not be compiled.
+func (e ExpectNotRune) MustCompile() ExpectNotRune {
+	c, err := e.Compile()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// match returns the branch matching the rune, branches numbered before
+// branch ranges, or -1 if there is none.
+func (e *ExpectRune) match(rn rune) int {
+	if e.matcher != nil {
+		return e.matcher.match(rn)
+	}
+	for i, branch := range e.Branches {
+		if branch.Rn == rn {
+			return i
+		}
+	}
+	for i, branch := range e.BranchRanges {
+		if branch.StartRn <= rn && rn <= branch.EndRn {
+			return len(e.Branches) + i
+		}
+	}
+	return -1
+}
+
+// branchFn returns the function of a branch numbered as by match.
+func (e *ExpectRune) branchFn(branch int) func(*Scanner) {
+	if branch < len(e.Branches) {
+		return e.Branches[branch].Fn
+	}
+	return e.BranchRanges[branch-len(e.Branches)].Fn
+}
+
+// match returns the rune or range matching the rune, runes numbered before
+// ranges, or -1 if there is none.
+func (e *ExpectNotRune) match(rn rune) int {
+	if e.matcher != nil {
+		return e.matcher.match(rn)
+	}
+	for i, expectedRune := range e.Runes {
+		if expectedRune == rn {
+			return i
+		}
+	}
+	for i, expectedRuneRange := range e.RuneRanges {
+		if expectedRuneRange.StartRn <= rn && rn <= expectedRuneRange.EndRn {
+			return len(e.Runes) + i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,75 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileOverlaps(t *testing.T) {
+	tests := []struct {
+		expect ExpectRune
+		err    string
+	}{
+		{ExpectRune{Branches: []Branch{{Rn: 'a'}, {Rn: 'b'}, {Rn: 'a'}}}, "dsl: rune a is matched by both [a] and [a]"},
+		{ExpectRune{Branches: []Branch{{Rn: 'e'}}, BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z'}}}, "dsl: rune e is matched by both [e] and range [a-z]"},
+		{ExpectRune{BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'm'}, {StartRn: 'k', EndRn: 'z'}}}, "dsl: rune k is matched by both range [a-m] and range [k-z]"},
+		{ExpectRune{BranchRanges: []BranchRange{{StartRn: 'z', EndRn: 'a'}}}, "dsl: range [z-a] matches no runes"},
+	}
+	for _, test := range tests {
+		_, err := test.expect.Compile()
+		if err == nil || err.Error() != test.err {
+			t.Errorf("expected error %q, found %v", test.err, err)
+		}
+	}
+
+	_, err := ExpectNotRune{Runes: []rune{'"'}, RuneRanges: []RuneRange{{StartRn: ' ', EndRn: '~'}}}.Compile()
+	if err == nil || !strings.Contains(err.Error(), "matched by both") {
+		t.Errorf("expected an overlap error, found %v", err)
+	}
+}
+
+func TestCompiledMatch(t *testing.T) {
+	expect := ExpectRune{
+		Branches: []Branch{{Rn: rune(0)}, {Rn: '_'}, {Rn: 'é'}, {Rn: '€'}},
+		BranchRanges: []BranchRange{
+			{StartRn: 'a', EndRn: 'z'},
+			{StartRn: '0', EndRn: '9'},
+			{StartRn: '~' + 1, EndRn: 'ç'}, // Crosses from ASCII into Latin-1
+			{StartRn: 'Ā', EndRn: 'ſ'},
+			{StartRn: '😀', EndRn: '😏'},
+		},
+	}
+	compiled, err := expect.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rn := rune(0); rn < 0x20000; rn++ {
+		if found, expected := compiled.match(rn), expect.match(rn); found != expected {
+			t.Fatalf("rune %U: expected branch %v, found %v", rn, expected, found)
+		}
+	}
+}
+
+func TestExpectCompiled(t *testing.T) {
+	word := ExpectRune{
+		BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z'}},
+		Options:      ExpectRuneOptions{Multiple: true},
+	}.MustCompile()
+	space := ExpectNotRune{
+		RuneRanges: []RuneRange{{StartRn: 'a', EndRn: 'z'}},
+		Options:    ExpectRuneOptions{Multiple: true, Optional: true, Skip: true},
+	}.MustCompile()
+	scanFn := func(s *Scanner) Token {
+		s.ExpectNot(space)
+		s.Expect(word)
+		s.Match([]Match{{ID: "WORD"}})
+		return s.Exit()
+	}
+
+	s := newStringScanner(scanFn, "  hello, world", &dslNoLogger{})
+	for _, expected := range []string{"hello", "world"} {
+		if tok, err := s.scan(); err != nil || tok.Literal != expected {
+			t.Errorf("expected %q, found %+v %v", expected, tok, err)
+		}
+	}
+}
@@ -14,22 +14,36 @@ import (
 
 // The Parser type holds a reference to the user parse func, the Scanner,
 // AST, Errors to return and other state variables.
+//
+// A Parser belongs to a single parse and is not safe for concurrent use.
+// Every part of its state is local to it, so any number of parses can run
+// at once as long as the user parse and scan functions keep their own state
+// with SetState rather than in package variables.
 type Parser struct {
-	fn   ParseFunc
-	s    scanner
-	ast  AST
-	l    logger
-	line string
-	buf  struct {
+	fn  ParseFunc
+	s   scanner
+	ast AST
+	l   logger
+	cov *Coverage
+	buf struct {
 		tokens []Token
 		num    int
 	} // Holds unread tokens so we don't have to make repeat calls to the Scanner
-	tokens     []Token // Holds all tokens consumed until they are moved to the AST
-	peekBuffer []Token // Holds all tokens peeked until they are consumed
-	errors     []Error
-	eof        bool
-	err        bool
-	loopCheck  struct {
+	tokens       []Token // Holds all tokens consumed until they are moved to the AST
+	peekBuffer   []Token // Holds all tokens peeked until they are consumed
+	errors       []Error
+	trivia       bool
+	scanned      []Token // Holds every token read from the scanner when trivia is attached to the tree
+	hidden       map[TokenType]bool
+	hiddenTokens []Token     // Holds every hidden token found, see HideTokens
+	comments     []TokenType // Token types to attach as comments once parsed
+	cst          *AST        // The concrete syntax tree being built, see WithCST
+	cstTokens    []Token     // Holds consumed tokens until they are added to the CST
+	eof          bool
+	err          bool
+	state        *parseState // User state shared with the scanner, see SetState
+	trace        bool        // Set when logging, so log messages are only built when written
+	loopCheck    struct {
 		count int
 		line  int
 		pos   int
@@ -47,8 +61,11 @@ type TokenType string
 type Token struct {
 	ID       TokenType
 	Literal  string
-	Line     int // Line is the line of the source text the Token was found.
-	Position int // Position is the position (or column) the Token was found.
+	Line     int         // Line is the line of the source text the Token was found.
+	Position int         // Position is the position (or column) the Token was found.
+	Leading  string      // Leading holds the runes skipped before the Token, see WithTrivia.
+	Trailing string      // Trailing holds the runes skipped after the Token, see WithTrivia.
+	Value    interface{} // Value holds the meaning of the Literal, see Scanner.Convert.
 }
 
 const (
@@ -60,10 +77,11 @@ const (
 // newParser returns an instance of a Parser
 func newParser(pf ParseFunc, s *Scanner, ast AST, l logger) *Parser {
 	return &Parser{
-		fn:  pf,
-		s:   s,
-		ast: ast,
-		l:   l,
+		fn:    pf,
+		s:     s,
+		ast:   ast,
+		l:     l,
+		trace: l.tracing(),
 	}
 }
 
@@ -110,18 +128,32 @@ type ParseOptions struct {
 //-----------------------------------------------------------------------------------
 
 func (p *Parser) Expect(expect ExpectToken) {
+	var site *CoverageSite
+	if p.cov != nil {
+		site = p.cov.site(coverParserExpect, branchTokenCoverage(expect.Branches))
+	}
+	p.expect(expect, site, nil)
+}
+
+// expect implements Expect. take is called with the index of each branch
+// matched in place of its Fn, when not nil, see the generic Expect.
+func (p *Parser) expect(expect ExpectToken, site *CoverageSite, take func(int)) {
 	var found1orMore bool
 	var tok Token
 	var err *Error
 
 	//If we have previously found an error but have not yet recovered with p.Recover, skip any call to p.Expect.
-	p.log(fmt.Sprintf("Expect Token %v: %v ", getParseOptions(expect.Options), branchTokensToStrings(expect.Branches)), prefixNewline)
+	if p.trace {
+		p.log(fmt.Sprintf("Expect Token %v: %v ", getParseOptions(expect.Options), branchTokensToStrings(expect.Branches)), prefixNewline)
+	}
 	if p.err {
 		p.log("Skipping Expect as error already found.", prefixNewline)
 		return
 	}
+	p.cov.call(site)
 	for {
 		var branchFn func(*Parser)
+		branch := -1
 		found := false
 
 		tok, err = p.scan()
@@ -131,10 +163,12 @@ func (p *Parser) Expect(expect ExpectToken) {
 		if tok.ID == TOKEN_EOF {
 			p.eof = true
 		}
-		for _, branch := range expect.Branches {
-			if tok.ID == branch.Id {
+		for i, b := range expect.Branches {
+			if tok.ID == b.Id {
 				found = true
-				branchFn = branch.Fn
+				branchFn = b.Fn
+				branch = i
+				p.cov.hit(site, i)
 				break
 			}
 		}
@@ -161,7 +195,11 @@ func (p *Parser) Expect(expect ExpectToken) {
 
 		p.logMatch(tok)
 		found1orMore = true // Set to true only after logging the first match
-		p.parseFn(branchFn)
+		if take != nil {
+			take(branch)
+		} else {
+			p.parseFn(branchFn)
+		}
 
 		if !expect.Options.Multiple || p.eof || p.err {
 			// If Multiple is false break out of the loop
@@ -185,11 +223,19 @@ func (p *Parser) ExpectNot(expect ExpectNotToken) {
 	var tok Token
 	var err *Error
 
-	p.log(fmt.Sprintf("Expect Not Token %v: %v ", getParseOptions(expect.Options), tokensToStrings(expect.Tokens)), prefixNewline)
+	var site *CoverageSite
+	if p.cov != nil {
+		site = p.cov.site(coverParserExpectNot, notTokenCoverage(expect.Tokens))
+	}
+
+	if p.trace {
+		p.log(fmt.Sprintf("Expect Not Token %v: %v ", getParseOptions(expect.Options), tokensToStrings(expect.Tokens)), prefixNewline)
+	}
 	if p.err {
 		p.log("Skipping Expect Not as error already found.", prefixNewline)
 		return
 	}
+	p.cov.call(site)
 
 	for {
 		found = false
@@ -201,9 +247,10 @@ func (p *Parser) ExpectNot(expect ExpectNotToken) {
 			p.eof = true
 		}
 
-		for _, token := range expect.Tokens {
+		for i, token := range expect.Tokens {
 			if tok.ID == token {
 				found = true
+				p.cov.hit(site, 1+i)
 				break
 			}
 		}
@@ -215,6 +262,7 @@ func (p *Parser) ExpectNot(expect ExpectNotToken) {
 		}
 
 		// Match not found, which is what we are expecting
+		p.cov.hit(site, 0)
 
 		if expect.Options.Peek {
 			// If we are peeking, remember each token read
@@ -249,18 +297,42 @@ func (p *Parser) ExpectNot(expect ExpectNotToken) {
 }
 
 func (p *Parser) parseFn(fn func(*Parser)) {
+	if p.enterFn(fn, fn != nil) {
+		fn(p)
+		p.leaveFn(fn)
+	}
+}
+
+// enterFn starts the call of the branch function fn, returning false if it
+// is not to be called, such as when it is nil (defined is false) or an
+// infinite loop is detected.
+func (p *Parser) enterFn(fn interface{}, defined bool) bool {
 	if ok, tok := p.checkForInfiniteLoop(); ok {
+		p.cstFlush()
 		p.newError(ErrorInfiniteLoopDetected, fmt.Errorf("infinite loop detected: %v", getFuncName(fn)), p.tokToErrLine(tok))
-		return
+		return false
 	}
-	if fn != nil && !p.eof {
+	if !defined || p.eof {
+		p.cstFlush()
+		return false
+	}
+	if p.trace {
 		p.log("Parsing: "+getFuncName(fn), prefixIncrement)
-		fn(p)
+	}
+	p.cstEnter(fn)
+	return true
+}
+
+// leaveFn ends the call of a branch function started by enterFn.
+func (p *Parser) leaveFn(fn interface{}) {
+	p.cstLeave()
+	if p.trace {
 		p.log("Returning: "+getFuncName(fn), prefixDecrement)
 	}
 }
 
 func (p *Parser) consume(tok Token, skip bool) {
+	p.cstConsume(tok)
 	if !skip {
 		p.tokens = append(p.tokens, tok)
 	}
@@ -272,19 +344,23 @@ func (p *Parser) logMatch(tok Token) {
 }
 
 func (p *Parser) AddNode(nt NodeType) {
-	p.log("AST Add Node: "+string(nt), prefixNewline)
+	if p.trace {
+		p.log("AST Add Node: "+string(nt), prefixNewline)
+	}
 	p.ast.addNode(nt)
 }
 
 func (p *Parser) AddTokens() {
 	p.log("AST Add Tokens: ", prefixNewline)
 	if len(p.tokens) > 0 {
-		for _, token := range p.tokens {
-			p.log(string(token.ID)+" - ", prefixNone)
-			for _, rn := range token.Literal {
-				p.log(sanitize(string(rn), false), prefixNone)
+		if p.trace {
+			for _, token := range p.tokens {
+				p.log(string(token.ID)+" - ", prefixNone)
+				for _, rn := range token.Literal {
+					p.log(sanitize(string(rn), false), prefixNone)
+				}
+				p.log(", ", prefixNone)
 			}
-			p.log(", ", prefixNone)
 		}
 		p.ast.addToken(p.tokens)
 		p.tokens = nil
@@ -298,8 +374,10 @@ func (p *Parser) SkipToken() {
 	if len(p.tokens) > 0 {
 		token := p.tokens[len(p.tokens)-1]
 		p.tokens = p.tokens[:len(p.tokens)-1]
-		p.log(string(token.ID)+" - ", prefixNone)
-		p.log(sanitize(token.Literal, true)+", ", prefixNone)
+		if p.trace {
+			p.log(string(token.ID)+" - ", prefixNone)
+			p.log(sanitize(token.Literal, true)+", ", prefixNone)
+		}
 	} else {
 		p.log("Warning: No Tokens to Skip", prefixError)
 	}
@@ -308,11 +386,13 @@ func (p *Parser) SkipToken() {
 func (p *Parser) GetToken() Token {
 	if len(p.tokens) == 0 {
 		p.log("Error: No tokens to get.", prefixError)
-		return Token{TOKEN_ERROR, "ERROR", 0, 0}
+		return Token{ID: TOKEN_ERROR, Literal: "ERROR"}
 	}
 	token := p.tokens[len(p.tokens)-1]
 	p.log("Get Last Token: ", prefixNewline)
-	p.log(sanitize(token.Literal, true), prefixNone)
+	if p.trace {
+		p.log(sanitize(token.Literal, true), prefixNone)
+	}
 	return token
 }
 
@@ -323,9 +403,15 @@ func (p *Parser) WalkUp() {
 
 func (p *Parser) Call(fn func(*Parser)) {
 	if fn != nil && !p.eof {
-		p.log("Calling: "+getFuncName(fn), prefixIncrement)
+		if p.trace {
+			p.log("Calling: "+getFuncName(fn), prefixIncrement)
+		}
+		p.cstEnter(fn)
 		fn(p)
-		p.log("Returning: "+getFuncName(fn), prefixDecrement)
+		p.cstLeave()
+		if p.trace {
+			p.log("Returning: "+getFuncName(fn), prefixDecrement)
+		}
 	}
 }
 
@@ -341,13 +427,25 @@ func (p *Parser) scan() (tok Token, err *Error) {
 		return
 	}
 
-	// Otherwise read the next token from the scanner.
-	tok, line, err := p.s.scan()
-	if err != nil {
-		p.err = true
-		p.errors = append(p.errors, *err)
+	// Otherwise read the next token from the scanner, skipping hidden tokens.
+	p.compact()
+	for {
+		tok, err = p.s.scan()
+		if err != nil {
+			p.err = true
+			p.errors = append(p.errors, *err)
+			p.ast.addError(*err)
+			if p.cst != nil {
+				p.cst.addError(*err)
+			}
+		}
+		if p.trivia {
+			p.scanned = append(p.scanned, tok)
+		}
+		if err != nil || !p.hide(tok) {
+			break
+		}
 	}
-	p.line = line
 
 	// Save it to the buffer in case we unscan later.
 	p.buf.tokens = append(p.buf.tokens, tok)
@@ -355,6 +453,25 @@ func (p *Parser) scan() (tok Token, err *Error) {
 	return
 }
 
+// compactSize is how many runes or tokens the Scanner and Parser let build
+// up in their buffers before dropping the ones that can no longer be read
+// again.
+const compactSize = 1024
+
+// compact drops the tokens that can no longer be unscanned from the token
+// buffer, before a new token is read. Only the tokens peeked by the current
+// Expect and the token that ended the peek are ever unscanned, so the rest
+// are dropped once enough of them have built up.
+func (p *Parser) compact() {
+	keep := len(p.peekBuffer) + 1
+	if len(p.buf.tokens)-keep < compactSize {
+		return
+	}
+	n := copy(p.buf.tokens, p.buf.tokens[len(p.buf.tokens)-keep:])
+	clear(p.buf.tokens[n:]) // Let the literals of the dropped tokens be freed
+	p.buf.tokens = p.buf.tokens[:n]
+}
+
 const MaxConsecutivePeeks = 10
 
 func (p *Parser) checkForInfiniteLoop() (bool, Token) {
@@ -396,6 +513,7 @@ func (p *Parser) consumePeeked() {
 		p.consume(tok, false)
 	}
 	p.peekBuffer = nil
+	p.cstFlush() // Peeked tokens belong to the rule that peeked them
 }
 
 type errorLine struct {
@@ -407,22 +525,38 @@ type errorLine struct {
 }
 
 func (p *Parser) tokToErrLine(tok Token) errorLine {
-	endPos := tok.Position
-	if tok.ID == TOKEN_EOF {
-		endPos++
-	}
+	endLine, endPos := literalEnd(tok.Line, tok.Position, tok.Literal)
 	return errorLine{
-		line:      p.line,
+		line:      p.s.line(),
 		startLine: tok.Line,
 		startPos:  tok.Position,
-		endLine:   tok.Line,
-		endPos:    endPos + len(tok.Literal) - 1,
+		endLine:   endLine,
+		endPos:    endPos,
+	}
+}
+
+// literalEnd returns the line and position of the last rune of a literal
+// found at line:pos. The literal is walked rune by rune so multi-line and
+// multi-byte literals end where the scanner actually stopped.
+func literalEnd(line, pos int, literal string) (endLine, endPos int) {
+	endLine, endPos = line, pos
+	var prev rune
+	for i, rn := range []rune(literal) {
+		switch {
+		case i == 0:
+		case prev == '\n':
+			endLine++
+			endPos = 1
+		default:
+			endPos++
+		}
+		prev = rn
 	}
+	return endLine, endPos
 }
 
 func (p *Parser) newError(code ErrorCode, errMsg error, el errorLine) {
-	p.err = true
-	p.errors = append(p.errors, Error{
+	err := Error{
 		Code:          code,
 		Message:       errMsg.Error(),
 		LineString:    el.line,
@@ -430,7 +564,13 @@ func (p *Parser) newError(code ErrorCode, errMsg error, el errorLine) {
 		StartPosition: el.startPos,
 		EndLine:       el.endLine,
 		EndPosition:   el.endPos,
-	})
+	}
+	p.err = true
+	p.errors = append(p.errors, err)
+	p.ast.addError(err)
+	if p.cst != nil {
+		p.cst.addError(err)
+	}
 	p.log(errMsg.Error(), prefixError)
 
 }
@@ -441,10 +581,16 @@ func (p *Parser) Recover(fn func(*Parser)) {
 	}
 
 	if fn != nil && !p.eof {
-		p.log("Recovering: "+getFuncName(fn), prefixIncrement)
+		if p.trace {
+			p.log("Recovering: "+getFuncName(fn), prefixIncrement)
+		}
 		p.err = false
+		p.cstEnter(fn)
 		fn(p)
-		p.log("Returning: "+getFuncName(fn), prefixDecrement)
+		p.cstLeave()
+		if p.trace {
+			p.log("Returning: "+getFuncName(fn), prefixDecrement)
+		}
 	}
 }
 
@@ -1,6 +1,8 @@
 package dsl
 
 import (
+	"bufio"
+	"strings"
 	"testing"
 	"time"
 )
@@ -9,15 +11,22 @@ import (
 type mockScanner struct {
 	tokens []Token
 	index  int
+	last   string
 }
 
-func (m *mockScanner) scan() (Token, string, *Error) {
+func (m *mockScanner) scan() (Token, *Error) {
 	if m.index >= len(m.tokens) {
-		return Token{ID: TOKEN_EOF, Literal: "EOF", Line: 0, Position: 0}, "", nil
+		m.last = ""
+		return Token{ID: TOKEN_EOF, Literal: "EOF", Line: 0, Position: 0}, nil
 	}
 	token := m.tokens[m.index]
 	m.index++
-	return token, tokensToLineString(m.tokens[0:m.index]), nil
+	m.last = tokensToLineString(m.tokens[0:m.index])
+	return token, nil
+}
+
+func (m *mockScanner) line() string {
+	return m.last
 }
 
 // mockLogger simulates the Logger for testing purposes
@@ -25,6 +34,8 @@ type mockLogger struct{}
 
 func (m *mockLogger) log(msg string, indent indent) {}
 
+func (m *mockLogger) tracing() bool { return false }
+
 // TestExpect tests the Expect method of the Parser
 func TestExpect(t *testing.T) {
 
@@ -337,3 +348,90 @@ func TestParserInfiniteLoopDetection(t *testing.T) {
 	}
 
 }
+
+func TestTokToErrLine(t *testing.T) {
+	p := &Parser{s: &mockScanner{}}
+	tests := []struct {
+		tok                                  Token
+		startLine, startPos, endLine, endPos int
+	}{
+		{Token{ID: "WORD", Literal: "abc", Line: 1, Position: 1}, 1, 1, 1, 3},
+		// Multi-line literals end on their last line
+		{Token{ID: "STRING", Literal: "ab\ncd", Line: 2, Position: 3}, 2, 3, 3, 2},
+		{Token{ID: "STRING", Literal: "ab\n", Line: 2, Position: 3}, 2, 3, 2, 5},
+		// Multi-byte runes take one position each
+		{Token{ID: "STRING", Literal: "ü✓", Line: 1, Position: 5}, 1, 5, 1, 6},
+		{Token{ID: TOKEN_EOF, Literal: "", Line: 3, Position: 4}, 3, 4, 3, 4},
+	}
+	for _, tt := range tests {
+		e := p.tokToErrLine(tt.tok)
+		if e.startLine != tt.startLine || e.startPos != tt.startPos || e.endLine != tt.endLine || e.endPos != tt.endPos {
+			t.Errorf("%q: expected %d:%d-%d:%d, found %d:%d-%d:%d", tt.tok.Literal, tt.startLine, tt.startPos, tt.endLine, tt.endPos, e.startLine, e.startPos, e.endLine, e.endPos)
+		}
+	}
+}
+
+// nestedParse adds a statement node holding a node of words for each
+// statement, so appending a statement moves the statements before it.
+func nestedParse(p *Parser) (AST, []Error) {
+	p.Expect(ExpectToken{
+		Branches: []BranchToken{{Id: "WORD", Fn: func(p *Parser) {
+			p.AddNode("STATEMENT")
+			p.AddNode("WORDS")
+			p.AddTokens()
+			p.Expect(ExpectToken{
+				Branches: []BranchToken{{Id: "WORD", Fn: func(p *Parser) { p.AddTokens() }}},
+				Options:  ParseOptions{Multiple: true, Optional: true},
+			})
+			p.WalkUp()
+			p.WalkUp()
+			p.Expect(ExpectToken{Branches: []BranchToken{{Id: "SEMI"}, {Id: TOKEN_EOF}}, Options: ParseOptions{Skip: true}})
+		}}},
+		Options: ParseOptions{Multiple: true},
+	})
+	return p.Exit()
+}
+
+func TestParseParents(t *testing.T) {
+	ast, errs := Parse(nestedParse, wordScan, bufio.NewReader(strings.NewReader("a b; c; d e f; g")))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(ast.RootNode.Children) != 4 {
+		t.Fatalf("expected 4 statements, found %d", len(ast.RootNode.Children))
+	}
+	var check func(n *Node)
+	check = func(n *Node) {
+		for i := range n.Children {
+			if n.Children[i].Parent != n {
+				t.Errorf("%v child %d of %v has a stale Parent reference", n.Children[i].Type, i, n.Type)
+			}
+			check(&n.Children[i])
+		}
+	}
+	check(ast.RootNode)
+}
+
+func TestParseBoundedBuffer(t *testing.T) {
+	input := strings.Repeat("say \"hello\" world; ", 1000)
+	p := setup(cstParse, triviaScan, bufio.NewReader(strings.NewReader(input)), newParseConfig(nil))
+	var most int
+	p.s = &watchScanner{scanner: p.s, watch: func() { most = max(most, len(p.buf.tokens)) }}
+	if _, errs := execute(p); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if most > compactSize+1 {
+		t.Errorf("token buffer grew to %d tokens", most)
+	}
+}
+
+// watchScanner calls watch before every token is scanned.
+type watchScanner struct {
+	scanner
+	watch func()
+}
+
+func (w *watchScanner) scan() (Token, *Error) {
+	w.watch()
+	return w.scanner.scan()
+}
@@ -0,0 +1,263 @@
+// pipeline.go implements pipelined parsing, see WithPipeline. The parser
+// and scanner normally take turns on one goroutine, the parser asking for a
+// token whenever it needs one. A pipeline runs the scanner on a goroutine of
+// its own instead, scanning ahead of the parser into a bounded buffer, so
+// reading and scanning the input overlaps with parsing it. Tokens are handed
+// over in batches to keep the cost of passing them between goroutines down.
+// A batch is sent once full, or early if the scanner may have to wait for
+// more input, so the parser never waits on tokens already scanned. The
+// parser hands each batch back once it has read it, for the scanner to fill
+// again, so a parse allocates only as many batches as can be in flight.
+//
+// Scanning ahead is only safe as long as the scanner would scan the same
+// tokens in lockstep. The parse function can change how the scanner reads
+// its input through the user state, and the scanner is needed to report the
+// line of an error, so the parser pauses the pipeline whenever it reads or
+// sets the state or reports an error. Pausing stops the scanner goroutine
+// and rewinds the scanner to just after the last token the parser took,
+// dropping the tokens scanned ahead, which are scanned again once the parser
+// asks for the next token. Tokens held by the parser itself, such as peeked
+// or unscanned tokens, are not affected.
+
+package dsl
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// WithPipeline returns a ParseOption that runs the scanner on its own
+// goroutine, scanning about size tokens ahead of the parser. It is used by
+// Parse, ParseString, ParseBytes and ParseFiles.
+//
+// Once ctx is cancelled the parser reads TOKEN_EOF in place of the rest of
+// the input, along with an error with the code ErrorParseCancelled, so the
+// parse function returns soon after. A scan already running is waited for.
+//
+// The parse function should read and set the user state as rarely as it can,
+// as each time drops the tokens scanned ahead. Coverage counts the scans of
+// dropped tokens. With a log writer the parse runs in lockstep, so that the
+// log reads in order.
+//
+// A pipeline gains nothing on input already in memory, or with a single CPU,
+// where it is slower than the default lockstep parse as handing the tokens
+// over costs more than it saves. It only pays off when reading the input can
+// block, such as on a network stream, with a CPU free to scan meanwhile.
+func WithPipeline(ctx context.Context, size int) ParseOption {
+	return func(c *ParseConfig) {
+		c.Pipeline = size
+		c.Context = ctx
+	}
+}
+
+// pipelined has the scanner of the parser run ahead of it when asked for
+// with WithPipeline.
+func pipelined(p *Parser, config *ParseConfig) *Parser {
+	if config.Pipeline <= 0 || p.trace {
+		return p
+	}
+	if s, ok := p.s.(*Scanner); ok {
+		p.s = newPipeline(s, config.Context, config.Pipeline)
+	}
+	return p
+}
+
+// pipeline stands in for the scanner of a parser, handing it the tokens
+// scanned ahead by a scanner goroutine.
+type pipeline struct {
+	s         *Scanner
+	ctx       context.Context
+	size      int
+	tokens    chan []scanned // Batches of tokens scanned ahead, nil while the pipeline is paused
+	batch     []scanned      // The rest of the batch being handed to the parser
+	held      []scanned      // The whole of the batch being handed to the parser
+	free      chan []scanned // Batches read by the parser, for the scanner to reuse
+	quit      chan struct{}  // Closed to stop the scanner goroutine
+	last      scanPoint      // Where the scanner was after the last token handed to the parser
+	pin       atomic.Int64   // The offset of the earliest rune the scanner must keep to rewind to last
+	ended     bool           // The parser has read TOKEN_EOF, so there is nothing left to scan ahead
+	cancelled bool
+}
+
+// scanned is a token scanned ahead and where the scanner was after it.
+type scanned struct {
+	tok   Token
+	err   *Error
+	point scanPoint
+}
+
+func newPipeline(s *Scanner, ctx context.Context, size int) *pipeline {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	pl := &pipeline{s: s, ctx: ctx, size: size, last: s.point()}
+	// Up to one batch per slot of the channel can be in flight, along with
+	// the one being filled and the one being read
+	pl.free = make(chan []scanned, pipelineBatches(size)+2)
+	pl.pin.Store(int64(pl.last.lineStart))
+	s.pin = &pl.pin
+	return pl
+}
+
+// scan hands the parser the next token scanned ahead, starting the scanner
+// goroutine if it is paused.
+func (pl *pipeline) scan() (Token, *Error) {
+	if pl.cancelled {
+		return Token{ID: TOKEN_EOF, Line: pl.last.line, Position: pl.last.pos}, nil
+	}
+	if pl.ended {
+		// The scanner is at the end of the input and scans in lockstep
+		tok, err := pl.s.scan()
+		pl.last = pl.s.point()
+		pl.pin.Store(int64(pl.last.lineStart))
+		return tok, err
+	}
+	select {
+	case <-pl.ctx.Done():
+		return pl.cancel()
+	default:
+	}
+	if pl.tokens == nil {
+		pl.start()
+	}
+	if len(pl.batch) == 0 {
+		pl.recycle(pl.held)
+		select {
+		case pl.batch = <-pl.tokens:
+			pl.held = pl.batch
+		case <-pl.ctx.Done():
+			pl.held = nil
+			return pl.cancel()
+		}
+	}
+
+	item := pl.batch[0]
+	pl.batch = pl.batch[1:]
+	pl.last = item.point
+	pl.pin.Store(int64(item.point.lineStart))
+	if item.tok.ID == TOKEN_EOF {
+		pl.pause()
+		pl.ended = true
+	}
+	return item.tok, item.err
+}
+
+// cancel pauses the pipeline for good, returning the end of the input and
+// the error reporting that the parse was cancelled.
+func (pl *pipeline) cancel() (Token, *Error) {
+	pl.pause()
+	pl.cancelled = true
+	return Token{ID: TOKEN_EOF, Line: pl.last.line, Position: pl.last.pos}, &Error{
+		Code:          ErrorParseCancelled,
+		Message:       "parse cancelled: " + pl.ctx.Err().Error(),
+		LineString:    pl.s.line(),
+		StartLine:     pl.last.line,
+		StartPosition: pl.last.pos,
+		EndLine:       pl.last.line,
+		EndPosition:   pl.last.pos,
+	}
+}
+
+// line pauses the pipeline, so the line is read from where the parser is
+// rather than where the scanner got to.
+func (pl *pipeline) line() string {
+	pl.pause()
+	return pl.s.line()
+}
+
+// start runs the scanner on a new goroutine until it scans TOKEN_EOF or the
+// pipeline is paused.
+func (pl *pipeline) start() {
+	size := min(pl.size, pipelineBatch)
+	tokens := make(chan []scanned, pipelineBatches(pl.size))
+	quit := make(chan struct{})
+	pl.tokens, pl.quit = tokens, quit
+	go func() {
+		defer close(tokens)
+		batch := pl.reuse(size)
+		for {
+			tok, err := pl.s.scan()
+			batch = append(batch, scanned{tok, err, pl.s.point()})
+			eof := tok.ID == TOKEN_EOF
+			if len(batch) == size || eof || pl.s.mayWait() {
+				select {
+				case tokens <- batch:
+				case <-quit:
+					return
+				}
+				batch = pl.reuse(size)
+			}
+			if eof {
+				return
+			}
+		}
+	}()
+}
+
+// pipelineBatch is the most tokens handed from the scanner goroutine to the
+// parser at once.
+const pipelineBatch = 64
+
+// pipelineBatches returns how many batches are sent ahead of the parser to
+// scan about size tokens ahead.
+func pipelineBatches(size int) int {
+	return max(size/min(size, pipelineBatch), 1)
+}
+
+// reuse returns an empty batch, one read by the parser if there is one.
+func (pl *pipeline) reuse(size int) []scanned {
+	select {
+	case batch := <-pl.free:
+		return batch
+	default:
+		return make([]scanned, 0, size)
+	}
+}
+
+// recycle hands a batch the parser has read back to the scanner.
+func (pl *pipeline) recycle(batch []scanned) {
+	if cap(batch) == 0 {
+		return
+	}
+	select {
+	case pl.free <- batch[:0]:
+	default:
+	}
+}
+
+// pause stops the scanner goroutine, waiting for it to return, and rewinds
+// the scanner to where the parser is.
+func (pl *pipeline) pause() {
+	if pl.tokens == nil {
+		return
+	}
+	close(pl.quit)
+	for batch := range pl.tokens {
+		pl.recycle(batch)
+	}
+	pl.recycle(pl.held)
+	pl.tokens = nil
+	pl.batch, pl.held = nil, nil
+	pl.s.rewind(pl.last)
+}
+
+// pause stops a pipelined scanner, so that the parser can read or change
+// what the scanner reads.
+func (p *Parser) pause() {
+	if pl, ok := p.s.(*pipeline); ok {
+		pl.pause()
+	}
+}
+
+// scanner returns the Scanner the parser reads from, paused if pipelined,
+// or nil if it reads from another scanner.
+func (p *Parser) scanner() *Scanner {
+	switch s := p.s.(type) {
+	case *Scanner:
+		return s
+	case *pipeline:
+		s.pause()
+		return s.s
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package dsl
+
+import (
+	"bufio"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	inputs := []string{
+		"",
+		"say \"hi\" ; bye;",
+		strings.Repeat("say \"hi there\" ;\n  bye ; ", 2000),
+		strings.Repeat("word ", 3000) + "\"unterminated",
+		"one ; ? two ; three",
+	}
+	for _, input := range inputs {
+		var cst, pipelinedCST AST
+		ast, errs := Parse(cstParse, triviaScan, bufio.NewReader(strings.NewReader(input)), WithTrivia(), WithCST(&cst))
+		for _, size := range []int{1, 16} {
+			found, foundErrs := Parse(cstParse, triviaScan, bufio.NewReader(strings.NewReader(input)),
+				WithTrivia(), WithCST(&pipelinedCST), WithPipeline(context.Background(), size))
+			if !reflect.DeepEqual(found, ast) || !reflect.DeepEqual(foundErrs, errs) || !reflect.DeepEqual(pipelinedCST, cst) {
+				t.Errorf("%.20q size %v: pipelined parse differs from lockstep", input, size)
+			}
+			found, foundErrs = ParseString(cstParse, triviaScan, input, WithTrivia(), WithPipeline(context.Background(), size))
+			if !reflect.DeepEqual(found, ast) || !reflect.DeepEqual(foundErrs, errs) {
+				t.Errorf("%.20q size %v: pipelined ParseString differs from lockstep", input, size)
+			}
+		}
+	}
+}
+
+func TestPipelineReusesBatches(t *testing.T) {
+	input := strings.Repeat("say \"hi there\" ;\n  bye ; ", 2000)
+	parse := func(opts ...ParseOption) func() {
+		return func() { ParseString(cstParse, triviaScan, input, opts...) }
+	}
+	lockstep := testing.AllocsPerRun(5, parse())
+	pipelined := testing.AllocsPerRun(5, parse(WithPipeline(context.Background(), 256)))
+	// 8000 tokens take 125 batches, of which only those in flight are made
+	if pipelined > lockstep+20 {
+		t.Errorf("expected the pipeline to reuse its batches, found %v allocations against %v in lockstep", pipelined, lockstep)
+	}
+}
+
+// modeScan scans words as WORD, or as UPPER once the parser has seen the
+// word "shout", and "hush" as HUSH.
+func modeScan(s *Scanner) Token {
+	s.Expect(ExpectRune{
+		Branches: []Branch{{Rn: ' '}, {Rn: '\n'}},
+		Options:  ExpectRuneOptions{Optional: true, Multiple: true, Skip: true},
+	})
+	s.Expect(ExpectRune{
+		Branches: []Branch{{Rn: rune(0), Fn: func(s *Scanner) { s.Match([]Match{{ID: TOKEN_EOF}}) }}},
+		BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z', Fn: func(s *Scanner) {
+			s.Expect(ExpectRune{BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z'}}, Options: ExpectRuneOptions{Optional: true, Multiple: true}})
+			id := TokenType("WORD")
+			if s.State() == true {
+				id = "UPPER"
+			}
+			s.Match([]Match{{Literal: "shout", ID: "SHOUT"}, {Literal: "hush", ID: "HUSH"}, {ID: id}})
+		}}},
+	})
+	return s.Exit()
+}
+
+func modeParse(p *Parser) (AST, []Error) {
+	p.Expect(ExpectToken{
+		Branches: []BranchToken{
+			{Id: "WORD", Fn: func(p *Parser) { p.AddTokens() }},
+			{Id: "UPPER", Fn: func(p *Parser) { p.AddTokens() }},
+			{Id: "SHOUT", Fn: func(p *Parser) { p.SkipToken(); p.SetState(true) }},
+			{Id: "HUSH", Fn: func(p *Parser) { p.SkipToken(); p.SetState(false) }},
+		},
+		Options: ParseOptions{Multiple: true},
+	})
+	return p.Exit()
+}
+
+func TestPipelineState(t *testing.T) {
+	input := strings.Repeat("a b shout c d e hush f\n", 100)
+	ast, errs := ParseString(modeParse, modeScan, input)
+	found, foundErrs := ParseString(modeParse, modeScan, input, WithPipeline(context.Background(), 8))
+	if !reflect.DeepEqual(found, ast) || !reflect.DeepEqual(foundErrs, errs) {
+		t.Fatalf("pipelined parse differs from lockstep")
+	}
+
+	var upper int
+	for _, tok := range found.RootNode.Tokens {
+		if tok.ID == "UPPER" {
+			upper++
+		}
+	}
+	if upper != 300 {
+		t.Errorf("expected 300 words scanned after shout, found %v", upper)
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, errs := Parse(cstParse, triviaScan, bufio.NewReader(strings.NewReader("say hi; bye;")), WithPipeline(ctx, 4))
+	if len(errs) == 0 || errs[0].Code != ErrorParseCancelled {
+		t.Fatalf("expected the parse to be cancelled, found %v", errs)
+	}
+}
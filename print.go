@@ -0,0 +1,273 @@
+// print.go implements a configurable printer that writes an AST, or any
+// branch of it, as a tree to an io.Writer. For example:
+//
+//	ROOT
+//	└── ASSIGNMENT "a"
+//	    ├── TERMINAL "1"
+//	    └── EXPRESSION "*"
+//	        └── TERMINAL "5"
+//
+// Token literals are quoted so the output of every node is a single line.
+// Without the color option the output contains no trailing whitespace or
+// escape codes, and the same tree always prints the same way, so it can be
+// used in golden tests.
+package dsl
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+// PrintOption is a function type that modifies PrintConfig
+type PrintOption func(*PrintConfig)
+
+// PrintConfig holds the configuration for printing
+type PrintConfig struct {
+	ASCII      bool                // Draw the tree with ASCII rather than Unicode box characters
+	TokenIDs   bool                // Print the TokenType of each token
+	Positions  bool                // Print the line:position of each token
+	MaxLiteral int                 // Truncate literals longer than MaxLiteral runes when > 0
+	MaxDepth   int                 // Only print nodes up to MaxDepth below the first node when > 0
+	Color      bool                // Color each NodeType with ANSI escape codes
+	Colors     map[NodeType]string // ANSI SGR parameters for each NodeType, such as "1;34"
+	legacy     *legacyFormat       // Print in the format of Print when set
+}
+
+// legacyFormat is where Print starts the branch it prints, see Node.Print.
+type legacyFormat struct {
+	prefix  string
+	isTail  bool
+	newline bool // End the output with a newline, as AST.Print does
+}
+
+// withLegacyFormat returns a PrintOption that prints in the format of Print.
+func withLegacyFormat(prefix string, isTail, newline bool) PrintOption {
+	return func(c *PrintConfig) {
+		c.legacy = &legacyFormat{prefix: prefix, isTail: isTail, newline: newline}
+	}
+}
+
+// WithASCII returns a PrintOption that draws the tree using ASCII characters.
+func WithASCII() PrintOption {
+	return func(c *PrintConfig) {
+		c.ASCII = true
+	}
+}
+
+// WithTokenIDs returns a PrintOption that prints the TokenType before each
+// token literal.
+func WithTokenIDs() PrintOption {
+	return func(c *PrintConfig) {
+		c.TokenIDs = true
+	}
+}
+
+// WithPositions returns a PrintOption that prints the line and position of
+// each token after its literal.
+func WithPositions() PrintOption {
+	return func(c *PrintConfig) {
+		c.Positions = true
+	}
+}
+
+// WithMaxLiteral returns a PrintOption that truncates literals longer than n
+// runes, marking the truncation with an ellipsis.
+func WithMaxLiteral(n int) PrintOption {
+	return func(c *PrintConfig) {
+		c.MaxLiteral = n
+	}
+}
+
+// WithMaxDepth returns a PrintOption that only prints nodes up to depth levels
+// below the first node. Children of the deepest nodes are summarised as a count.
+func WithMaxDepth(depth int) PrintOption {
+	return func(c *PrintConfig) {
+		c.MaxDepth = depth
+	}
+}
+
+// WithColor returns a PrintOption that colors each NodeType using ANSI escape
+// codes. Node types missing from colors, or all types if colors is nil, are
+// given a color from a fixed palette based on a hash of the type name, so a
+// type is always printed in the same color.
+func WithColor(colors map[NodeType]string) PrintOption {
+	return func(c *PrintConfig) {
+		c.Color = true
+		c.Colors = colors
+	}
+}
+
+// Print writes the entire AST to os.Stdout in the format it has always
+// used, each node on a new line as its type followed by " - " and its token
+// literals each followed by ", ". The output starts and ends with a newline.
+// Use Fprint for the configurable format described above, or to be told of
+// errors writing the output.
+func (a *AST) Print() {
+	a.Fprint(os.Stdout, withLegacyFormat("", true, true))
+}
+
+// Fprint writes the entire AST to w.
+func (a *AST) Fprint(w io.Writer, opts ...PrintOption) error {
+	return a.RootNode.Fprint(w, opts...)
+}
+
+// Print writes the node and its descendants to os.Stdout as a branch of a
+// larger tree, in the format of AST.Print. The prefix is printed at the
+// start of each line and isTail indicates whether the node is the last
+// child of its parent.
+//
+// A user can print the entire tree using AST.Print() or only print a sub-branch
+// by calling Print() or Fprint() on any node in the tree.
+func (n *Node) Print(prefix string, isTail bool) {
+	n.Fprint(os.Stdout, withLegacyFormat(prefix, isTail, false))
+}
+
+// printLegacy writes the branch in the format of Print.
+func (n *Node) printLegacy(buf *bytes.Buffer, prefix string, isTail bool) {
+	buf.WriteString("\n" + prefix)
+	if isTail {
+		buf.WriteString("└── ")
+		prefix += "    "
+	} else {
+		buf.WriteString("├── ")
+		prefix += "│   "
+	}
+	buf.WriteString(string(n.Type) + " - ")
+	for _, token := range n.Tokens {
+		buf.WriteString(token.Literal + ", ")
+	}
+	for i := range n.Children {
+		n.Children[i].printLegacy(buf, prefix, i == len(n.Children)-1)
+	}
+}
+
+// Fprint writes the node and its descendants to w, starting with the node
+// itself without a branch prefix.
+func (n *Node) Fprint(w io.Writer, opts ...PrintOption) error {
+	p := newPrinter(opts)
+	if legacy := p.config.legacy; legacy != nil {
+		n.printLegacy(&p.buf, legacy.prefix, legacy.isTail)
+		if legacy.newline {
+			p.buf.WriteByte('\n')
+		}
+	} else {
+		p.line(n)
+		p.children(n, "", 0)
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// ---------------------------------------------------------------------------------------------------------
+
+type printer struct {
+	config PrintConfig
+	buf    bytes.Buffer
+	glyphs struct {
+		fork, last, pipe, space string
+	}
+}
+
+var (
+	unicodeGlyphs = [4]string{"├── ", "└── ", "│   ", "    "}
+	asciiGlyphs   = [4]string{"|-- ", "`-- ", "|   ", "    "}
+)
+
+// colorPalette is used for node types that are not given a color.
+var colorPalette = []string{"31", "32", "33", "34", "35", "36", "1;31", "1;32", "1;33", "1;34", "1;35", "1;36"}
+
+func newPrinter(opts []PrintOption) *printer {
+	p := &printer{}
+	for _, opt := range opts {
+		opt(&p.config)
+	}
+	glyphs := unicodeGlyphs
+	if p.config.ASCII {
+		glyphs = asciiGlyphs
+	}
+	p.glyphs.fork, p.glyphs.last, p.glyphs.pipe, p.glyphs.space = glyphs[0], glyphs[1], glyphs[2], glyphs[3]
+	return p
+}
+
+// printBranch prints a node with its connector followed by its children.
+func (p *printer) printBranch(n *Node, prefix string, isTail bool, depth int) {
+	p.buf.WriteString(prefix)
+	if isTail {
+		p.buf.WriteString(p.glyphs.last)
+		prefix += p.glyphs.space
+	} else {
+		p.buf.WriteString(p.glyphs.fork)
+		prefix += p.glyphs.pipe
+	}
+	p.line(n)
+	p.children(n, prefix, depth)
+}
+
+func (p *printer) children(n *Node, prefix string, depth int) {
+	numNodes := len(n.Children)
+	if numNodes == 0 {
+		return
+	}
+	if p.config.MaxDepth > 0 && depth >= p.config.MaxDepth {
+		p.buf.WriteString(prefix + p.glyphs.last)
+		fmt.Fprintf(&p.buf, "... %d more\n", countNodes(n)-1)
+		return
+	}
+	for i := range n.Children {
+		p.printBranch(&n.Children[i], prefix, i == numNodes-1, depth+1)
+	}
+}
+
+// line prints the node type and its tokens followed by a newline.
+func (p *printer) line(n *Node) {
+	if p.config.Color {
+		p.buf.WriteString("\x1b[" + p.color(n.Type) + "m")
+		p.buf.WriteString(string(n.Type))
+		p.buf.WriteString("\x1b[0m")
+	} else {
+		p.buf.WriteString(string(n.Type))
+	}
+	for _, tok := range n.Tokens {
+		p.buf.WriteByte(' ')
+		if p.config.TokenIDs {
+			p.buf.WriteString(string(tok.ID))
+			p.buf.WriteByte(':')
+		}
+		p.buf.WriteString(strconv.Quote(p.truncate(tok.Literal)))
+		if p.config.Positions {
+			fmt.Fprintf(&p.buf, "@%d:%d", tok.Line, tok.Position)
+		}
+	}
+	p.buf.WriteByte('\n')
+}
+
+func (p *printer) truncate(literal string) string {
+	if p.config.MaxLiteral <= 0 || utf8.RuneCountInString(literal) <= p.config.MaxLiteral {
+		return literal
+	}
+	runes := []rune(literal)
+	return string(runes[:p.config.MaxLiteral]) + "…"
+}
+
+func (p *printer) color(nt NodeType) string {
+	if code, ok := p.config.Colors[nt]; ok {
+		return code
+	}
+	h := fnv.New32a()
+	h.Write([]byte(nt))
+	return colorPalette[h.Sum32()%uint32(len(colorPalette))]
+}
+
+// countNodes returns the number of nodes in the branch including n.
+func countNodes(n *Node) int {
+	count := 1
+	for i := range n.Children {
+		count += countNodes(&n.Children[i])
+	}
+	return count
+}
@@ -0,0 +1,95 @@
+package dsl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []PrintOption
+		expected string
+	}{
+		{
+			name: "Default",
+			expected: `ROOT
+├── ASSIGNMENT "a"
+│   ├── TERMINAL "1"
+│   └── EXPRESSION "*"
+│       └── TERMINAL "say \"hi\"\n"
+├── TYPE WITH (SPACES)
+└── :error
+`,
+		},
+		{
+			name: "ASCII with IDs and Positions",
+			opts: []PrintOption{WithASCII(), WithTokenIDs(), WithPositions(), WithMaxLiteral(3)},
+			expected: `ROOT
+|-- ASSIGNMENT VARIABLE:"a"@1:1
+|   |-- TERMINAL LITERAL:"1"@1:6
+|   ` + "`" + `-- EXPRESSION MULTIPLY:"*"@1:8
+|       ` + "`" + `-- TERMINAL STRING:"say…"@1:10
+|-- TYPE WITH (SPACES)
+` + "`" + `-- :error
+`,
+		},
+		{
+			name: "MaxDepth",
+			opts: []PrintOption{WithMaxDepth(1)},
+			expected: `ROOT
+├── ASSIGNMENT "a"
+│   └── ... 3 more
+├── TYPE WITH (SPACES)
+└── :error
+`,
+		},
+		{
+			name: "Color",
+			opts: []PrintOption{WithMaxDepth(1), WithColor(map[NodeType]string{
+				NODE_ROOT: "1", "ASSIGNMENT": "32", "TYPE WITH (SPACES)": "33", ":error": "31",
+			})},
+			expected: "\x1b[1mROOT\x1b[0m\n" +
+				"├── \x1b[32mASSIGNMENT\x1b[0m \"a\"\n" +
+				"│   └── ... 3 more\n" +
+				"├── \x1b[33mTYPE WITH (SPACES)\x1b[0m\n" +
+				"└── \x1b[31m:error\x1b[0m\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAST()
+			var buf bytes.Buffer
+			if err := a.Fprint(&buf, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			if actual := buf.String(); actual != tt.expected {
+				t.Errorf("Unexpected output:\n%s\nexpected:\n%s", actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrintLegacy(t *testing.T) {
+	a := newTestAST()
+
+	// Print keeps the signatures it has always had
+	var _ func() = a.Print
+	var _ func(string, bool) = a.RootNode.Print
+
+	var buf bytes.Buffer
+	if err := a.Fprint(&buf, withLegacyFormat("", true, true)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\n└── ROOT - " +
+		"\n    ├── ASSIGNMENT - a, " +
+		"\n    │   ├── TERMINAL - 1, " +
+		"\n    │   └── EXPRESSION - *, " +
+		"\n    │       └── TERMINAL - say \"hi\"\n, " +
+		"\n    ├── TYPE WITH (SPACES) - " +
+		"\n    └── :error - \n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\nexpected %q\nfound    %q", expected, buf.String())
+	}
+}
@@ -2,23 +2,46 @@
 // When a Scanner is created it takes a bufio.Reader as the source of
 // the text, scans a number of characters (runes) as defined by the
 // user ScanFunc and returns a token to the Parser.
+//
+// Input already in memory, see ParseString, is read in place instead.
+// Runes are decoded straight from the string and the literal of a token
+// whose runes are next to each other in the input is a substring of it,
+// so scanning such input allocates nothing per token.
 package dsl
 
 import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
 // The Scanner contains a reference to the user scan function, the
 // user input buffer, various state variables and the parser log.
+//
+// A Scanner belongs to a single parse and is not safe for concurrent use.
+// Scan functions that need state, such as a mode switched on by the parse
+// function, should keep it with State rather than in package variables, so
+// that parses can run in parallel.
 type Scanner struct {
 	fn  ScanFunc
 	r   *bufio.Reader
+	src struct {
+		mem      bool   // Set when reading from text rather than r
+		text     string // The whole input
+		off      int    // Byte offset of the next rune to read
+		eof      int    // Number of times the end of the text has been read and not unread
+		consumed int    // Byte offset of the next rune to consume
+	}
 	l   logger
+	cov *Coverage
 	buf struct {
-		runes  []rune
-		unread int
+		runes    []rune
+		unread   int
+		base     int // Offset in the input of runes[0], counted in runes
+		consumed int // Offset in the input of the next rune to consume, counted in runes
 	}
 	curLineBuffer bytes.Buffer
 	peekBuffer    []rune
@@ -28,9 +51,18 @@ type Scanner struct {
 	curPos        int
 	options       ExpectRuneOptions
 	expRunes      []rune
+	expPos        []runePos // Line and position of each rune in expRunes
+	expOff        []int     // Byte offset in src.text of each rune in expRunes, when reading from text
+	trivia        bool
+	raw           []rune // Every rune consumed by the current scan when trivia is kept
+	expRaw        []int  // Index into raw of each rune in expRunes, -1 for the end of input
 	tok           Token
 	error         *Error
 	eof           bool
+	state         *parseState   // User state shared with the parser, see State
+	lineStart     int           // Offset of the first rune in curLineBuffer
+	pin           *atomic.Int64 // Offset of the earliest rune compact must keep, set when pipelined
+	trace         bool          // Set when logging, so log messages are only built when written
 }
 
 type ScanFunc func(*Scanner) Token
@@ -46,6 +78,11 @@ type BranchRange struct {
 	Fn      func(*Scanner)
 }
 
+type runePos struct {
+	line int
+	pos  int
+}
+
 type Match struct {
 	Literal string
 	ID      TokenType
@@ -57,13 +94,23 @@ func newScanner(sf ScanFunc, r *bufio.Reader, l logger) *Scanner {
 		fn:      sf,
 		r:       r,
 		l:       l,
+		trace:   l.tracing(),
 		curLine: 1,
 		curPos:  1,
+		state:   &parseState{},
 	}
 
 	return s
 }
 
+// newStringScanner returns a Scanner reading the runes of src in place.
+func newStringScanner(sf ScanFunc, src string, l logger) *Scanner {
+	s := newScanner(sf, nil, l)
+	s.src.mem = true
+	s.src.text = src
+	return s
+}
+
 // If the Optional option is false and a match is not found, an error is returned to the
 // parser.
 //
@@ -88,6 +135,7 @@ type ExpectRune struct {
 	Branches     []Branch
 	BranchRanges []BranchRange
 	Options      ExpectRuneOptions
+	matcher      *runeMatcher // Set by Compile
 }
 
 // Expect first reads a rune from s.read() and then tries to match it against input
@@ -101,48 +149,39 @@ type ExpectRune struct {
 //
 // Any runes that are read but not consumed or skipped will be unread.
 func (s *Scanner) Expect(expect ExpectRune) {
+	var site *CoverageSite
+	if s.cov != nil {
+		site = s.cov.site(coverScannerExpect, branchRuneCoverage(expect.Branches, expect.BranchRanges))
+	}
 
-	s.log(fmt.Sprintf("Expect %v ", getExpectRuneOptions(expect.Options)), prefixNewline)
-	s.log(fmt.Sprintf("Rune: %v ", branchesToStrings(expect.Branches)), prefixNone)
-	s.log(fmt.Sprintf("Range: %v ", branchRangesToStrings(expect.BranchRanges)), prefixNone)
+	if s.trace {
+		s.log(fmt.Sprintf("Expect %v ", getExpectRuneOptions(expect.Options)), prefixNewline)
+		s.log(fmt.Sprintf("Rune: %v ", branchesToStrings(expect.Branches)), prefixNone)
+		s.log(fmt.Sprintf("Range: %v ", branchRangesToStrings(expect.BranchRanges)), prefixNone)
+	}
 	if s.tok.ID != "" {
 		s.log("Already Matched. Skipping.", prefixNewline)
 		return
 	}
+	s.cov.call(site)
 
 	var found1orMore bool
 	var rn rune
 
 	// Loop if Multiple is true
 	for {
-		var branchFn func(*Scanner)
-		found := false
 		rn = s.read()
 
-		// Check if the current rune matches any of the branches
-		for _, branch := range expect.Branches {
-			if branch.Rn == rn {
-				found = true
-				branchFn = branch.Fn
-				break
-			}
-		}
-
-		// Check if the current rune matches any of the branch ranges
-		if !found {
-			for _, branch := range expect.BranchRanges {
-				if branch.StartRn <= rn && rn <= branch.EndRn {
-					found = true
-					branchFn = branch.Fn
-					break
-				}
-			}
-		}
-		if !found {
+		// Check if the current rune matches any of the branches, then any
+		// of the branch ranges
+		branch := expect.match(rn)
+		if branch < 0 {
 			// If no match was found, unread the rune and break out of the loop
 			s.unread()
 			break
 		}
+		s.cov.hit(site, branch)
+		branchFn := expect.branchFn(branch)
 
 		// We found a match
 		if expect.Options.Peek {
@@ -161,8 +200,9 @@ func (s *Scanner) Expect(expect ExpectRune) {
 		found1orMore = true // Set to true only after logging the first match
 		s.scanFn(branchFn)
 
-		if !expect.Options.Multiple {
-			// If Multiple is false break out of the loop
+		if !expect.Options.Multiple || rn == rune(0) {
+			// If Multiple is false break out of the loop. The end of the input
+			// is read forever so it can only be matched once.
 			break
 		}
 	}
@@ -173,7 +213,6 @@ func (s *Scanner) Expect(expect ExpectRune) {
 	}
 
 	if !found1orMore && !expect.Options.Optional {
-		s.expRunes = append(s.expRunes, rn)
 		strings := append(branchesToStrings(expect.Branches), branchRangesToStrings(expect.BranchRanges)...)
 		s.error = s.newError(ErrorRuneExpectedNotFound, fmt.Errorf("found [%v], expected any of %v", string(rn), strings))
 	}
@@ -190,6 +229,7 @@ type ExpectNotRune struct {
 	RuneRanges []RuneRange
 	Fn         func(*Scanner)
 	Options    ExpectRuneOptions
+	matcher    *runeMatcher // Set by Compile
 }
 
 // ExpectNot reads a rune from the buffer and then tries to match it against the input
@@ -203,42 +243,37 @@ type ExpectNotRune struct {
 //
 // Any runes that are read but not consumed or skipped will be unread.
 func (s *Scanner) ExpectNot(expect ExpectNotRune) {
-	s.log(fmt.Sprintf("ExpectNot %v ", getExpectRuneOptions(expect.Options)), prefixNewline)
-	s.log(fmt.Sprintf("Rune: %v ", runesToStrings(expect.Runes)), prefixNone)
-	s.log(fmt.Sprintf("Range: %v ", runeRangesToStrings(expect.RuneRanges)), prefixNone)
+	var site *CoverageSite
+	if s.cov != nil {
+		site = s.cov.site(coverScannerExpectNot, notRuneCoverage(expect.Runes, expect.RuneRanges))
+	}
+
+	if s.trace {
+		s.log(fmt.Sprintf("ExpectNot %v ", getExpectRuneOptions(expect.Options)), prefixNewline)
+		s.log(fmt.Sprintf("Rune: %v ", runesToStrings(expect.Runes)), prefixNone)
+		s.log(fmt.Sprintf("Range: %v ", runeRangesToStrings(expect.RuneRanges)), prefixNone)
+	}
 	if s.tok.ID != "" {
 		s.log("Already Matched. Skipping.", prefixNewline)
 		return
 	}
+	s.cov.call(site)
 
 	var found1orMoreNot bool
 	var rn rune
 
 	for {
-		found := false
 		rn = s.read()
-		for _, expectedRune := range expect.Runes {
-			if expectedRune == rn {
-				found = true
-				break
-			}
-		}
-		if !found {
-			for _, expectedRuneRange := range expect.RuneRanges {
-				if expectedRuneRange.StartRn <= rn && rn <= expectedRuneRange.EndRn {
-					found = true
-					break
-				}
-			}
-		}
 
 		// If a match was found, unread the rune and break out of the loop
-		if found {
+		if branch := expect.match(rn); branch >= 0 {
+			s.cov.hit(site, 1+branch)
 			s.unread()
 			break
 		}
 
 		// Match not found, which is what we are expecting
+		s.cov.hit(site, 0)
 
 		if expect.Options.Peek {
 			// If we are peeking, remember each rune read
@@ -256,7 +291,8 @@ func (s *Scanner) ExpectNot(expect ExpectNotRune) {
 		found1orMoreNot = true // Set to true only after logging the first match
 		s.scanFn(expect.Fn)
 
-		if !expect.Options.Multiple {
+		if !expect.Options.Multiple || rn == rune(0) {
+			// The end of the input is read forever so it can only be matched once.
 			break
 		}
 	}
@@ -267,7 +303,6 @@ func (s *Scanner) ExpectNot(expect ExpectNotRune) {
 	}
 
 	if !found1orMoreNot && !expect.Options.Optional {
-		s.expRunes = append(s.expRunes, rn)
 		strings := append(runesToStrings(expect.Runes), runeRangesToStrings(expect.RuneRanges)...)
 		s.error = s.newError(ErrorRuneExpectedNotFound, fmt.Errorf("found [%v], expected any except %v", string(rn), strings))
 	}
@@ -275,9 +310,13 @@ func (s *Scanner) ExpectNot(expect ExpectNotRune) {
 }
 
 func (s *Scanner) Call(fn func(*Scanner)) {
-	s.log("Calling: "+getFuncName(fn), prefixIncrement)
+	if s.trace {
+		s.log("Calling: "+getFuncName(fn), prefixIncrement)
+	}
 	fn(s)
-	s.log("Returning: "+getFuncName(fn), prefixDecrement)
+	if s.trace {
+		s.log("Returning: "+getFuncName(fn), prefixDecrement)
+	}
 }
 
 // Match is required to be called by the user scan function before it
@@ -286,7 +325,8 @@ func (s *Scanner) Call(fn func(*Scanner)) {
 // Expect() and not skipped (s.scanStr), against the input string.
 //
 // Once matched a Token is generated from the input ID, s.scanStr and
-// the current line and position of the scanner. Once the user scan
+// the line and position of the first rune of s.scanStr. If every rune has
+// been skipped the current line and position of the scanner is used. Once the user scan
 // function has matched a token, any subsequent calls to Match will
 // do nothing until the user scan function returns and is called again
 // and reset (by s.init()) by the parser.
@@ -294,24 +334,72 @@ func (s *Scanner) Match(matches []Match) {
 	if s.tok.ID != "" {
 		return
 	}
-	expString := runesToString(s.expRunes)
+	expString := s.literal()
 	for _, match := range matches {
 		if expString == match.Literal || match.Literal == "" {
-			s.log("Matched: "+string(match.ID)+" - "+sanitize(expString, true), prefixNewline)
-			s.tok = Token{match.ID, expString, s.curLine, s.curPos - len(s.expRunes)}
+			if s.trace {
+				s.log("Matched: "+string(match.ID)+" - "+sanitize(expString, true), prefixNewline)
+			}
+			line, pos := s.curLine, s.curPos
+			if len(s.expPos) > 0 {
+				line, pos = s.expPos[0].line, s.expPos[0].pos
+			}
+			s.tok = Token{ID: match.ID, Literal: expString, Line: line, Position: pos}
 			break
 		}
 	}
 }
 
-// The user scan function should return the result of Exit()
+// SetValue gives the token matched by the current scan a value, such as the
+// number its literal stands for, which is carried through to the AST. It
+// does nothing if no token has been matched.
+func (s *Scanner) SetValue(v interface{}) {
+	if s.tok.ID == "" {
+		return
+	}
+	s.tok.Value = v
+}
+
+// Convert gives the token matched by the current scan the value fn returns
+// for its literal, see SetValue. If fn returns an error it is reported as a
+// scanner error with the code ErrorInvalidValue, spanning the literal, and
+// the token is returned without a value.
+func (s *Scanner) Convert(fn func(literal string) (interface{}, error)) {
+	if s.tok.ID == "" || s.error != nil {
+		return
+	}
+	v, err := fn(s.tok.Literal)
+	if err != nil {
+		s.log(err.Error(), prefixError)
+		endLine, endPos := literalEnd(s.tok.Line, s.tok.Position, s.tok.Literal)
+		s.error = &Error{
+			Code:          ErrorInvalidValue,
+			Message:       err.Error(),
+			LineString:    s.curLineBuffer.String(),
+			StartLine:     s.tok.Line,
+			StartPosition: s.tok.Position,
+			EndLine:       endLine,
+			EndPosition:   endPos,
+		}
+		return
+	}
+	s.tok.Value = v
+}
+
+// The user scan function should return the result of Exit(). If no
+// token was matched the token UNKNOWN is returned, holding whatever runes
+// were accepted so its span covers the unrecognised input.
 func (s *Scanner) Exit() Token {
 	if s.tok.ID == "" {
+		line, pos := s.curLine, s.curPos
+		if len(s.expPos) > 0 {
+			line, pos = s.expPos[0].line, s.expPos[0].pos
+		}
 		return Token{
 			ID:       TOKEN_UNKNOWN,
-			Literal:  "UNKNOWN",
-			Line:     s.curLine,
-			Position: s.curPos,
+			Literal:  s.literal(),
+			Line:     line,
+			Position: pos,
 		}
 	}
 	return s.tok
@@ -322,7 +410,16 @@ func (s *Scanner) SkipRune() {
 	if len(s.expRunes) > 0 {
 		rn := s.expRunes[len(s.expRunes)-1]
 		s.expRunes = s.expRunes[:len(s.expRunes)-1]
-		s.log(sanitize(string(rn), true)+", ", prefixNone)
+		s.expPos = s.expPos[:len(s.expPos)-1]
+		if s.src.mem {
+			s.expOff = s.expOff[:len(s.expOff)-1]
+		}
+		if s.trivia {
+			s.expRaw = s.expRaw[:len(s.expRaw)-1]
+		}
+		if s.trace {
+			s.log(sanitize(string(rn), true)+", ", prefixNone)
+		}
 	} else {
 		s.log("Warning: No Runes to Skip", prefixError)
 	}
@@ -330,21 +427,39 @@ func (s *Scanner) SkipRune() {
 
 // -------------------------------- scanner interface ---------------------------------------
 
+// scanner is the part of the Scanner used by the parser. line returns the
+// text of the line the last token was scanned on, for error messages. It is
+// only read when an error is reported, as it reads ahead to the end of the
+// line.
 type scanner interface {
-	scan() (Token, string, *Error)
+	scan() (Token, *Error)
+	line() string
 }
 
 // scan is the entry point from the parser.
-func (s *Scanner) scan() (Token, string, *Error) {
+func (s *Scanner) scan() (Token, *Error) {
 	s.init()
-	s.log("Scanning: "+getFuncName(s.fn), prefixIncrement)
-	defer s.log("Returning: "+getFuncName(s.fn), prefixDecrement) // use defer keyword to log after the fn has returned
-	return s.fn(s), s.getLine(), s.error                          // Call the user ScanFunc with a reference to the p.s scanner
+	if s.trace {
+		s.log("Scanning: "+getFuncName(s.fn), prefixIncrement)
+		defer s.log("Returning: "+getFuncName(s.fn), prefixDecrement) // use defer keyword to log after the fn has returned
+	}
+	tok := s.fn(s) // Call the user ScanFunc with a reference to the p.s scanner
+	if s.trivia {
+		tok.Leading, tok.Trailing = s.splitTrivia()
+	}
+	return tok, s.error
+}
+
+func (s *Scanner) line() string {
+	return s.getLine()
 }
 
 // -------------------------------- Scanner Core Functions---------------------------------------
 
 func (s *Scanner) logMatch(rn rune, found1orMore bool) {
+	if !s.trace {
+		return
+	}
 	if !found1orMore {
 		s.log(fmt.Sprintf("Pos:%v ", s.curPos), prefixNone)
 		s.log("Found: ", prefixNone)
@@ -359,8 +474,30 @@ func (s *Scanner) logMatch(rn rune, found1orMore bool) {
 }
 
 func (s *Scanner) consume(rn rune, skip bool) {
+	if s.trivia {
+		index := -1
+		if rn != rune(0) {
+			index = len(s.raw)
+			s.raw = append(s.raw, rn)
+		}
+		if !skip {
+			s.expRaw = append(s.expRaw, index)
+		}
+	}
 	if !skip {
 		s.expRunes = append(s.expRunes, rn)
+		s.expPos = append(s.expPos, runePos{s.curLine, s.curPos})
+		if s.src.mem {
+			s.expOff = append(s.expOff, s.src.consumed)
+		}
+	}
+	if !s.src.mem {
+		s.buf.consumed++
+	} else if s.src.consumed < len(s.src.text) {
+		// Runes are consumed in the order they are read, so the width of
+		// the rune is that of the next one in the text
+		_, width := utf8.DecodeRuneInString(s.src.text[s.src.consumed:])
+		s.src.consumed += width
 	}
 	s.curPos++
 
@@ -368,6 +505,7 @@ func (s *Scanner) consume(rn rune, skip bool) {
 		s.curLine++
 		s.curPos = 1
 		s.curLineBuffer.Reset()
+		s.lineStart = s.offset()
 	} else {
 		s.curLineBuffer.WriteRune(rn)
 	}
@@ -377,6 +515,15 @@ func (s *Scanner) consume(rn rune, skip bool) {
 // bufio reader s.r if it hasn't already been read. Using another buffer
 // s.buf means we can read and unread as many runes as we like.
 func (s *Scanner) read() rune {
+	if s.src.mem {
+		if s.src.off >= len(s.src.text) {
+			s.src.eof++
+			return rune(0)
+		}
+		rn, width := utf8.DecodeRuneInString(s.src.text[s.src.off:])
+		s.src.off += width
+		return rn
+	}
 
 	if s.buf.unread > 0 {
 		rn := s.buf.runes[len(s.buf.runes)-s.buf.unread]
@@ -395,8 +542,36 @@ func (s *Scanner) read() rune {
 	return rn
 }
 
-// To unread simply increment the index to the rune buffer
+// compact drops the runes consumed by earlier tokens from the read buffer.
+// A scan only unreads runes it read itself, so once a new scan starts only
+// the unread runes can be read again. Copying them to the front of the
+// buffer is left until enough runes have been consumed to make it worth it,
+// so the buffer stays within a few times that size however long the input.
+func (s *Scanner) compact() {
+	consumed := len(s.buf.runes) - s.buf.unread
+	if s.pin != nil {
+		// A pipelined scanner may be rewound, see rewind
+		consumed = min(consumed, int(s.pin.Load())-s.buf.base)
+	}
+	if consumed >= compactSize {
+		n := copy(s.buf.runes, s.buf.runes[consumed:])
+		s.buf.runes = s.buf.runes[:n]
+		s.buf.base += consumed
+	}
+}
+
+// To unread simply increment the index to the rune buffer, or step back a
+// rune when reading from text
 func (s *Scanner) unread() {
+	if s.src.mem {
+		if s.src.eof > 0 {
+			s.src.eof--
+		} else if s.src.off > 0 {
+			_, width := utf8.DecodeLastRuneInString(s.src.text[:s.src.off])
+			s.src.off -= width
+		}
+		return
+	}
 	if s.buf.unread < len(s.buf.runes) { // Ensure we don't unread more runes than have been read
 		s.buf.unread++
 	}
@@ -424,23 +599,151 @@ func (s *Scanner) scanFn(fn func(*Scanner)) {
 			s.log("Already Matched. Skipping.", prefixNewline)
 			return
 		}
-		s.log("Scanning: "+getFuncName(fn), prefixIncrement)
+		if s.trace {
+			s.log("Scanning: "+getFuncName(fn), prefixIncrement)
+		}
 		fn(s)
-		s.log("Returning: "+getFuncName(fn), prefixDecrement)
+		if s.trace {
+			s.log("Returning: "+getFuncName(fn), prefixDecrement)
+		}
 	}
 }
 
+// splitTrivia splits the runes consumed but skipped by the current scan into
+// those before the first kept rune and those after it. Runes skipped between
+// two kept runes are added after the last.
+func (s *Scanner) splitTrivia() (leading, trailing string) {
+	first, last := -1, -1
+	kept := make(map[int]bool, len(s.expRaw))
+	for _, index := range s.expRaw {
+		if index < 0 {
+			continue
+		}
+		if first < 0 {
+			first = index
+		}
+		last = index
+		kept[index] = true
+	}
+	if first < 0 {
+		return string(s.raw), ""
+	}
+
+	var middle []rune
+	for i := first + 1; i < last; i++ {
+		if !kept[i] {
+			middle = append(middle, s.raw[i])
+		}
+	}
+	return string(s.raw[:first]), string(middle) + string(s.raw[last+1:])
+}
+
+// remaining returns the input that has not been consumed, without consuming
+// it, so trivia can account for input after the last token scanned.
+func (s *Scanner) remaining() string {
+	if s.src.mem {
+		return s.src.text[s.src.off:]
+	}
+	var rest []rune
+	for i := len(s.buf.runes) - s.buf.unread; i < len(s.buf.runes); i++ {
+		if rn := s.buf.runes[i]; rn != rune(0) {
+			rest = append(rest, rn)
+		}
+	}
+	for {
+		rn, _, err := s.r.ReadRune()
+		if err != nil {
+			break
+		}
+		rest = append(rest, rn)
+	}
+	return string(rest)
+}
+
 // -------------------------------- Scanner Helper Functions---------------------------------------
 
 // Reset the scanner after every s.callFn() call
 func (s *Scanner) init() {
 	s.tok.ID = ""
-	s.expRunes = nil
+	s.compact()
+	// The buffers are reused from one token to the next, no token holds on
+	// to them
+	s.expRunes = s.expRunes[:0]
+	s.expPos = s.expPos[:0]
+	s.expOff = s.expOff[:0]
+	s.raw = s.raw[:0]
+	s.expRaw = s.expRaw[:0]
 	s.error = nil
 	s.startLine = s.curLine
 	s.startPos = s.curPos
 	s.peekBuffer = nil
 	s.curLineBuffer.Reset()
+	s.lineStart = s.offset()
+}
+
+// mayWait reports whether the next rune read may have to wait for input.
+func (s *Scanner) mayWait() bool {
+	return !s.src.mem && s.buf.unread == 0 && s.r.Buffered() == 0
+}
+
+// offset returns the offset in the input of the next rune to consume, in
+// bytes when reading from text and in runes otherwise.
+func (s *Scanner) offset() int {
+	if s.src.mem {
+		return s.src.consumed
+	}
+	return s.buf.consumed
+}
+
+// scanPoint is where a Scanner is between two scans, everything needed to
+// carry on from there.
+type scanPoint struct {
+	offset    int
+	lineStart int // Offset of the first rune in the line buffer
+	lineLen   int // Length of the line buffer in bytes
+	line      int
+	pos       int
+	state     interface{}
+}
+
+// point returns where the scanner is, to be returned to with rewind.
+func (s *Scanner) point() scanPoint {
+	pt := scanPoint{
+		offset:    s.offset(),
+		lineStart: s.lineStart,
+		lineLen:   s.curLineBuffer.Len(),
+		line:      s.curLine,
+		pos:       s.curPos,
+	}
+	if s.state != nil {
+		pt.state = s.state.value
+	}
+	return pt
+}
+
+// rewind returns the scanner to a point between two earlier scans. The runes
+// read since must still be in the read buffer, which a pipeline makes sure
+// of by pinning it.
+func (s *Scanner) rewind(pt scanPoint) {
+	s.curLine, s.curPos = pt.line, pt.pos
+	s.lineStart = pt.lineStart
+	s.curLineBuffer.Reset()
+	if s.src.mem {
+		s.src.off, s.src.consumed, s.src.eof = pt.offset, pt.offset, 0
+		s.curLineBuffer.WriteString(s.src.text[pt.lineStart:pt.offset])
+		for s.curLineBuffer.Len() < pt.lineLen {
+			s.curLineBuffer.WriteRune(rune(0)) // The end of the input was consumed
+		}
+	} else {
+		s.buf.consumed = pt.offset
+		s.buf.unread = len(s.buf.runes) - (pt.offset - s.buf.base)
+		for _, rn := range s.buf.runes[pt.lineStart-s.buf.base : pt.offset-s.buf.base] {
+			s.curLineBuffer.WriteRune(rn)
+		}
+	}
+	if s.state != nil {
+		s.state.value = pt.state
+	}
 }
 
 // Creates a new error and passes it to the parser. Only one error is generated by the
@@ -512,14 +815,42 @@ func getExpectRuneOptions(opts ExpectRuneOptions) string {
 	return buf.String()
 }
 
+// literal returns the runes accepted by the current scan as a string. When
+// reading from text and the runes are next to each other in it, the literal
+// is the substring of the text they were read from and no copy is made.
+func (s *Scanner) literal() string {
+	if !s.src.mem || len(s.expOff) == 0 {
+		return runesToString(s.expRunes)
+	}
+	start := s.expOff[0]
+	end := start
+	for i, rn := range s.expRunes {
+		switch {
+		case s.expOff[i] != end:
+			// A rune was skipped
+			return runesToString(s.expRunes)
+		case rn == rune(0) && end == len(s.src.text):
+			// The end of the input adds nothing to the literal
+		case rn == rune(0) || rn == utf8.RuneError:
+			// Either is written differently to the text it was read from
+			return runesToString(s.expRunes)
+		default:
+			end += utf8.RuneLen(rn)
+		}
+	}
+	return s.src.text[start:end]
+}
+
 // Used to calculate token literal strings
-func runesToString(runes []rune) (str string) {
+func runesToString(runes []rune) string {
+	var b strings.Builder
+	b.Grow(len(runes))
 	for _, rn := range runes {
 		if rn != rune(0) {
-			str = str + string(rn)
+			b.WriteRune(rn)
 		}
 	}
-	return
+	return b.String()
 }
 
 // Used to list possible scan branches in the log and errors in Expect()
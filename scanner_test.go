@@ -6,7 +6,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestScan(t *testing.T) {
@@ -40,13 +45,13 @@ func TestScan(t *testing.T) {
 	}
 
 	for i, expected := range expectedTokens {
-		token, _, _ := s.scan()
-		if token != expected {
+		token, _ := s.scan()
+		if !reflect.DeepEqual(token, expected) {
 			t.Errorf("Token %d: expected %v, got %v", i+1, expected, token)
 		}
 	}
 
-	if token, _, _ := s.scan(); token.ID != TOKEN_EOF {
+	if token, _ := s.scan(); token.ID != TOKEN_EOF {
 		t.Errorf("Expected EOF, got %v", token)
 	}
 }
@@ -175,17 +180,17 @@ func TestPeekScan(t *testing.T) {
 			s := newScanner(scanFn, bufio.NewReader(bytes.NewBufferString(tt.input)), logger)
 
 			for i, expectedToken := range tt.expected {
-				token, _, err := s.scan()
+				token, err := s.scan()
 				if err != nil {
 					t.Fatalf("Unexpected error at token %d: %v", i+1, err)
 				}
-				if token != expectedToken {
+				if !reflect.DeepEqual(token, expectedToken) {
 					t.Errorf("Token %d: expected %v, got %v", i+1, expectedToken, token)
 				}
 			}
 
 			// Ensure EOF is reached
-			token, _, err := s.scan()
+			token, err := s.scan()
 			if err != nil {
 				t.Fatalf("Unexpected error at EOF: %v", err)
 			}
@@ -195,3 +200,236 @@ func TestPeekScan(t *testing.T) {
 		})
 	}
 }
+
+// wordScan scans lower case words, double quoted strings and semicolons
+// separated by spaces and new lines.
+func wordScan(s *Scanner) Token {
+	s.Expect(ExpectRune{
+		Branches: []Branch{{Rn: ' '}, {Rn: '\n'}},
+		Options:  ExpectRuneOptions{Optional: true, Multiple: true, Skip: true},
+	})
+	s.Expect(ExpectRune{
+		Branches: []Branch{
+			{Rn: rune(0), Fn: func(s *Scanner) { s.Match([]Match{{Literal: "", ID: TOKEN_EOF}}) }},
+			{Rn: ';', Fn: func(s *Scanner) { s.Match([]Match{{Literal: ";", ID: "SEMI"}}) }},
+			{Rn: '"', Fn: func(s *Scanner) {
+				s.SkipRune()
+				s.ExpectNot(ExpectNotRune{Runes: []rune{'"'}, Options: ExpectRuneOptions{Optional: true, Multiple: true}})
+				s.Expect(ExpectRune{Branches: []Branch{{Rn: '"', Fn: func(s *Scanner) { s.SkipRune() }}}})
+				s.Match([]Match{{Literal: "", ID: "STRING"}})
+			}},
+		},
+		BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z', Fn: func(s *Scanner) {
+			s.Expect(ExpectRune{BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z'}}, Options: ExpectRuneOptions{Optional: true, Multiple: true}})
+			s.Match([]Match{{Literal: "", ID: "WORD"}})
+		}}},
+	})
+	return s.Exit()
+}
+
+func TestScanTokenPositions(t *testing.T) {
+	// Tokens start at their first rune kept, whatever was skipped before or
+	// inside them
+	input := "  ab\n\"cd\nef\" gh"
+	s := newScanner(wordScan, bufio.NewReader(bytes.NewBufferString(input)), &dslNoLogger{})
+
+	expectedTokens := []Token{
+		{ID: "WORD", Literal: "ab", Line: 1, Position: 3},
+		{ID: "STRING", Literal: "cd\nef", Line: 2, Position: 2},
+		{ID: "WORD", Literal: "gh", Line: 3, Position: 5},
+	}
+	for i, expected := range expectedTokens {
+		token, err := s.scan()
+		if err != nil {
+			t.Fatalf("Unexpected error at token %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(token, expected) {
+			t.Errorf("Token %d: expected %v, got %v", i+1, expected, token)
+		}
+	}
+}
+
+func TestScanUnknown(t *testing.T) {
+	// A token that is not matched holds the runes read, starting at the first
+	scanFn := func(s *Scanner) Token {
+		s.Expect(ExpectRune{
+			BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z'}},
+			Options:      ExpectRuneOptions{Multiple: true},
+		})
+		return s.Exit()
+	}
+	s := newScanner(scanFn, bufio.NewReader(bytes.NewBufferString("wx")), &dslNoLogger{})
+
+	expected := Token{ID: TOKEN_UNKNOWN, Literal: "wx", Line: 1, Position: 1}
+	if token, _ := s.scan(); !reflect.DeepEqual(token, expected) {
+		t.Errorf("Expected %v, got %v", expected, token)
+	}
+}
+
+func TestScanEndOfInput(t *testing.T) {
+	// The end of the input is read forever, so Multiple can match it only once
+	tests := []struct {
+		name   string
+		input  string
+		scanFn ScanFunc
+	}{
+		{
+			name:  "Expect",
+			input: "",
+			scanFn: func(s *Scanner) Token {
+				s.Expect(ExpectRune{
+					Branches: []Branch{{Rn: rune(0)}},
+					Options:  ExpectRuneOptions{Multiple: true},
+				})
+				s.Match([]Match{{Literal: "", ID: TOKEN_EOF}})
+				return s.Exit()
+			},
+		},
+		{
+			name:   "ExpectNot",
+			input:  "\"cd",
+			scanFn: wordScan,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScanner(tt.scanFn, bufio.NewReader(bytes.NewBufferString(tt.input)), &dslNoLogger{})
+			done := make(chan struct{})
+			go func() {
+				s.scan()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Expected the scan to stop at the end of the input")
+			}
+		})
+	}
+}
+
+func TestScanBoundedBuffer(t *testing.T) {
+	// Tokens spanning more than one compaction, with a peek at each space
+	input := strings.Repeat("word \"a string\" ", 2000)
+	s := newScanner(triviaScan, bufio.NewReader(strings.NewReader(input)), &dslNoLogger{})
+	var words int
+	for {
+		tok, err := s.scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.ID == TOKEN_EOF {
+			break
+		}
+		if tok.ID == "WORD" && tok.Literal != "word" || tok.ID == "STRING" && tok.Literal != "a string" {
+			t.Fatalf("unexpected token %+v", tok)
+		}
+		words++
+		if len(s.buf.runes) > 2*compactSize {
+			t.Fatalf("read buffer grew to %d runes", len(s.buf.runes))
+		}
+	}
+	if words != 4000 {
+		t.Errorf("expected 4000 tokens, found %d", words)
+	}
+}
+
+// scanAll scans tokens with triviaScan up to TOKEN_EOF or the first error.
+func scanAll(t *testing.T, s *Scanner) []Token {
+	var tokens []Token
+	for {
+		tok, err := s.scan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, tok)
+		if tok.ID == TOKEN_EOF {
+			return tokens
+		}
+	}
+}
+
+func TestScanString(t *testing.T) {
+	inputs := []string{
+		"",
+		"say \"hi there\" ;\n  bye",
+		"\"ünïcode ✓\" word",
+		"\"bad \xff byte\" ;",
+	}
+	for _, input := range inputs {
+		s := newScanner(triviaScan, bufio.NewReader(strings.NewReader(input)), &dslNoLogger{})
+		s.trivia = true
+		expected := scanAll(t, s)
+
+		s = newStringScanner(triviaScan, input, &dslNoLogger{})
+		s.trivia = true
+		found := scanAll(t, s)
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("%q:\nexpected %+v\nfound    %+v", input, expected, found)
+		}
+		if rest := s.remaining(); rest != "" {
+			t.Errorf("%q: unexpected input remaining %q", input, rest)
+		}
+
+		// Literals spelt as in the input are read in place
+		start := uintptr(unsafe.Pointer(unsafe.StringData(input)))
+		for _, tok := range found {
+			if tok.Literal == "" || !strings.Contains(input, tok.Literal) {
+				continue
+			}
+			if p := uintptr(unsafe.Pointer(unsafe.StringData(tok.Literal))); p < start || p >= start+uintptr(len(input)) {
+				t.Errorf("%q: literal %q is a copy of the input", input, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestScanStringAllocs(t *testing.T) {
+	// Strings are left out as the tables of the string branch of triviaScan
+	// are built on every call
+	input := strings.Repeat("word ; ", 1500)
+	allocs := testing.AllocsPerRun(10, func() {
+		s := newStringScanner(triviaScan, input, &dslNoLogger{})
+		for {
+			tok, err := s.scan()
+			if err != nil || tok.ID == TOKEN_EOF {
+				break
+			}
+		}
+	})
+	if allocs > 20 {
+		t.Errorf("expected scanning 3000 tokens to allocate almost nothing, found %v allocations", allocs)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	scanFn := func(s *Scanner) Token {
+		s.SetValue("unmatched") // Does nothing before a token is matched
+		s.Expect(ExpectRune{
+			Branches: []Branch{{Rn: ' '}, {Rn: '\n'}},
+			Options:  ExpectRuneOptions{Optional: true, Multiple: true, Skip: true},
+		})
+		s.Expect(ExpectRune{
+			Branches: []Branch{{Rn: rune(0), Fn: func(s *Scanner) { s.Match([]Match{{ID: TOKEN_EOF}}) }}},
+			BranchRanges: []BranchRange{{StartRn: '0', EndRn: '9', Fn: func(s *Scanner) {
+				s.Expect(ExpectRune{BranchRanges: []BranchRange{{StartRn: '0', EndRn: '9'}}, Options: ExpectRuneOptions{Optional: true, Multiple: true}})
+				s.Match([]Match{{ID: "NUMBER"}})
+				s.Convert(func(literal string) (interface{}, error) { return strconv.ParseInt(literal, 10, 8) })
+			}}},
+		})
+		return s.Exit()
+	}
+
+	s := newStringScanner(scanFn, "12\n 300", &dslNoLogger{})
+	if tok, err := s.scan(); err != nil || tok.Value != int64(12) {
+		t.Errorf("expected the value 12, found %+v %v", tok, err)
+	}
+	tok, err := s.scan()
+	if err == nil || err.Code != ErrorInvalidValue || err.StartLine != 2 || err.StartPosition != 2 || err.EndPosition != 4 {
+		t.Fatalf("expected an invalid value at 2:2-4, found %+v", err)
+	}
+	if tok.ID != "NUMBER" || tok.Literal != "300" || tok.Value != nil {
+		t.Errorf("expected the number without a value, found %+v", tok)
+	}
+}
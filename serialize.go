@@ -0,0 +1,714 @@
+// serialize.go implements round-trippable encodings of the AST. Three
+// formats are supported; JSON using the struct tags on Node, a compact
+// S-expression form that is easy to read and write by hand, and a binary
+// encoding for caching and transport.
+//
+// Node.Parent is not part of any encoding as it would make the tree cyclic.
+// Every decoder rebuilds the Parent references and resets the AST curNode
+// to the RootNode so a decoded tree can be used exactly like a parsed one.
+//
+// Token positions are kept by the S-expression and binary encodings. JSON
+// writes each token as its ID and literal, along with any trivia, so the
+// tokens of a decoded JSON tree have no Line or Position.
+//
+// Node IDs are kept by the JSON and binary encodings. JSON only writes the
+// IDs that break from preorder, so a tree as numbered by a parse is written
+// without them. S-expressions are meant to be written by hand as well, so
+// they leave the IDs out and the nodes of a decoded S-expression are
+// numbered in preorder, as a parse numbers them. Node attributes are kept
+// by every encoding as JSON. The Value of a token is not kept by any, see
+// ASTToken.
+package dsl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UnmarshalJSON implements json.Unmarshaler. The AST is marshalled with
+// the default encoder using the struct tags on AST and Node.
+func (a *AST) UnmarshalJSON(data []byte) error {
+	var v struct {
+		RootNode *Node `json:"root"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.RootNode == nil {
+		return errors.New("dsl: JSON AST has no root node")
+	}
+	a.setRoot(v.RootNode)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. The ID of a node is only written if
+// it is not the ID of the node before it in preorder plus one, or 1 for the
+// node being marshalled, so a tree numbered by a parse is written without
+// IDs. Decoding gives the nodes without one the ID following the node before
+// them, which restores every ID.
+func (n Node) MarshalJSON() ([]byte, error) {
+	prev := 0
+	return json.Marshal(newNodeJSON(&n, &prev))
+}
+
+// nodeJSON is the JSON form of a Node, with the IDs that follow on in
+// preorder left out. The fields are in the order of those of Node.
+type nodeJSON struct {
+	ID       int        `json:"id,omitempty"`
+	Type     NodeType   `json:"type"`
+	Tokens   []ASTToken `json:"tokens"`
+	Hidden   []ASTToken `json:"hidden,omitempty"`
+	Comments []Comment  `json:"comments,omitempty"`
+	Children []nodeJSON `json:"children"`
+	Errors   []Error    `json:"errors,omitempty"`
+	Attrs    Attributes `json:"attrs,omitempty"`
+}
+
+// newNodeJSON returns the JSON form of the node, given the ID of the node
+// before it in preorder, which it moves on to the last node of its subtree.
+func newNodeJSON(n *Node, prev *int) nodeJSON {
+	v := nodeJSON{Type: n.Type, Tokens: n.Tokens, Hidden: n.Hidden, Comments: n.Comments, Errors: n.Errors, Attrs: n.Attrs}
+	if n.ID != *prev+1 {
+		v.ID = n.ID
+	}
+	*prev = n.ID
+	if n.Children != nil {
+		v.Children = make([]nodeJSON, len(n.Children))
+		for i := range n.Children {
+			v.Children[i] = newNodeJSON(&n.Children[i], prev)
+		}
+	}
+	return v
+}
+
+// ---------------------------------------------------------------------------------------------------------
+
+// MarshalSExpr encodes the AST as an S-expression. Each node is written as
+// a list headed by its NodeType, followed by its tokens and then its
+// children. Tokens are written as a list of the TokenType, the quoted
+// literal and the line:position the token was found, for example:
+//
+//	(ROOT
+//	  (ASSIGNMENT (VARIABLE "a" 1:1)
+//	    (TERMINAL (LITERAL "1" 1:6))))
+//
+// The position may be omitted when writing fixtures by hand. Trivia kept
+// with WithTrivia follows the position as the keyword :leading or :trailing
+// and a quoted string, for example (VARIABLE "b" 2:1 :leading "\n").
+//
+// Hidden tokens attached to a node are written after its tokens as a list
+// headed by the keyword :hidden followed by the tokens.
+//
+// Comments attached to a node are written after its hidden tokens as a list
+// headed by the keyword :comment followed by the kind of comment and the
+// token, for example (:comment trailing (COMMENT "note" 1:9)).
+//
+// Errors attached to a node are written after its comments as a list headed
+// by the keyword :error, holding the code, message, start line, start position,
+// end line, end position and line string.
+//
+// Attributes are written after the errors, in order of name, as a list
+// headed by the keyword :attr holding the quoted name and the value as
+// quoted JSON, for example (:attr "type" "\"int\"").
+//
+// Types containing whitespace, parentheses, quotes or semicolons, or that
+// start with a colon, are quoted.
+func (a AST) MarshalSExpr() ([]byte, error) {
+	if a.RootNode == nil {
+		return nil, errors.New("dsl: AST has no root node")
+	}
+	var buf bytes.Buffer
+	if err := writeSExprNode(&buf, a.RootNode, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalSExpr decodes an AST written by MarshalSExpr. Line comments
+// starting with a semicolon are ignored so fixtures can be annotated.
+func (a *AST) UnmarshalSExpr(data []byte) error {
+	r := &sexprReader{data: data, line: 1}
+	v, err := r.next()
+	if err != nil {
+		return err
+	}
+	if !v.isList {
+		return r.errorf("expected a node, found %q", v.atom)
+	}
+	root, err := sexprToNode(v)
+	if err != nil {
+		return err
+	}
+	if v, err := r.next(); err == nil {
+		return r.errorf("unexpected %v after root node", v)
+	} else if err != errSExprEOF {
+		return err
+	}
+	a.setRoot(root)
+	return nil
+}
+
+func writeSExprNode(buf *bytes.Buffer, n *Node, depth int) error {
+	buf.WriteByte('(')
+	buf.WriteString(sexprSymbol(string(n.Type)))
+	for _, tok := range n.Tokens {
+		buf.WriteByte(' ')
+		writeSExprToken(buf, tok)
+	}
+	if len(n.Hidden) > 0 {
+		buf.WriteString(" (:hidden")
+		for _, tok := range n.Hidden {
+			buf.WriteByte(' ')
+			writeSExprToken(buf, tok)
+		}
+		buf.WriteByte(')')
+	}
+	for _, c := range n.Comments {
+		buf.WriteString(" (:comment " + c.Kind.String() + " ")
+		writeSExprToken(buf, c.Token)
+		buf.WriteByte(')')
+	}
+	for _, err := range n.Errors {
+		fmt.Fprintf(buf, " (:error %d %s %d %d %d %d %s)", err.Code, strconv.Quote(err.Message),
+			err.StartLine, err.StartPosition, err.EndLine, err.EndPosition, strconv.Quote(err.LineString))
+	}
+	for _, name := range n.Attrs.names() {
+		v, err := n.Attrs.marshal(name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, " (:attr %s %s)", strconv.Quote(name), strconv.Quote(string(v)))
+	}
+	for i := range n.Children {
+		buf.WriteByte('\n')
+		buf.WriteString(strings.Repeat("  ", depth+1))
+		if err := writeSExprNode(buf, &n.Children[i], depth+1); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(')')
+	return nil
+}
+
+func writeSExprToken(buf *bytes.Buffer, tok ASTToken) {
+	buf.WriteByte('(')
+	buf.WriteString(sexprSymbol(string(tok.ID)))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.Quote(tok.Literal))
+	if tok.Line != 0 || tok.Position != 0 {
+		fmt.Fprintf(buf, " %d:%d", tok.Line, tok.Position)
+	}
+	if tok.Leading != "" {
+		buf.WriteString(" :leading " + strconv.Quote(tok.Leading))
+	}
+	if tok.Trailing != "" {
+		buf.WriteString(" :trailing " + strconv.Quote(tok.Trailing))
+	}
+	buf.WriteByte(')')
+}
+
+// sexprSymbol returns the symbol unquoted unless it would not be read back
+// as a single symbol.
+func sexprSymbol(s string) string {
+	if s == "" || s[0] == ':' || strings.ContainsAny(s, " \t\r\n\v\f()\";") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// sexpr is a parsed S-expression. It is either an atom (a bare symbol or a
+// quoted string) or a list.
+type sexpr struct {
+	atom   string
+	quoted bool
+	isList bool
+	list   []sexpr
+	line   int
+}
+
+func (v sexpr) String() string {
+	if v.isList {
+		return "list"
+	}
+	return strconv.Quote(v.atom)
+}
+
+// sexprToNode converts a list of the form (TYPE token... child...) to a Node.
+func sexprToNode(v sexpr) (*Node, error) {
+	if len(v.list) == 0 || v.list[0].isList {
+		return nil, fmt.Errorf("dsl: line %v: node must start with its type", v.line)
+	}
+	n := &Node{Type: NodeType(v.list[0].atom)}
+	for _, item := range v.list[1:] {
+		if !item.isList {
+			return nil, fmt.Errorf("dsl: line %v: unexpected %v in node %v", item.line, item, n.Type)
+		}
+		if isSExprKeyword(item, ":hidden") {
+			if len(n.Children) > 0 {
+				return nil, fmt.Errorf("dsl: line %v: hidden tokens after children in node %v", item.line, n.Type)
+			}
+			for _, v := range item.list[1:] {
+				if !isSExprToken(v) {
+					return nil, fmt.Errorf("dsl: line %v: invalid hidden token in node %v", item.line, n.Type)
+				}
+				tok, err := sexprToToken(v)
+				if err != nil {
+					return nil, err
+				}
+				n.Hidden = append(n.Hidden, tok)
+			}
+			continue
+		}
+		if isSExprKeyword(item, ":comment") {
+			if len(n.Children) > 0 {
+				return nil, fmt.Errorf("dsl: line %v: comment after children in node %v", item.line, n.Type)
+			}
+			c, ok := sexprToComment(item)
+			if !ok {
+				return nil, fmt.Errorf("dsl: line %v: invalid comment in node %v", item.line, n.Type)
+			}
+			n.Comments = append(n.Comments, c)
+			continue
+		}
+		if isSExprKeyword(item, ":error") {
+			if len(n.Children) > 0 {
+				return nil, fmt.Errorf("dsl: line %v: error after children in node %v", item.line, n.Type)
+			}
+			err, ok := sexprToError(item)
+			if !ok {
+				return nil, fmt.Errorf("dsl: line %v: invalid error in node %v", item.line, n.Type)
+			}
+			n.Errors = append(n.Errors, err)
+			continue
+		}
+		if isSExprKeyword(item, ":attr") {
+			if len(n.Children) > 0 {
+				return nil, fmt.Errorf("dsl: line %v: attribute after children in node %v", item.line, n.Type)
+			}
+			if len(item.list) != 3 || !item.list[1].quoted || !item.list[2].quoted || !json.Valid([]byte(item.list[2].atom)) {
+				return nil, fmt.Errorf("dsl: line %v: invalid attribute in node %v", item.line, n.Type)
+			}
+			if n.Attrs == nil {
+				n.Attrs = Attributes{}
+			}
+			n.Attrs[item.list[1].atom] = json.RawMessage(item.list[2].atom)
+			continue
+		}
+		if isSExprToken(item) {
+			if len(n.Children) > 0 {
+				return nil, fmt.Errorf("dsl: line %v: token after children in node %v", item.line, n.Type)
+			}
+			tok, err := sexprToToken(item)
+			if err != nil {
+				return nil, err
+			}
+			n.Tokens = append(n.Tokens, tok)
+			continue
+		}
+		child, err := sexprToNode(item)
+		if err != nil {
+			return nil, err
+		}
+		n.Children = append(n.Children, *child)
+	}
+	return n, nil
+}
+
+// sexprToComment converts a list of the form (:comment kind token).
+func sexprToComment(v sexpr) (Comment, bool) {
+	if len(v.list) != 3 || v.list[1].isList || v.list[1].quoted || !isSExprToken(v.list[2]) {
+		return Comment{}, false
+	}
+	kind, ok := parseCommentKind(v.list[1].atom)
+	if !ok {
+		return Comment{}, false
+	}
+	tok, err := sexprToToken(v.list[2])
+	if err != nil {
+		return Comment{}, false
+	}
+	return Comment{Kind: kind, Token: tok}, true
+}
+
+// A token is the only list whose second element is a quoted string.
+func isSExprToken(v sexpr) bool {
+	return len(v.list) >= 2 && !v.list[0].isList && !v.list[1].isList && v.list[1].quoted
+}
+
+// sexprToToken converts a list of the form (ID "literal" line:position
+// :leading "..." :trailing "...") to an ASTToken.
+func sexprToToken(v sexpr) (ASTToken, error) {
+	tok := ASTToken{ID: TokenType(v.list[0].atom), Literal: v.list[1].atom}
+	rest := v.list[2:]
+	if len(rest) > 0 && !rest[0].isList && !rest[0].quoted && !strings.HasPrefix(rest[0].atom, ":") {
+		var ok bool
+		if tok.Line, tok.Position, ok = sexprPosition(rest[0]); !ok {
+			return tok, fmt.Errorf("dsl: line %v: invalid token position %v", v.line, rest[0])
+		}
+		rest = rest[1:]
+	}
+	for ; len(rest) > 0; rest = rest[2:] {
+		if len(rest) < 2 || rest[0].isList || rest[0].quoted || rest[1].isList || !rest[1].quoted {
+			return tok, fmt.Errorf("dsl: line %v: invalid token %v", v.line, tok.ID)
+		}
+		switch rest[0].atom {
+		case ":leading":
+			tok.Leading = rest[1].atom
+		case ":trailing":
+			tok.Trailing = rest[1].atom
+		default:
+			return tok, fmt.Errorf("dsl: line %v: unknown token keyword %v", v.line, rest[0].atom)
+		}
+	}
+	return tok, nil
+}
+
+// sexprPosition parses a line:position atom.
+func sexprPosition(v sexpr) (line, pos int, ok bool) {
+	if v.isList || v.quoted {
+		return 0, 0, false
+	}
+	lineStr, posStr, found := strings.Cut(v.atom, ":")
+	if !found {
+		return 0, 0, false
+	}
+	line, err1 := strconv.Atoi(lineStr)
+	pos, err2 := strconv.Atoi(posStr)
+	return line, pos, err1 == nil && err2 == nil
+}
+
+func isSExprKeyword(v sexpr, keyword string) bool {
+	return len(v.list) > 0 && !v.list[0].isList && !v.list[0].quoted && v.list[0].atom == keyword
+}
+
+// sexprToError converts a list of the form
+// (:error code "message" startLine startPos endLine endPos "line").
+func sexprToError(v sexpr) (Error, bool) {
+	if len(v.list) != 8 || !v.list[2].quoted || !v.list[7].quoted {
+		return Error{}, false
+	}
+	var ints [5]int
+	for i, item := range []sexpr{v.list[1], v.list[3], v.list[4], v.list[5], v.list[6]} {
+		n, err := strconv.Atoi(item.atom)
+		if item.isList || item.quoted || err != nil {
+			return Error{}, false
+		}
+		ints[i] = n
+	}
+	return Error{
+		Code:          ErrorCode(ints[0]),
+		Message:       v.list[2].atom,
+		StartLine:     ints[1],
+		StartPosition: ints[2],
+		EndLine:       ints[3],
+		EndPosition:   ints[4],
+		LineString:    v.list[7].atom,
+	}, true
+}
+
+var errSExprEOF = errors.New("dsl: unexpected end of S-expression")
+
+type sexprReader struct {
+	data []byte
+	pos  int
+	line int
+}
+
+func (r *sexprReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("dsl: line %v: "+format, append([]interface{}{r.line}, args...)...)
+}
+
+// next reads the next complete atom or list.
+func (r *sexprReader) next() (sexpr, error) {
+	r.skipSpace()
+	if r.pos >= len(r.data) {
+		return sexpr{}, errSExprEOF
+	}
+	line := r.line
+	switch c := r.data[r.pos]; c {
+	case '(':
+		r.pos++
+		v := sexpr{isList: true, line: line}
+		for {
+			r.skipSpace()
+			if r.pos >= len(r.data) {
+				return sexpr{}, errSExprEOF
+			}
+			if r.data[r.pos] == ')' {
+				r.pos++
+				return v, nil
+			}
+			item, err := r.next()
+			if err != nil {
+				return sexpr{}, err
+			}
+			v.list = append(v.list, item)
+		}
+	case ')':
+		return sexpr{}, r.errorf("unexpected ')'")
+	case '"':
+		start := r.pos
+		r.pos++
+		for r.pos < len(r.data) && r.data[r.pos] != '"' {
+			if r.data[r.pos] == '\\' {
+				r.pos++
+			}
+			if r.pos < len(r.data) && r.data[r.pos] == '\n' {
+				r.line++
+			}
+			r.pos++
+		}
+		if r.pos >= len(r.data) {
+			return sexpr{}, errSExprEOF
+		}
+		r.pos++
+		s, err := strconv.Unquote(string(r.data[start:r.pos]))
+		if err != nil {
+			return sexpr{}, r.errorf("invalid string %s", r.data[start:r.pos])
+		}
+		return sexpr{atom: s, quoted: true, line: line}, nil
+	default:
+		start := r.pos
+		for r.pos < len(r.data) && !strings.ContainsRune(" \t\r\n\v\f()\";", rune(r.data[r.pos])) {
+			r.pos++
+		}
+		return sexpr{atom: string(r.data[start:r.pos]), line: line}, nil
+	}
+}
+
+func (r *sexprReader) skipSpace() {
+	for r.pos < len(r.data) {
+		switch r.data[r.pos] {
+		case '\n':
+			r.line++
+		case ' ', '\t', '\r', '\v', '\f':
+		case ';':
+			for r.pos < len(r.data) && r.data[r.pos] != '\n' {
+				r.pos++
+			}
+			continue
+		default:
+			return
+		}
+		r.pos++
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------------
+
+// binaryMagic identifies the binary AST encoding. The last byte is the
+// format version.
+var binaryMagic = []byte{'D', 'S', 'L', 5}
+
+// MarshalBinary implements encoding.BinaryMarshaler. Strings are written as
+// a uvarint length followed by their bytes and slices as a uvarint count
+// followed by their elements. Attribute values are written as strings of
+// JSON.
+func (a AST) MarshalBinary() ([]byte, error) {
+	if a.RootNode == nil {
+		return nil, errors.New("dsl: AST has no root node")
+	}
+	buf := append([]byte(nil), binaryMagic...)
+	return appendBinaryNode(buf, a.RootNode)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (a *AST) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, binaryMagic) {
+		return errors.New("dsl: invalid binary AST header")
+	}
+	r := &binaryReader{data: data[len(binaryMagic):]}
+	root := &Node{}
+	r.node(root)
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) > 0 {
+		return errors.New("dsl: trailing data after binary AST")
+	}
+	a.setRoot(root)
+	return nil
+}
+
+func appendBinaryNode(buf []byte, n *Node) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(n.ID))
+	buf = appendBinaryString(buf, string(n.Type))
+	buf = appendBinaryTokens(buf, n.Tokens)
+	buf = appendBinaryTokens(buf, n.Hidden)
+	buf = binary.AppendUvarint(buf, uint64(len(n.Comments)))
+	for _, c := range n.Comments {
+		buf = binary.AppendUvarint(buf, uint64(c.Kind))
+		buf = appendBinaryTokens(buf, []ASTToken{c.Token})
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(n.Errors)))
+	for _, err := range n.Errors {
+		buf = binary.AppendVarint(buf, int64(err.Code))
+		buf = appendBinaryString(buf, err.Message)
+		buf = appendBinaryString(buf, err.LineString)
+		for _, v := range []int{err.StartLine, err.StartPosition, err.EndLine, err.EndPosition} {
+			buf = binary.AppendVarint(buf, int64(v))
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(n.Attrs)))
+	for _, name := range n.Attrs.names() {
+		v, err := n.Attrs.marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendBinaryString(buf, name)
+		buf = appendBinaryString(buf, string(v))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(n.Children)))
+	for i := range n.Children {
+		var err error
+		if buf, err = appendBinaryNode(buf, &n.Children[i]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendBinaryTokens(buf []byte, tokens []ASTToken) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(tokens)))
+	for _, tok := range tokens {
+		buf = appendBinaryString(buf, string(tok.ID))
+		buf = appendBinaryString(buf, tok.Literal)
+		buf = binary.AppendVarint(buf, int64(tok.Line))
+		buf = binary.AppendVarint(buf, int64(tok.Position))
+		buf = appendBinaryString(buf, tok.Leading)
+		buf = appendBinaryString(buf, tok.Trailing)
+	}
+	return buf
+}
+
+func appendBinaryString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// binaryReader decodes the binary encoding. The first error is kept and
+// every subsequent read returns zero values.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) tokens() []ASTToken {
+	count := r.count()
+	if count == 0 {
+		return nil
+	}
+	tokens := make([]ASTToken, count)
+	for i := range tokens {
+		tokens[i].ID = TokenType(r.string())
+		tokens[i].Literal = r.string()
+		tokens[i].Line = int(r.varint())
+		tokens[i].Position = int(r.varint())
+		tokens[i].Leading = r.string()
+		tokens[i].Trailing = r.string()
+	}
+	return tokens
+}
+
+func (r *binaryReader) node(n *Node) {
+	n.ID = int(r.uvarint())
+	n.Type = NodeType(r.string())
+	n.Tokens = r.tokens()
+	n.Hidden = r.tokens()
+	if count := r.count(); count > 0 {
+		n.Comments = make([]Comment, count)
+		for i := range n.Comments {
+			n.Comments[i].Kind = CommentKind(r.uvarint())
+			if tokens := r.tokens(); len(tokens) == 1 {
+				n.Comments[i].Token = tokens[0]
+			} else if r.err == nil {
+				r.err = errors.New("dsl: invalid comment in binary AST")
+			}
+		}
+	}
+	if count := r.count(); count > 0 {
+		n.Errors = make([]Error, count)
+		for i := range n.Errors {
+			err := &n.Errors[i]
+			err.Code = ErrorCode(r.varint())
+			err.Message = r.string()
+			err.LineString = r.string()
+			err.StartLine = int(r.varint())
+			err.StartPosition = int(r.varint())
+			err.EndLine = int(r.varint())
+			err.EndPosition = int(r.varint())
+		}
+	}
+	if count := r.count(); count > 0 {
+		n.Attrs = make(Attributes, count)
+		for i := 0; i < count; i++ {
+			name := r.string()
+			n.Attrs[name] = json.RawMessage(r.string())
+		}
+	}
+	if count := r.count(); count > 0 {
+		n.Children = make([]Node, count)
+		for i := range n.Children {
+			r.node(&n.Children[i])
+		}
+	}
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, size := binary.Uvarint(r.data)
+	if size <= 0 {
+		r.err = errors.New("dsl: truncated binary AST")
+		return 0
+	}
+	r.data = r.data[size:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, size := binary.Varint(r.data)
+	if size <= 0 {
+		r.err = errors.New("dsl: truncated binary AST")
+		return 0
+	}
+	r.data = r.data[size:]
+	return v
+}
+
+// count reads a slice length. Every element takes at least one byte so a
+// count larger than the remaining data is corrupt.
+func (r *binaryReader) count() int {
+	v := r.uvarint()
+	if v > uint64(len(r.data)) {
+		if r.err == nil {
+			r.err = errors.New("dsl: corrupt binary AST")
+		}
+		return 0
+	}
+	return int(v)
+}
+
+func (r *binaryReader) string() string {
+	size := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if size > uint64(len(r.data)) {
+		r.err = errors.New("dsl: truncated binary AST")
+		return ""
+	}
+	s := string(r.data[:size])
+	r.data = r.data[size:]
+	return s
+}
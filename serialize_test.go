@@ -0,0 +1,137 @@
+package dsl
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// newTestAST builds a small tree resembling the mydsl example:
+// a := 1 * b
+func newTestAST() AST {
+	a := newAST()
+	a.addNode("ASSIGNMENT")
+	a.addToken([]Token{{ID: "VARIABLE", Literal: "a", Line: 1, Position: 1}})
+	a.addNode("TERMINAL")
+	a.addToken([]Token{{ID: "LITERAL", Literal: "1", Line: 1, Position: 6, Leading: " := ", Trailing: " "}})
+	a.walkUp()
+	a.addNode("EXPRESSION")
+	a.addToken([]Token{{ID: "MULTIPLY", Literal: "*", Line: 1, Position: 8}})
+	a.addHidden(Token{ID: "COMMENT", Literal: "times", Line: 1, Position: 12})
+	a.curNode.Comments = append(a.curNode.Comments, Comment{Kind: CommentTrailing, Token: ASTToken{ID: "COMMENT", Literal: "note", Line: 1, Position: 20}})
+	a.addNode("TERMINAL")
+	a.addToken([]Token{{ID: "STRING", Literal: "say \"hi\"\n", Line: 1, Position: 10}})
+	a.addError(Error{Code: ErrorTokenExpectedNotFound, Message: "found [x]", LineString: "a := 1 * x", StartLine: 1, StartPosition: 10, EndLine: 1, EndPosition: 10})
+	a.walkUp()
+	a.walkUp()
+	a.walkUp()
+	a.addNode("TYPE WITH (SPACES)")
+	a.walkUp()
+	a.addNode(":error")
+	return a
+}
+
+// checkParents fails the test if any Parent reference in the tree does not
+// point to the node holding it as a child.
+func checkParents(t *testing.T, n *Node) {
+	t.Helper()
+	for i := range n.Children {
+		if n.Children[i].Parent != n {
+			t.Fatalf("node %v: Parent does not point to %v", n.Children[i].Type, n.Type)
+		}
+		checkParents(t, &n.Children[i])
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	formats := []struct {
+		name      string
+		marshal   func(AST) ([]byte, error)
+		unmarshal func(*AST, []byte) error
+	}{
+		{"JSON", func(a AST) ([]byte, error) { return json.Marshal(a) }, func(a *AST, b []byte) error { return json.Unmarshal(b, a) }},
+		{"SExpr", AST.MarshalSExpr, (*AST).UnmarshalSExpr},
+		{"Binary", AST.MarshalBinary, (*AST).UnmarshalBinary},
+	}
+
+	expected, _ := json.Marshal(newTestAST())
+
+	for _, f := range formats {
+		t.Run(f.name, func(t *testing.T) {
+			a := newTestAST()
+			a.RootNode.Children[0].Tokens[0].Value = []int{1} // Transient, and not comparable
+			data, err := f.marshal(a)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			var decoded AST
+			if err := f.unmarshal(&decoded, data); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			actual, _ := json.Marshal(decoded)
+			if string(actual) != string(expected) {
+				t.Errorf("Round trip mismatch:\nexpected %s\ngot      %s", expected, actual)
+			}
+			checkParents(t, decoded.RootNode)
+			// Positions are kept by every encoding but JSON
+			if tok := decoded.RootNode.Children[0].Tokens[0]; (tok.Line == 1 && tok.Position == 1) != (f.name != "JSON") {
+				t.Errorf("unexpected token position %v:%v", tok.Line, tok.Position)
+			}
+			if v := decoded.RootNode.Children[0].Tokens[0].Value; v != nil {
+				t.Errorf("expected the token value to be dropped, found %v", v)
+			}
+			if decoded.curNode != decoded.RootNode {
+				t.Errorf("curNode was not reset to the root node")
+			}
+
+			// The decoded tree must be usable for further construction
+			decoded.addNode("EXTRA")
+			decoded.walkUp()
+			if n := len(decoded.RootNode.Children); n != 4 {
+				t.Errorf("Unexpected child count after addNode: got %d, want 4", n)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSExpr(t *testing.T) {
+	input := `; a hand written fixture
+(ROOT
+  (ASSIGNMENT (VARIABLE "a" 1:1) ; trailing comment
+    (TERMINAL (LITERAL "1") (:error 2 "found [x]" 1 6 1 6 "a := 1")))
+  ("QUOTED TYPE"))
+`
+	var a AST
+	if err := a.UnmarshalSExpr([]byte(input)); err != nil {
+		t.Fatalf("UnmarshalSExpr failed: %v", err)
+	}
+	assignment := a.RootNode.Children[0]
+	if assignment.Type != "ASSIGNMENT" || !reflect.DeepEqual(assignment.Tokens[0], ASTToken{ID: "VARIABLE", Literal: "a", Line: 1, Position: 1}) {
+		t.Errorf("Unexpected assignment node: %+v", assignment)
+	}
+	if !reflect.DeepEqual(assignment.Children[0].Tokens[0], ASTToken{ID: "LITERAL", Literal: "1"}) {
+		t.Errorf("Unexpected terminal token: %+v", assignment.Children[0].Tokens[0])
+	}
+	if err := assignment.Children[0].Errors[0]; err.Code != ErrorTokenExpectedNotFound || err.Message != "found [x]" || err.EndPosition != 6 {
+		t.Errorf("Unexpected terminal error: %+v", err)
+	}
+	if a.RootNode.Children[1].Type != "QUOTED TYPE" {
+		t.Errorf("Unexpected node type: got %q", a.RootNode.Children[1].Type)
+	}
+
+	for _, bad := range []string{"", "(ROOT", "ROOT", "(ROOT))", `(ROOT "a")`, "(ROOT (A) (B \"x\"))", `(ROOT (A "x" 1))`} {
+		if err := a.UnmarshalSExpr([]byte(bad)); err == nil {
+			t.Errorf("Expected an error decoding %q", bad)
+		}
+	}
+}
+
+func TestUnmarshalBinaryCorrupt(t *testing.T) {
+	data, _ := newTestAST().MarshalBinary()
+	var a AST
+	for i := 0; i < len(data); i++ {
+		if err := a.UnmarshalBinary(data[:i]); err == nil {
+			t.Errorf("Expected an error decoding %d of %d bytes", i, len(data))
+		}
+	}
+}
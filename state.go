@@ -0,0 +1,51 @@
+// state.go implements user state shared by the parse and scan functions of
+// a single parse. A grammar sometimes needs the parser to switch how the
+// scanner reads its input, such as skipping the rest of a line to recover
+// from an error. Keeping that switch in a package variable breaks as soon as
+// two parses run at once, so each parse carries its own state instead.
+
+package dsl
+
+// parseState holds the user state of a parse. The parser and the scanner
+// share the same parseState.
+type parseState struct {
+	value interface{}
+}
+
+// SetState stores a value for the rest of the parse, to be read by the
+// parse function with State and by the scan function with Scanner.State.
+// Tokens the parser has already read, such as peeked tokens, are not
+// scanned again, but tokens scanned ahead with WithPipeline are.
+func (p *Parser) SetState(v interface{}) {
+	p.pause()
+	if p.state == nil {
+		p.state = &parseState{}
+	}
+	p.state.value = v
+}
+
+// State returns the value stored with SetState, or nil.
+func (p *Parser) State() interface{} {
+	p.pause()
+	if p.state == nil {
+		return nil
+	}
+	return p.state.value
+}
+
+// SetState stores a value for the rest of the parse, to be read by the scan
+// function with State and by the parse function with Parser.State.
+func (s *Scanner) SetState(v interface{}) {
+	if s.state == nil {
+		s.state = &parseState{}
+	}
+	s.state.value = v
+}
+
+// State returns the value stored with SetState, or nil.
+func (s *Scanner) State() interface{} {
+	if s.state == nil {
+		return nil
+	}
+	return s.state.value
+}
@@ -0,0 +1,171 @@
+// stream.go implements push-style parsing of input that arrives in chunks,
+// such as documents received over a network stream. Parse pulls its input
+// from a bufio.Reader and blocks until the whole input is parsed, so the
+// StreamParser runs it on its own goroutine over a reader fed by Feed. The
+// two take turns: Feed hands a chunk to the parser and waits until the
+// parser has used all of it and asks for more, or returns. Only one of them
+// runs at any time, so the tree can be read between calls without locking.
+//
+// The scanner sees the end of the input only once Close is called. Until
+// then a parser waiting for input is reported as ErrNeedMoreInput.
+
+package dsl
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrNeedMoreInput is returned by StreamParser.Feed when the parser has used
+// every chunk fed so far and is waiting for more.
+var ErrNeedMoreInput = errors.New("dsl: need more input")
+
+// StreamParser parses input pushed to it in chunks with Feed. Close must be
+// called once the input ends, to finish the parse and release its
+// goroutine. A StreamParser must not be used from more than one goroutine at
+// a time.
+type StreamParser struct {
+	p       *Parser
+	chunks  chan []byte   // Hands chunks from Feed to the reader
+	waiting chan struct{} // Signals that the reader has run out of chunks
+	done    chan struct{} // Closed once the parse function returns
+	ast     AST
+	errs    []Error
+	flushed int // The number of top-level nodes already returned by Feed
+	closed  bool
+}
+
+// NewStreamParser returns a StreamParser ready to be fed the input. It takes
+// the same parse and scan functions and options as Parse.
+func NewStreamParser(pf ParseFunc, sf ScanFunc, opts ...ParseOption) *StreamParser {
+	sp := &StreamParser{
+		chunks:  make(chan []byte),
+		waiting: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	sp.p = setup(pf, sf, bufio.NewReader(&streamReader{sp: sp}), newParseConfig(opts))
+	go func() {
+		sp.ast, sp.errs = execute(sp.p)
+		close(sp.done)
+	}()
+	sp.wait()
+	return sp
+}
+
+// Feed passes the next chunk of input to the parser and returns once it has
+// been used. The top-level nodes finished since the last call are returned
+// as copies, without comments or trivia as they are attached once the parse
+// is complete. The error is ErrNeedMoreInput while the parser waits for the
+// next chunk and nil once the parse function has returned, after which any
+// further input is ignored.
+func (sp *StreamParser) Feed(data []byte) ([]Node, error) {
+	if sp.closed {
+		return nil, errors.New("dsl: feed after close")
+	}
+	if sp.finished() {
+		return sp.flush(), nil
+	}
+	if len(data) > 0 {
+		// The caller may reuse data once Feed returns
+		sp.chunks <- append([]byte(nil), data...)
+		if sp.wait() {
+			return sp.flush(), nil
+		}
+	}
+	return sp.flush(), ErrNeedMoreInput
+}
+
+// Close ends the input and returns the AST and errors of the whole parse,
+// as returned by Parse, including the nodes already returned by Feed.
+func (sp *StreamParser) Close() (AST, []Error) {
+	if !sp.closed {
+		sp.closed = true
+		close(sp.chunks)
+		<-sp.done
+	}
+	return sp.ast, sp.errs
+}
+
+// wait blocks until the parser asks for more input or the parse function
+// returns, reporting which.
+func (sp *StreamParser) wait() bool {
+	select {
+	case <-sp.waiting:
+		return false
+	case <-sp.done:
+		return true
+	}
+}
+
+func (sp *StreamParser) finished() bool {
+	select {
+	case <-sp.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// flush returns copies of the top-level nodes finished since the last call.
+// A node is finished once the parser has moved on to the next one, or back
+// to the root.
+//
+// flush reads the parser's tree without locking while the parse goroutine
+// is still running. That goroutine only hands control back to Feed from
+// streamReader.Read, which the scanner calls between reading tokens, never
+// while the parser is changing the tree, and it stays blocked there until
+// the next chunk or Close. The scanner is not pipelined onto a goroutine of
+// its own for the same reason.
+func (sp *StreamParser) flush() []Node {
+	a := &sp.p.ast
+	if sp.finished() {
+		a = &sp.ast
+	}
+	if a.RootNode == nil {
+		return nil
+	}
+	finished := len(a.RootNode.Children)
+	if a.curNode != a.RootNode && !sp.finished() && finished > 0 {
+		finished--
+	}
+
+	var nodes []Node
+	for i := sp.flushed; i < finished; i++ {
+		nodes = append(nodes, copyNode(&a.RootNode.Children[i]))
+	}
+	// The children are linked once the nodes are in place in the slice
+	for i := range nodes {
+		nodes[i].Parent = nil
+		linkParents(&nodes[i])
+	}
+	if finished > sp.flushed {
+		sp.flushed = finished
+	}
+	return nodes
+}
+
+// streamReader reads the chunks passed to Feed, asking for the next one
+// whenever it runs out, until Close.
+type streamReader struct {
+	sp   *StreamParser
+	data []byte
+	eof  bool
+}
+
+func (r *streamReader) Read(b []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		r.sp.waiting <- struct{}{}
+		chunk, ok := <-r.sp.chunks
+		if !ok {
+			r.eof = true
+		}
+		r.data = chunk
+	}
+	n := copy(b, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
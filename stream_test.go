@@ -0,0 +1,139 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStreamParser(t *testing.T) {
+	sp := NewStreamParser(cstParse, triviaScan)
+	for _, chunk := range []string{"say \"h", "i\"; by", "e"} {
+		if _, err := sp.Feed([]byte(chunk)); err != ErrNeedMoreInput {
+			t.Fatalf("%q: expected ErrNeedMoreInput, found %v", chunk, err)
+		}
+	}
+	if _, errs := sp.Close(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if _, err := sp.Feed([]byte(";")); err == nil {
+		t.Errorf("expected an error feeding a closed parser")
+	}
+}
+
+// statementWords returns the words of each statement built by nestedParse.
+func statementWords(nodes []Node) []string {
+	var found []string
+	for _, n := range nodes {
+		var words []string
+		for _, child := range n.Children {
+			for _, tok := range child.Tokens {
+				words = append(words, tok.Literal)
+			}
+		}
+		found = append(found, strings.Join(words, " "))
+	}
+	return found
+}
+
+func TestStreamParserFeed(t *testing.T) {
+	sp := NewStreamParser(nestedParse, triviaScan)
+
+	// A statement is returned by the Feed of the chunk completing it
+	chunks := []struct {
+		chunk    string
+		expected string
+	}{
+		{"say h", ""},
+		{"i there; by", "say hi there"},
+		{"e; go", "bye"},
+		{" now", ""},
+	}
+	for _, c := range chunks {
+		nodes, err := sp.Feed([]byte(c.chunk))
+		if err != ErrNeedMoreInput {
+			t.Fatalf("%q: expected ErrNeedMoreInput, found %v", c.chunk, err)
+		}
+		if found := strings.Join(statementWords(nodes), "|"); found != c.expected {
+			t.Errorf("%q: expected statements %q, found %q", c.chunk, c.expected, found)
+		}
+		for i := range nodes {
+			if nodes[i].Parent != nil || nodes[i].Children[0].Parent != &nodes[i] {
+				t.Errorf("%q: expected the nodes returned to be linked to their copies", c.chunk)
+			}
+		}
+	}
+
+	ast, errs := sp.Close()
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if found := statementWords(ast.RootNode.Children); strings.Join(found, "|") != "say hi there|bye|go now" {
+		t.Errorf("expected every statement in the AST, found %q", found)
+	}
+}
+
+// firstParse parses the first statement only, returning before the end of
+// the input.
+func firstParse(p *Parser) (AST, []Error) {
+	p.Expect(ExpectToken{Branches: []BranchToken{{Id: "WORD", Fn: func(p *Parser) {
+		p.AddNode("STATEMENT")
+		p.AddTokens()
+		p.Expect(ExpectToken{Branches: []BranchToken{{Id: "SEMI"}}, Options: ParseOptions{Skip: true}})
+		p.WalkUp()
+	}}}})
+	return p.Exit()
+}
+
+func TestStreamParserFinished(t *testing.T) {
+	sp := NewStreamParser(firstParse, triviaScan)
+	if nodes, err := sp.Feed([]byte("stop")); err != ErrNeedMoreInput || len(nodes) != 0 {
+		t.Fatalf("expected ErrNeedMoreInput and no nodes, found %v and %v", err, nodes)
+	}
+	nodes, err := sp.Feed([]byte("; ignored"))
+	if err != nil {
+		t.Fatalf("expected the parse to finish, found %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Tokens[0].Literal != "stop" {
+		t.Errorf("expected the statement, found %+v", nodes)
+	}
+
+	// Once the parse function has returned further input is ignored
+	if nodes, err = sp.Feed([]byte("more; input;")); err != nil || len(nodes) != 0 {
+		t.Errorf("expected no nodes and no error after the parse finished, found %v and %v", nodes, err)
+	}
+	ast, errs := sp.Close()
+	if len(errs) != 0 || len(ast.RootNode.Children) != 1 {
+		t.Errorf("expected one statement and no errors, found %d and %v", len(ast.RootNode.Children), errs)
+	}
+}
+
+func TestStreamParserFeedRunes(t *testing.T) {
+	// Every rune is fed on its own, so the parser asks for more input in the
+	// middle of each token and node
+	input := "say hi there; bye; go now"
+	sp := NewStreamParser(nestedParse, triviaScan)
+	var found []string
+	for _, rn := range input {
+		nodes, err := sp.Feed([]byte(string(rn)))
+		if err != ErrNeedMoreInput {
+			t.Fatalf("%q: expected ErrNeedMoreInput, found %v", rn, err)
+		}
+		for i := range nodes {
+			if nodes[i].Children[0].Parent != &nodes[i] || nodes[i].Children[0].Type != "WORDS" {
+				t.Errorf("%q: expected a statement linked to its words, found %+v", rn, nodes[i])
+			}
+		}
+		found = append(found, statementWords(nodes)...)
+	}
+	if strings.Join(found, "|") != "say hi there|bye" {
+		t.Errorf("expected the statements before the last, found %q", found)
+	}
+
+	ast, errs := sp.Close()
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if found := statementWords(ast.RootNode.Children); strings.Join(found, "|") != "say hi there|bye|go now" {
+		t.Errorf("expected every statement in the AST, found %q", found)
+	}
+}
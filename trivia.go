@@ -0,0 +1,133 @@
+// trivia.go implements lossless trivia retention. Scanners usually drop
+// whitespace and comments with the Skip option or SkipRune, so the source
+// text cannot be rebuilt from the tokens. With WithTrivia the scanner keeps
+// every skipped rune as Leading or Trailing trivia on the neighbouring
+// Token, and the parser carries it, along with the text of any token that
+// never made it into the AST, onto the neighbouring ASTToken. Concatenating
+// the tokens in order, each with its trivia, reproduces the input.
+//
+// Runes skipped between two kept runes of the same token, such as an escape
+// character, cannot be placed before or after its literal and are added to
+// its Trailing trivia instead. Such a scan function is not lossless.
+//
+// The rune(0) returned at the end of the input is not source text. It never
+// appears in trivia and is left out when a literal containing it is joined
+// back together by Text or Source.
+
+package dsl
+
+import (
+	"sort"
+	"strings"
+)
+
+// WithTrivia returns a ParseOption that keeps skipped runes as trivia on
+// the tokens returned by Tokenize and on the tokens in the AST.
+func WithTrivia() ParseOption {
+	return func(c *ParseConfig) {
+		c.Trivia = true
+	}
+}
+
+// Text returns the source text of the token, its literal surrounded by its
+// trivia.
+func (t Token) Text() string {
+	return t.Leading + sourceLiteral(t.Literal) + t.Trailing
+}
+
+// Text returns the source text of the token, its literal surrounded by its
+// trivia.
+func (t ASTToken) Text() string {
+	return t.Leading + sourceLiteral(t.Literal) + t.Trailing
+}
+
+// Source returns the source text of the tokens. For the tokens returned by
+// Tokenize with WithTrivia this is the input.
+func Source(tokens []Token) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		b.WriteString(tok.Text())
+	}
+	return b.String()
+}
+
+// Source returns the source text of the AST. For an AST parsed with
+// WithTrivia this is the input, as long as the AST holds at least one token.
+func (a AST) Source() string {
+	if a.RootNode == nil {
+		return ""
+	}
+	return a.RootNode.Source()
+}
+
+// Source returns the source text of the tokens of the node and all of its
+// descendants, in the order they were found in the source.
+func (n *Node) Source() string {
+	var b strings.Builder
+	for _, tok := range sourceTokens(n) {
+		b.WriteString(tok.Text())
+	}
+	return b.String()
+}
+
+// sourceTokens returns the tokens of the node and all of its descendants
+// ordered by where they were found in the source, as a parse function may
+// add a token to a node after the tokens of its children.
+func sourceTokens(n *Node) []*ASTToken {
+	var tokens []*ASTToken
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for i := range n.Tokens {
+			tokens = append(tokens, &n.Tokens[i])
+		}
+		for i := range n.Children {
+			walk(&n.Children[i])
+		}
+	}
+	walk(n)
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokens[i].Line != tokens[j].Line {
+			return tokens[i].Line < tokens[j].Line
+		}
+		return tokens[i].Position < tokens[j].Position
+	})
+	return tokens
+}
+
+// sourceLiteral removes the end of input rune from a literal.
+func sourceLiteral(literal string) string {
+	return strings.TrimRight(literal, "\x00")
+}
+
+// attachTrivia sets the trivia of the tokens in the AST from the tokens
+// scanned during the parse. Scanned tokens that are not in the AST, such as
+// skipped or peeked tokens, become Leading trivia of the next token in the
+// AST, and any left over at the end, along with rest, the input that was
+// never scanned, become Trailing trivia of the last.
+func attachTrivia(root *Node, scanned []Token, rest string) {
+	tokens := sourceTokens(root)
+
+	var pending strings.Builder
+	var last *ASTToken
+	i := 0
+	for _, tok := range scanned {
+		if i < len(tokens) && sameToken(*tokens[i], tok) {
+			last = tokens[i]
+			last.Leading = pending.String() + tok.Leading
+			last.Trailing = tok.Trailing
+			pending.Reset()
+			i++
+			continue
+		}
+		pending.WriteString(tok.Text())
+	}
+	pending.WriteString(rest)
+
+	if last != nil {
+		last.Trailing += pending.String()
+	}
+}
+
+func sameToken(a ASTToken, t Token) bool {
+	return a.ID == t.ID && a.Literal == t.Literal && a.Line == t.Line && a.Position == t.Position
+}
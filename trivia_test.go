@@ -0,0 +1,124 @@
+package dsl
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// triviaScan scans words, quoted strings and semicolons, skipping
+// whitespace with the Skip option and quotes with SkipRune.
+func triviaScan(s *Scanner) Token {
+	s.Expect(ExpectRune{
+		Branches: []Branch{{Rn: ' '}, {Rn: '\n'}},
+		Options:  ExpectRuneOptions{Optional: true, Multiple: true, Skip: true},
+	})
+	s.Expect(ExpectRune{
+		Branches: []Branch{
+			{Rn: ';', Fn: func(s *Scanner) { s.Match([]Match{{Literal: ";", ID: "SEMI"}}) }},
+			{Rn: '"', Fn: func(s *Scanner) {
+				s.SkipRune()
+				s.ExpectNot(ExpectNotRune{Runes: []rune{'"', rune(0)}, Options: ExpectRuneOptions{Optional: true, Multiple: true}})
+				s.Expect(ExpectRune{Branches: []Branch{{Rn: '"', Fn: func(s *Scanner) { s.SkipRune() }}}})
+				s.Match([]Match{{ID: "STRING"}})
+			}},
+			{Rn: rune(0), Fn: func(s *Scanner) { s.Match([]Match{{ID: TOKEN_EOF}}) }},
+		},
+		BranchRanges: []BranchRange{
+			{StartRn: 'a', EndRn: 'z', Fn: func(s *Scanner) {
+				s.Expect(ExpectRune{BranchRanges: []BranchRange{{StartRn: 'a', EndRn: 'z'}}, Options: ExpectRuneOptions{Optional: true, Multiple: true}})
+				s.Match([]Match{{ID: "WORD"}})
+			}},
+		},
+	})
+	return s.Exit()
+}
+
+// triviaParse adds every word and string to a statement, skipping the
+// semicolons between them.
+func triviaParse(p *Parser) (AST, []Error) {
+	p.AddNode("STATEMENT")
+	p.Expect(ExpectToken{
+		Branches: []BranchToken{
+			{Id: "WORD", Fn: func(p *Parser) { p.AddTokens() }},
+			{Id: "STRING", Fn: func(p *Parser) { p.AddTokens() }},
+			{Id: "SEMI", Fn: func(p *Parser) { p.SkipToken() }},
+		},
+		Options: ParseOptions{Multiple: true},
+	})
+	return p.ast, p.errors
+}
+
+func TestTriviaTokenize(t *testing.T) {
+	input := "say \"hi there\" ;\n  bye \"unterminated"
+	tokens, _ := Tokenize(triviaScan, bufio.NewReader(strings.NewReader(input)), WithTrivia())
+
+	expected := []Token{
+		{ID: "WORD", Literal: "say", Line: 1, Position: 1},
+		{ID: "STRING", Literal: "hi there", Line: 1, Position: 6, Leading: " \"", Trailing: "\""},
+		{ID: "SEMI", Literal: ";", Line: 1, Position: 16, Leading: " "},
+		{ID: "WORD", Literal: "bye", Line: 2, Position: 3, Leading: "\n  "},
+		{ID: "STRING", Literal: "unterminated", Line: 2, Position: 8, Leading: " \""},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, found %d: %+v", len(expected), len(tokens), tokens)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(tokens[i], expected[i]) {
+			t.Errorf("token %d:\nexpected %+v\nfound    %+v", i, expected[i], tokens[i])
+		}
+	}
+	if source := Source(tokens); source != input {
+		t.Errorf("Source does not reproduce the input:\nexpected %q\nfound    %q", input, source)
+	}
+
+	// Without WithTrivia the skipped runes are dropped as before
+	tokens, _ = Tokenize(triviaScan, bufio.NewReader(strings.NewReader(input)))
+	if tokens[1].Leading != "" || tokens[1].Trailing != "" {
+		t.Errorf("expected no trivia without WithTrivia, found %+v", tokens[1])
+	}
+}
+
+func TestTriviaParse(t *testing.T) {
+	input := " one;\"two\" ; three four\n \"five\" ; ;"
+	ast, errs := Parse(triviaParse, triviaScan, bufio.NewReader(strings.NewReader(input)), WithTrivia())
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// The skipped semicolons become Leading trivia of the next token in the
+	// AST and those after the last token become its Trailing trivia.
+	tokens := ast.RootNode.Children[0].Tokens
+	expected := []ASTToken{
+		{ID: "WORD", Literal: "one", Line: 1, Position: 2, Leading: " "},
+		{ID: "STRING", Literal: "two", Line: 1, Position: 7, Leading: ";\"", Trailing: "\""},
+		{ID: "WORD", Literal: "three", Line: 1, Position: 14, Leading: " ; "},
+		{ID: "WORD", Literal: "four", Line: 1, Position: 20, Leading: " "},
+		{ID: "STRING", Literal: "five", Line: 2, Position: 3, Leading: "\n \"", Trailing: "\" ; ;"},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, found %d: %+v", len(expected), len(tokens), tokens)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(tokens[i], expected[i]) {
+			t.Errorf("token %d:\nexpected %+v\nfound    %+v", i, expected[i], tokens[i])
+		}
+	}
+	if source := ast.Source(); source != input {
+		t.Errorf("Source does not reproduce the input:\nexpected %q\nfound    %q", input, source)
+	}
+}
+
+func TestTriviaParseError(t *testing.T) {
+	// The scanner stops at the unexpected rune, so the rest of the input is
+	// never scanned but is still kept as Trailing trivia of the last token.
+	input := "one two ! three"
+	ast, errs := Parse(triviaParse, triviaScan, bufio.NewReader(strings.NewReader(input)), WithTrivia())
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, found %v", errs)
+	}
+	if source := ast.Source(); source != input {
+		t.Errorf("Source does not reproduce the input:\nexpected %q\nfound    %q", input, source)
+	}
+}
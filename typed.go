@@ -0,0 +1,129 @@
+// typed.go implements typed parsing, where the rules of the grammar return
+// the values they parse rather than adding nodes to an AST. A rule is a
+// function of the Parser returning a value of any type, such as a struct of
+// the user's own AST, and ParseTyped returns the value of the first rule:
+//
+//	func statement(p *dsl.Parser) Statement {
+//		p.Expect(dsl.ExpectToken{Branches: []dsl.BranchToken{{Id: "VARIABLE"}}})
+//		name := p.GetToken().Literal
+//		values := dsl.Expect(p, dsl.ExpectTyped[Expr]{
+//			Branches: []dsl.BranchTyped[Expr]{{Id: "NUMBER", Fn: number}, {Id: "STRING", Fn: str}},
+//		})
+//		...
+//	}
+//
+// Expect and Call are the typed forms of Parser.Expect and Parser.Call,
+// returning the values of the rules they call, so each rule builds its value
+// from those of the rules below it and the compiler checks that the types
+// fit together. The methods of the Parser may be used alongside them, to
+// read tokens with no value of their own or to recover from errors.
+//
+// No Node tree is built, so AddNode, AddTokens and WalkUp do nothing and the
+// options shaping the AST, such as WithComments and WithEvents, have no
+// effect. WithCST still builds a concrete syntax tree, its nodes named after
+// the rules.
+
+package dsl
+
+import (
+	"bufio"
+)
+
+// ExpectTyped is the input of Expect, as ExpectToken is of Parser.Expect.
+type ExpectTyped[T any] struct {
+	Branches []BranchTyped[T]
+	Options  ParseOptions
+}
+
+// BranchTyped is a branch of ExpectTyped, taken when the next token has the
+// type Id. Fn parses the rest of the branch and returns its value, or may be
+// nil for a branch with no value.
+type BranchTyped[T any] struct {
+	Id TokenType
+	Fn func(*Parser) T
+}
+
+// ParseTyped parses the input with the rule, returning the value it returns
+// along with any errors. Options are applied as they are by Parse.
+func ParseTyped[T any](rule func(*Parser) T, sf ScanFunc, r *bufio.Reader, opts ...ParseOption) (T, []Error) {
+	config := newParseConfig(opts)
+	return executeTyped(pipelined(setup(nil, sf, r, config), config), rule)
+}
+
+// ParseStringTyped parses the input held in src with the rule, reading it in
+// place as ParseString does.
+func ParseStringTyped[T any](rule func(*Parser) T, sf ScanFunc, src string, opts ...ParseOption) (T, []Error) {
+	config := newParseConfig(opts)
+	logger := config.logger()
+	return executeTyped(pipelined(setupScanner(nil, newStringScanner(sf, src, logger), logger, config), config), rule)
+}
+
+// executeTyped runs the rule in place of a parse function, with an AST
+// without a root so that no tree is built.
+func executeTyped[T any](p *Parser, rule func(*Parser) T) (T, []Error) {
+	p.ast = AST{}
+	p.trivia = false // No tree to attach the trivia of the tokens scanned to
+	p.log("Line 1: ", prefixNone)
+	if p.trace {
+		p.log("Parsing: "+getFuncName(rule), prefixNewline)
+	}
+	p.cstEnter(rule)
+	v := rule(p)
+	p.cstLeave()
+	p.cstFlush()
+	if p.trace {
+		p.log("Returning: "+getFuncName(rule), prefixDecrement)
+	}
+	p.stop(&p.ast)
+	return v, p.errors
+}
+
+// Expect reads tokens as Parser.Expect does, calling the Fn of each branch
+// taken and returning the values they return, in order. At most one value is
+// returned unless the Multiple option is set, and none if no branch with a
+// Fn is taken, such as when an error has been found.
+//
+// Tokens read while a branch is parsed are dropped once its Fn returns, so
+// Parser.GetToken returns the token of the branch only within its Fn.
+func Expect[T any](p *Parser, expect ExpectTyped[T]) []T {
+	branches := make([]BranchToken, len(expect.Branches))
+	for i, branch := range expect.Branches {
+		branches[i].Id = branch.Id
+	}
+	var site *CoverageSite
+	if p.cov != nil {
+		site = p.cov.site(coverParserExpect, branchTokenCoverage(branches))
+	}
+
+	var values []T
+	held := len(p.tokens)
+	p.expect(ExpectToken{Branches: branches, Options: expect.Options}, site, func(i int) {
+		fn := expect.Branches[i].Fn
+		if p.enterFn(fn, fn != nil) {
+			values = append(values, fn(p))
+			p.leaveFn(fn)
+		}
+		p.tokens = p.tokens[:min(held, len(p.tokens))]
+	})
+	return values
+}
+
+// Call calls the rule as Parser.Call does, returning its value. The zero
+// value is returned if the parser has read TOKEN_EOF.
+func Call[T any](p *Parser, rule func(*Parser) T) T {
+	var v T
+	if rule != nil && !p.eof {
+		if p.trace {
+			p.log("Calling: "+getFuncName(rule), prefixIncrement)
+		}
+		held := len(p.tokens)
+		p.cstEnter(rule)
+		v = rule(p)
+		p.cstLeave()
+		p.tokens = p.tokens[:min(held, len(p.tokens))]
+		if p.trace {
+			p.log("Returning: "+getFuncName(rule), prefixDecrement)
+		}
+	}
+	return v
+}
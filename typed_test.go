@@ -0,0 +1,91 @@
+package dsl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type typedStatement struct {
+	Words []string
+}
+
+// typedStatements parses the same statements as cstParse, returning the
+// words of each in place of an AST.
+func typedStatements(p *Parser) []typedStatement {
+	return Expect(p, ExpectTyped[typedStatement]{
+		Branches: []BranchTyped[typedStatement]{
+			{Id: "WORD", Fn: typedStatementRule},
+			{Id: "SEMI"},
+		},
+		Options: ParseOptions{Multiple: true},
+	})
+}
+
+func typedStatementRule(p *Parser) typedStatement {
+	p.AddNode("IGNORED") // Typed parses build no tree
+	s := typedStatement{Words: []string{p.GetToken().Literal}}
+	s.Words = append(s.Words, Call(p, typedWords)...)
+	p.Expect(ExpectToken{Branches: []BranchToken{{Id: "SEMI"}, {Id: TOKEN_EOF}}, Options: ParseOptions{Skip: true}})
+	p.WalkUp()
+	return s
+}
+
+func typedWords(p *Parser) []string {
+	return Expect(p, ExpectTyped[string]{
+		Branches: []BranchTyped[string]{
+			{Id: "WORD", Fn: func(p *Parser) string { return p.GetToken().Literal }},
+			{Id: "STRING", Fn: func(p *Parser) string { return `"` + p.GetToken().Literal + `"` }},
+		},
+		Options: ParseOptions{Multiple: true, Optional: true},
+	})
+}
+
+func TestParseTyped(t *testing.T) {
+	input := "say \"hi\" there ; bye;"
+	var cst AST
+	c := NewCoverage()
+	found, errs := ParseStringTyped(typedStatements, triviaScan, input, WithCST(&cst), WithCoverage(c))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := []typedStatement{{Words: []string{"say", `"hi"`, "there"}}, {Words: []string{"bye"}}}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, found %v", expected, found)
+	}
+
+	want := `(ROOT
+  (typedStatements
+    (typedStatementRule (WORD "say" 1:1) (SEMI ";" 1:16)
+      (typedWords
+        (typedWords.func2 (STRING "hi" 1:6))
+        (typedWords.func1 (WORD "there" 1:10))))
+    (typedStatementRule (WORD "bye" 1:18) (SEMI ";" 1:21)
+      (typedWords))))
+`
+	if got, _ := cst.MarshalSExpr(); string(got) != want {
+		t.Errorf("unexpected CST:\nexpected %s\nfound    %s", want, got)
+	}
+
+	// Coverage is recorded where Expect is called, as for Parser.Expect
+	hits := make(map[string]int)
+	for _, site := range c.Sites() {
+		if site.Kind == coverParserExpect && !strings.HasSuffix(site.File, "typed_test.go") {
+			t.Errorf("%v call site in unexpected file %v", site.Kind, site.File)
+		}
+		for _, branch := range site.Branches {
+			hits[site.Func+" "+branch.Label] += branch.Hits
+		}
+	}
+	if hits["typedStatements WORD"] != 2 || hits["typedWords STRING"] != 1 || hits["typedWords WORD"] != 1 {
+		t.Errorf("unexpected coverage %v", hits)
+	}
+
+	found, errs = ParseStringTyped(typedStatements, triviaScan, "\"hi\" say;")
+	if len(errs) != 1 || errs[0].Code != ErrorTokenExpectedNotFound {
+		t.Errorf("expected an error, found %v", errs)
+	}
+	if found != nil {
+		t.Errorf("expected no statements, found %v", found)
+	}
+}